@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/kubernetes-csi/csi-driver-smb/pkg/smb"
 	"k8s.io/component-base/metrics/legacyregistry"
@@ -50,8 +51,37 @@ var (
 	defaultOnDeletePolicy         = flag.String("default-ondelete-policy", "", "default policy for deleting subdirectory when deleting a volume")
 	removeArchivedVolumePath      = flag.Bool("remove-archived-volume-path", true, "remove archived volume path in DeleteVolume")
 	enableWindowsHostProcess      = flag.Bool("enable-windows-host-process", false, "enable windows host process")
+	healthAddress                 = flag.String("health-address", "", "export /healthz and /readyz endpoints, these endpoints are also served on metrics-address")
+	checkKrb5CacheDirectory       = flag.Bool("check-krb5-cache-directory", false, "check whether krb5-cache-directory exists in readiness check, set it to true if kerberos is in use")
+	tlsCertFile                   = flag.String("tls-cert-file", "", "server certificate file in PEM format for tcp:// endpoint, certificate is reloaded when the file changes")
+	tlsKeyFile                    = flag.String("tls-key-file", "", "server private key file in PEM format for tcp:// endpoint")
+	tlsClientCAFile               = flag.String("tls-client-ca-file", "", "CA file in PEM format to verify client certificates, mutual TLS is required if it's set")
+	volumeLockWaitTimeout         = flag.Duration("volume-lock-wait-timeout", 30*time.Second, "max time to wait for a volume lock held by another operation before returning Aborted, it's also bounded by half of the request deadline, 0 means not waiting")
+	maxConcurrentMountsPerServer  = flag.Int("max-concurrent-mounts-per-server", 0, "max number of concurrent mounts against one SMB server on node, 0 means unlimited")
+	mountQPSPerServer             = flag.Float64("mount-qps-per-server", 0, "max number of mounts started per second against one SMB server on node, 0 means unlimited")
+	mountBurstPerServer           = flag.Int("mount-burst-per-server", 0, "max burst of mounts against one SMB server on node, only applies when mount-qps-per-server is set")
+	maxConcurrentControllerOps    = flag.Int("max-concurrent-controller-ops-per-server", 0, "max number of concurrent internal mount, mkdir, delete and copy operations against one SMB server in controller, 0 means unlimited")
+	controllerOpQPSPerServer      = flag.Float64("controller-op-qps-per-server", 0, "max number of internal mount, mkdir, delete and copy operations started per second against one SMB server in controller, 0 means unlimited")
+	controllerOpBurstPerServer    = flag.Int("controller-op-burst-per-server", 0, "max burst of controller operations against one SMB server, only applies when controller-op-qps-per-server is set")
+	debugAddress                  = flag.String("debug-address", "", "export /debug/state endpoint with driver internal state (volume locks, pending mounts, caches), disabled by default")
+	enableDebugAdmin              = flag.Bool("enable-debug-admin", false, "allow admin actions (force release volume lock, evict cache entry, list archives) on debug-address")
+	shareBackend                  = flag.String("share-backend", "", "backend to create a share per volume on SMB server when sharePath is set in storage class, supported value: samba-netconf, empty means disabled")
+	userBackend                   = flag.String("user-backend", "", "backend to create a SMB user per volume when perVolumeUser is set in storage class, supported value: samba-smbpasswd, empty means disabled")
+	serverBackendSSHTarget        = flag.String("server-backend-ssh-target", "", "ssh target (user@host) to run share and user backend commands on, commands are run locally if empty")
+	serverBackendSSHKeyFile       = flag.String("server-backend-ssh-key-file", "", "ssh private key file used to connect to server-backend-ssh-target")
+	archiveGCInterval             = flag.Duration("archive-gc-interval", 0, "interval of pruning archives which exceed archiveMaxAge or archiveMaxCount of storage classes in controller, 0 means disabled")
+	protectedPaths                = flag.String("protected-paths", "", "comma separated path patterns of subDir which DeleteVolume never deletes or archives, e.g. home,projects/*")
+	allowDeleteWithoutMarker      = flag.Bool("allow-delete-without-volume-marker", false, "allow DeleteVolume to delete or archive directories without volume marker, e.g. volumes created by an older driver during migration")
+	topologyKeys                  = flag.String("topology-keys", "", "comma separated node label keys reported as topology segments of node, e.g. topology.kubernetes.io/zone, topology is disabled if both topology-keys and topology-segments are empty")
+	topologySegments              = flag.String("topology-segments", "", "comma separated key=value topology segments of node, they take precedence over node labels of topology-keys")
+	asyncDeleteWorkers            = flag.Int("async-delete-workers", 0, "number of workers deleting subdirectories moved to trash by DeleteVolume in background in controller, 0 means subdirectories are deleted in DeleteVolume")
 )
 
+// readinessChecker checks whether the driver is ready to serve requests
+type readinessChecker interface {
+	CheckReadiness(ctx context.Context) error
+}
+
 // exit is a separate function to handle program termination
 var exit = func(code int) {
 	os.Exit(code)
@@ -65,12 +95,17 @@ func main() {
 			klog.Fatalln(err)
 		}
 		fmt.Println(info) // nolint
+	} else if args := flag.Args(); len(args) > 0 && args[0] == volumeIDCommand {
+		if err := runVolumeIDCommand(args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err) // nolint
+			exit(1)
+			return
+		}
 	} else {
 		if *nodeID == "" {
 			// nodeid is not needed in controller component
 			klog.Warning("nodeid is empty")
 		}
-		exportMetrics()
 		handle()
 	}
 	exit(0)
@@ -89,12 +124,46 @@ func handle() {
 		Krb5Prefix:                    *krb5Prefix,
 		DefaultOnDeletePolicy:         *defaultOnDeletePolicy,
 		EnableWindowsHostProcess:      *enableWindowsHostProcess,
+		CheckKrb5CacheDirectory:       *checkKrb5CacheDirectory,
+		TLSCertFile:                   *tlsCertFile,
+		TLSKeyFile:                    *tlsKeyFile,
+		TLSClientCAFile:               *tlsClientCAFile,
+		VolumeLockWaitTimeout:         *volumeLockWaitTimeout,
+		MountLimits: smb.ServerLimitOptions{
+			MaxConcurrency: *maxConcurrentMountsPerServer,
+			QPS:            *mountQPSPerServer,
+			Burst:          *mountBurstPerServer,
+		},
+		ControllerOpLimits: smb.ServerLimitOptions{
+			MaxConcurrency: *maxConcurrentControllerOps,
+			QPS:            *controllerOpQPSPerServer,
+			Burst:          *controllerOpBurstPerServer,
+		},
+		ServerBackend: smb.ServerBackendOptions{
+			ShareBackend: *shareBackend,
+			UserBackend:  *userBackend,
+			SSHTarget:    *serverBackendSSHTarget,
+			SSHKeyFile:   *serverBackendSSHKeyFile,
+		},
+		ArchiveGCInterval:              *archiveGCInterval,
+		AsyncDeleteWorkers:             *asyncDeleteWorkers,
+		ProtectedPaths:                 parseProtectedPaths(*protectedPaths),
+		TopologyKeys:                   parseTopologyKeys(*topologyKeys),
+		AllowDeleteWithoutVolumeMarker: *allowDeleteWithoutMarker,
 	}
+	segments, err := parseTopologySegments(*topologySegments)
+	if err != nil {
+		klog.Fatalf("%v", err)
+	}
+	driverOptions.TopologySegments = segments
 	driver := smb.NewDriver(&driverOptions)
+	exportMetrics(driver)
+	exportHealth(driver)
+	exportDebug(driver)
 	driver.Run(*endpoint, *kubeconfig, false)
 }
 
-func exportMetrics() {
+func exportMetrics(checker readinessChecker) {
 	if *metricsAddress == "" {
 		return
 	}
@@ -103,12 +172,45 @@ func exportMetrics() {
 		klog.Warningf("failed to get listener for metrics endpoint: %v", err)
 		return
 	}
-	serve(context.Background(), l, serveMetrics)
+	serve(context.Background(), l, func(l net.Listener) error {
+		return serveMetrics(l, checker)
+	})
+}
+
+func exportHealth(checker readinessChecker) {
+	if *healthAddress == "" {
+		return
+	}
+	l, err := net.Listen("tcp", *healthAddress)
+	if err != nil {
+		klog.Warningf("failed to get listener for health endpoint: %v", err)
+		return
+	}
+	serve(context.Background(), l, func(l net.Listener) error {
+		return serveHealth(l, checker)
+	})
+}
+
+func exportDebug(driver *smb.Driver) {
+	if *debugAddress == "" {
+		return
+	}
+	l, err := net.Listen("tcp", *debugAddress)
+	if err != nil {
+		klog.Warningf("failed to get listener for debug endpoint: %v", err)
+		return
+	}
+	if *enableDebugAdmin {
+		klog.Warningf("admin actions are enabled on debug endpoint %s", *debugAddress)
+	}
+	serve(context.Background(), l, func(l net.Listener) error {
+		return trapClosedConnErr(http.Serve(l, driver.DebugHandler(*enableDebugAdmin)))
+	})
 }
 
 func serve(_ context.Context, l net.Listener, serveFunc func(net.Listener) error) {
 	path := l.Addr().String()
-	klog.V(2).Infof("set up prometheus server on %v", path)
+	klog.V(2).Infof("set up http server on %v", path)
 	go func() {
 		defer l.Close()
 		if err := serveFunc(l); err != nil {
@@ -117,12 +219,38 @@ func serve(_ context.Context, l net.Listener, serveFunc func(net.Listener) error
 	}()
 }
 
-func serveMetrics(l net.Listener) error {
+func serveMetrics(l net.Listener, checker readinessChecker) error {
 	m := http.NewServeMux()
 	m.Handle("/metrics", legacyregistry.Handler())
+	registerHealthHandlers(m, checker)
 	return trapClosedConnErr(http.Serve(l, m))
 }
 
+func serveHealth(l net.Listener, checker readinessChecker) error {
+	m := http.NewServeMux()
+	registerHealthHandlers(m, checker)
+	return trapClosedConnErr(http.Serve(l, m))
+}
+
+// registerHealthHandlers registers /healthz and /readyz handlers:
+// /healthz reports whether the process is alive,
+// /readyz reports whether all the dependencies of the driver are available.
+func registerHealthHandlers(m *http.ServeMux, checker readinessChecker) {
+	m.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	m.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := checker.CheckReadiness(r.Context()); err != nil {
+			klog.Warningf("readiness check failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
 func trapClosedConnErr(err error) error {
 	if err == nil {
 		return nil
@@ -132,3 +260,44 @@ func trapClosedConnErr(err error) error {
 	}
 	return err
 }
+
+// parseProtectedPaths splits comma separated path patterns
+func parseProtectedPaths(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.Trim(strings.TrimSpace(pattern), "/"); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// parseTopologyKeys splits comma separated node label keys
+func parseTopologyKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseTopologySegments parses comma separated key=value topology segments
+func parseTopologySegments(value string) (map[string]string, error) {
+	var segments map[string]string
+	for _, segment := range strings.Split(value, ",") {
+		if segment = strings.TrimSpace(segment); segment == "" {
+			continue
+		}
+		k, v, found := strings.Cut(segment, "=")
+		if !found || k == "" || v == "" {
+			return nil, fmt.Errorf("topology segment %q should be in key=value format", segment)
+		}
+		if segments == nil {
+			segments = map[string]string{}
+		}
+		segments[k] = v
+	}
+	return segments, nil
+}
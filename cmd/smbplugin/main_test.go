@@ -17,8 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
@@ -80,3 +84,148 @@ func TestTrapClosedConnErr(t *testing.T) {
 		}
 	}
 }
+
+func TestParseProtectedPaths(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{
+			value:    "",
+			expected: nil,
+		},
+		{
+			value:    "home, /projects/*/ ,,",
+			expected: []string{"home", "projects/*"},
+		},
+	}
+
+	for _, test := range tests {
+		patterns := parseProtectedPaths(test.value)
+		if !reflect.DeepEqual(patterns, test.expected) {
+			t.Errorf("Expected patterns %v, but got %v", test.expected, patterns)
+		}
+	}
+}
+
+func TestParseTopology(t *testing.T) {
+	if keys := parseTopologyKeys(" topology.kubernetes.io/zone, ,topology.kubernetes.io/region"); !reflect.DeepEqual(keys,
+		[]string{"topology.kubernetes.io/zone", "topology.kubernetes.io/region"}) {
+		t.Errorf("unexpected topology keys %v", keys)
+	}
+	if keys := parseTopologyKeys(""); keys != nil {
+		t.Errorf("expected no topology keys, got %v", keys)
+	}
+
+	tests := []struct {
+		value       string
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{value: "", expected: nil},
+		{value: "topology.kubernetes.io/zone=zone-a, site=dc1", expected: map[string]string{"topology.kubernetes.io/zone": "zone-a", "site": "dc1"}},
+		{value: "zone-a", expectedErr: true},
+		{value: "site=", expectedErr: true},
+	}
+	for _, test := range tests {
+		segments, err := parseTopologySegments(test.value)
+		if test.expectedErr != (err != nil) {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+		}
+		if !reflect.DeepEqual(segments, test.expected) {
+			t.Errorf("%q: expected segments %v, got %v", test.value, test.expected, segments)
+		}
+	}
+}
+
+type fakeReadinessChecker struct {
+	err error
+}
+
+func (f *fakeReadinessChecker) CheckReadiness(_ context.Context) error {
+	return f.err
+}
+
+func TestRegisterHealthHandlers(t *testing.T) {
+	tests := []struct {
+		path         string
+		readinessErr error
+		expectedCode int
+	}{
+		{
+			path:         "/healthz",
+			expectedCode: http.StatusOK,
+		},
+		{
+			path:         "/healthz",
+			readinessErr: fmt.Errorf("mount.cifs not found"),
+			expectedCode: http.StatusOK,
+		},
+		{
+			path:         "/readyz",
+			expectedCode: http.StatusOK,
+		},
+		{
+			path:         "/readyz",
+			readinessErr: fmt.Errorf("mount.cifs not found"),
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		m := http.NewServeMux()
+		registerHealthHandlers(m, &fakeReadinessChecker{err: test.readinessErr})
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.expectedCode {
+			t.Errorf("path %s with readiness error %v: expected code %d, got %d", test.path, test.readinessErr, test.expectedCode, rec.Code)
+		}
+	}
+}
+
+func TestRunVolumeIDCommand(t *testing.T) {
+	tests := []struct {
+		desc        string
+		args        []string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			desc:     "decode legacy volume id",
+			args:     []string{"decode", "smb-server/share#pvc-1#pv-1#retain"},
+			expected: "{\n  \"version\": 1,\n  \"source\": \"//smb-server/share\",\n  \"subDir\": \"pvc-1\",\n  \"uuid\": \"pv-1\",\n  \"onDelete\": \"retain\"\n}\n",
+		},
+		{
+			desc:     "encode versioned volume id",
+			args:     []string{"encode", "-source", "//smb-server/share", "-subdir", "team#1", "-uuid", "pv-1", "-attribute", "backend=samba"},
+			expected: "v2:backend=samba&source=smb-server%2Fshare&subdir=team%231&uuid=pv-1\n",
+		},
+		{
+			desc:     "encode legacy volume id",
+			args:     []string{"encode", "-version", "1", "-source", "//smb-server/share", "-subdir", "pvc-1", "-uuid", "pv-1"},
+			expected: "smb-server/share#pvc-1#pv-1#\n",
+		},
+		{desc: "legacy volume id could not encode separator", args: []string{"encode", "-version", "1", "-source", "//smb-server/share", "-subdir", "team#1"}, expectedErr: true},
+		{desc: "invalid attribute", args: []string{"encode", "-source", "//smb-server/share", "-attribute", "backend"}, expectedErr: true},
+		{desc: "invalid volume id", args: []string{"decode", "v3:source=smb-server"}, expectedErr: true},
+		{desc: "missing volume id", args: []string{"decode"}, expectedErr: true},
+		{desc: "unknown command", args: []string{"convert"}, expectedErr: true},
+		{desc: "no command", args: nil, expectedErr: true},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := runVolumeIDCommand(test.args, &out)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got output %q", test.desc, out.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("%s: expected output %q, got %q", test.desc, test.expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kubernetes-csi/csi-driver-smb/pkg/smb"
+)
+
+const (
+	volumeIDCommand = "volume-id"
+	volumeIDUsage   = `usage:
+  smbplugin volume-id decode <volume-id>...
+  smbplugin volume-id encode -source //server/share [-version 2] [-subdir dir] [-uuid pv] [-ondelete retain|archive]
+                             [-share share] [-volume-user namespace/user] [-attribute key=value]...`
+)
+
+// attributesFlag collects repeated key=value flags
+type attributesFlag map[string]string
+
+func (a attributesFlag) String() string {
+	pairs := make([]string, 0, len(a))
+	for k, v := range a {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (a attributesFlag) Set(value string) error {
+	k, v, found := strings.Cut(value, "=")
+	if !found || k == "" {
+		return fmt.Errorf("attribute %q should be in key=value format", value)
+	}
+	a[k] = v
+	return nil
+}
+
+// runVolumeIDCommand decodes volume ids into JSON or encodes a volume id from flags, the result is written to out
+func runVolumeIDCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", volumeIDUsage)
+	}
+	switch args[0] {
+	case "decode":
+		if len(args) < 2 {
+			return fmt.Errorf("%s", volumeIDUsage)
+		}
+		for _, id := range args[1:] {
+			info, err := smb.DecodeVolumeID(id)
+			if err != nil {
+				return fmt.Errorf("failed to decode volume id %q: %v", id, err)
+			}
+			data, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data)) // nolint
+		}
+		return nil
+	case "encode":
+		info := &smb.VolumeIDInfo{}
+		attributes := attributesFlag{}
+		var volumeUser string
+		fs := flag.NewFlagSet(volumeIDCommand+" encode", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.IntVar(&info.Version, "version", 2, "version of volume id, 1 is the legacy format")
+		fs.StringVar(&info.Source, "source", "", "source of the volume, e.g. //smb-server/share")
+		fs.StringVar(&info.SubDir, "subdir", "", "sub directory of the volume")
+		fs.StringVar(&info.UUID, "uuid", "", "PV name of the volume")
+		fs.StringVar(&info.OnDelete, "ondelete", "", "onDelete policy of the volume: retain or archive, empty means delete")
+		fs.StringVar(&info.Share, "share", "", "share created by share backend for the volume")
+		fs.StringVar(&volumeUser, "volume-user", "", "user created by user backend for the volume in namespace/user format")
+		fs.Var(attributes, "attribute", "extra attribute in key=value format, could be repeated")
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("%v\n%s", err, volumeIDUsage)
+		}
+		if volumeUser != "" {
+			namespace, user, found := strings.Cut(volumeUser, "/")
+			if !found {
+				return fmt.Errorf("volume user %q should be in namespace/user format", volumeUser)
+			}
+			info.VolumeUserSecretNamespace, info.VolumeUser = namespace, user
+		}
+		if len(attributes) > 0 {
+			info.Attributes = attributes
+		}
+		id, err := smb.EncodeVolumeID(info)
+		if err != nil {
+			return fmt.Errorf("failed to encode volume id: %v", err)
+		}
+		fmt.Fprintln(out, id) // nolint
+		return nil
+	}
+	return fmt.Errorf("unknown %s command %q\n%s", volumeIDCommand, args[0], volumeIDUsage)
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/klog/v2"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -45,10 +46,17 @@ func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
 	return &nonBlockingGRPCServer{}
 }
 
+// NewNonBlockingGRPCServerWithTLS creates a server which serves tcp:// endpoint over TLS
+// if tlsOptions is enabled, unix:// endpoint is always served in plain text.
+func NewNonBlockingGRPCServerWithTLS(tlsOptions *TLSOptions) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{tlsOptions: tlsOptions}
+}
+
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg         sync.WaitGroup
+	server     *grpc.Server
+	tlsOptions *TLSOptions
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
@@ -92,6 +100,18 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	opts := []grpc.ServerOption{
 		grpc.UnaryInterceptor(logGRPC),
 	}
+	if s.tlsOptions.Enabled() {
+		if proto == "tcp" {
+			reloader, err := newCertReloader(*s.tlsOptions)
+			if err != nil {
+				klog.Fatalf("Failed to set up TLS: %v", err)
+			}
+			opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.tlsConfig())))
+			klog.V(2).Infof("serving %s over TLS, client certificate required: %v", endpoint, s.tlsOptions.ClientCAFile != "")
+		} else {
+			klog.Warningf("TLS is only supported on tcp:// endpoint, serving %s in plain text", endpoint)
+		}
+	}
 	server := grpc.NewServer(opts...)
 	s.server = server
 
@@ -65,3 +65,8 @@ func TestForceStop(_ *testing.T) {
 	s.server = grpc.NewServer()
 	s.ForceStop()
 }
+
+func TestNewNonBlockingGRPCServerWithTLS(t *testing.T) {
+	s := NewNonBlockingGRPCServerWithTLS(&TLSOptions{})
+	assert.NotNil(t, s)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// TLSOptions defines the certificate files used to serve a tcp:// endpoint over TLS
+type TLSOptions struct {
+	// server certificate and key in PEM format
+	CertFile string
+	KeyFile  string
+	// if set, clients must present a certificate signed by this CA (mutual TLS)
+	ClientCAFile string
+}
+
+// Enabled returns true if any of the TLS files is configured
+func (o *TLSOptions) Enabled() bool {
+	return o != nil && (o.CertFile != "" || o.KeyFile != "" || o.ClientCAFile != "")
+}
+
+// Validate checks whether the TLS options are consistent
+func (o *TLSOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return fmt.Errorf("both server certificate file and key file must be provided")
+	}
+	return nil
+}
+
+// certReloader loads the server certificate and client CA from files,
+// the files are reloaded on TLS handshake once their modification time changes
+// so that rotated certificates are picked up without restarting the driver.
+type certReloader struct {
+	opts TLSOptions
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	clientCAs   *x509.CertPool
+	certModTime time.Time
+	keyModTime  time.Time
+	caModTime   time.Time
+}
+
+func newCertReloader(opts TLSOptions) (*certReloader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	r := &certReloader{opts: opts}
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// tlsConfig returns the TLS config used by gRPC server
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}
+}
+
+func (r *certReloader) getConfigForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	if err := r.reloadIfChanged(); err != nil {
+		// keep serving with the certificates loaded previously
+		klog.Errorf("failed to reload TLS certificates, using the previous ones: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+	}
+	if r.clientCAs != nil {
+		config.ClientCAs = r.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// reloadIfChanged loads the certificate files if they are modified since last load
+func (r *certReloader) reloadIfChanged() error {
+	certModTime, err := getModTime(r.opts.CertFile)
+	if err != nil {
+		return err
+	}
+	keyModTime, err := getModTime(r.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	var caModTime time.Time
+	if r.opts.ClientCAFile != "" {
+		if caModTime, err = getModTime(r.opts.ClientCAFile); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert == nil || !certModTime.Equal(r.certModTime) || !keyModTime.Equal(r.keyModTime) {
+		cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load server certificate(%s, %s): %v", r.opts.CertFile, r.opts.KeyFile, err)
+		}
+		r.cert = &cert
+		r.certModTime = certModTime
+		r.keyModTime = keyModTime
+		klog.V(2).Infof("loaded server certificate from %s", r.opts.CertFile)
+	}
+	if r.opts.ClientCAFile != "" && (r.clientCAs == nil || !caModTime.Equal(r.caModTime)) {
+		content, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file(%s): %v", r.opts.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no valid certificate found in client CA file(%s)", r.opts.ClientCAFile)
+		}
+		r.clientCAs = pool
+		r.caModTime = caModTime
+		klog.V(2).Infof("loaded client CA from %s", r.opts.ClientCAFile)
+	}
+	return nil
+}
+
+func getModTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "csi-smb-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to change time of %s: %v", path, err)
+	}
+}
+
+// handshake runs a TLS handshake over an in-memory connection and returns the server certificate serial
+func handshake(serverConfig *tls.Config, clientConfig *tls.Config) (*big.Int, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(serverConn, serverConfig).Handshake()
+	}()
+	client := tls.Client(clientConn, clientConfig)
+	if err := client.Handshake(); err != nil {
+		serverConn.Close()
+		<-serverErr
+		return nil, err
+	}
+	// with TLS 1.3, client certificate is verified after client handshake completes,
+	// drain the connection so that server could send the alert
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+	if err := <-serverErr; err != nil {
+		return nil, err
+	}
+	return client.ConnectionState().PeerCertificates[0].SerialNumber, nil
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		opts      *TLSOptions
+		enabled   bool
+		expectErr bool
+	}{
+		{
+			desc: "nil options",
+		},
+		{
+			desc: "empty options",
+			opts: &TLSOptions{},
+		},
+		{
+			desc:    "cert and key",
+			opts:    &TLSOptions{CertFile: "cert", KeyFile: "key"},
+			enabled: true,
+		},
+		{
+			desc:      "key missing",
+			opts:      &TLSOptions{CertFile: "cert", ClientCAFile: "ca"},
+			enabled:   true,
+			expectErr: true,
+		},
+		{
+			desc:      "only client CA",
+			opts:      &TLSOptions{ClientCAFile: "ca"},
+			enabled:   true,
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.enabled, test.opts.Enabled(), "test[%s]", test.desc)
+		err := test.opts.Validate()
+		assert.Equal(t, test.expectErr, err != nil, "test[%s]: unexpected error %v", test.desc, err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca := newTestCert(t, 1, true, nil)
+	server := newTestCert(t, 2, false, ca)
+	client := newTestCert(t, 3, false, ca)
+	untrusted := newTestCert(t, 4, false, nil)
+
+	modTime := time.Now().Add(-time.Minute)
+	writeTestFile(t, certFile, server.certPEM, modTime)
+	writeTestFile(t, keyFile, server.keyPEM, modTime)
+	writeTestFile(t, caFile, ca.certPEM, modTime)
+
+	_, err := newCertReloader(TLSOptions{CertFile: certFile, KeyFile: filepath.Join(dir, "non-existing")})
+	assert.Error(t, err)
+
+	reloader, err := newCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	assert.NoError(t, err)
+	serverConfig := reloader.tlsConfig()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	clientCert := tls.Certificate{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}
+	untrustedCert := tls.Certificate{Certificate: [][]byte{untrusted.cert.Raw}, PrivateKey: untrusted.key}
+
+	// client certificate signed by CA
+	serial, err := handshake(serverConfig, &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serial.Int64())
+
+	// no client certificate
+	_, err = handshake(serverConfig, &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"})
+	assert.Error(t, err)
+
+	// client certificate not signed by CA
+	_, err = handshake(serverConfig, &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1", Certificates: []tls.Certificate{untrustedCert}})
+	assert.Error(t, err)
+
+	// rotate server certificate
+	rotated := newTestCert(t, 5, false, ca)
+	writeTestFile(t, certFile, rotated.certPEM, modTime.Add(time.Second))
+	writeTestFile(t, keyFile, rotated.keyPEM, modTime.Add(time.Second))
+	serial, err = handshake(serverConfig, &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), serial.Int64())
+
+	// broken certificate keeps the previous one
+	writeTestFile(t, certFile, []byte("invalid"), modTime.Add(2*time.Second))
+	serial, err = handshake(serverConfig, &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), serial.Int64())
+}
+
+func TestCertReloaderWithoutClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	ca := newTestCert(t, 1, true, nil)
+	server := newTestCert(t, 2, false, ca)
+	writeTestFile(t, certFile, server.certPEM, time.Now())
+	writeTestFile(t, keyFile, server.keyPEM, time.Now())
+
+	reloader, err := newCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	assert.NoError(t, err)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	serial, err := handshake(reloader.tlsConfig(), &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serial.Int64())
+}
+
+func TestServeWithTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	server := newTestCert(t, 1, false, nil)
+	writeTestFile(t, certFile, server.certPEM, time.Now())
+	writeTestFile(t, keyFile, server.keyPEM, time.Now())
+
+	s := NewNonBlockingGRPCServerWithTLS(&TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	s.Start("tcp://127.0.0.1:0", nil, nil, nil, true)
+	s.Wait()
+}
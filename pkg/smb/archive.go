@@ -0,0 +1,701 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	archivePrefix = "archived-"
+	// prefix of the temporary directory which a volume is copied into before it's renamed to the archive name
+	archivingPrefix = ".archiving-"
+	// suffix of the metadata file stored alongside an archive
+	archiveMetadataSuffix = ".json"
+	// deletion time in archive name, it's valid in file names on both Linux and Windows
+	archiveTimeFormat = "20060102T150405Z"
+	// max length of a file name on SMB server
+	maxArchiveNameLength = 255
+	// provisioner secret in storage class parameters, used to mount the share in archive garbage collection
+	provisionerSecretNameKey      = "csi.storage.k8s.io/provisioner-secret-name"
+	provisionerSecretNamespaceKey = "csi.storage.k8s.io/provisioner-secret-namespace"
+)
+
+// timestamped archive name: archived-{subDir with / replaced by ~}_{pvc namespace}_{pvc name}_{deletion time},
+// PVC namespace and name could not contain '_', so the name is parsed from the right
+var archiveNameRegex = regexp.MustCompile(`^` + archivePrefix + `(.+)_([a-z0-9-]*)_([a-z0-9.-]*)_(\d{8}T\d{6}Z)$`)
+
+// archiveRetention is the retention of timestamped archives in a storage class
+type archiveRetention struct {
+	// archives deleted longer than maxAge ago are pruned, 0 means no limit
+	maxAge time.Duration
+	// only the newest maxCount archives of a PVC are kept, 0 means no limit
+	maxCount int
+}
+
+// archiveInfo is parsed from a timestamped archive name
+type archiveInfo struct {
+	name         string
+	subDir       string
+	pvcNamespace string
+	pvcName      string
+	deletedAt    time.Time
+}
+
+// parseArchiveRetention returns the archive retention in params, nil if it's not set
+func parseArchiveRetention(params map[string]string) (*archiveRetention, error) {
+	var retention *archiveRetention
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case archiveMaxAgeField:
+			maxAge, err := parseArchiveMaxAge(v)
+			if err != nil {
+				return nil, err
+			}
+			if retention == nil {
+				retention = &archiveRetention{}
+			}
+			retention.maxAge = maxAge
+		case archiveMaxCountField:
+			maxCount, err := strconv.Atoi(v)
+			if err != nil || maxCount < 1 {
+				return nil, fmt.Errorf("invalid %s %q, it should be a positive integer", archiveMaxCountField, v)
+			}
+			if retention == nil {
+				retention = &archiveRetention{}
+			}
+			retention.maxCount = maxCount
+		}
+	}
+	return retention, nil
+}
+
+// parseArchiveMaxAge parses max age in days, e.g. 30d, or as a duration, e.g. 720h
+func parseArchiveMaxAge(value string) (time.Duration, error) {
+	var maxAge time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		if n, err = strconv.Atoi(days); err == nil {
+			maxAge = time.Duration(n) * 24 * time.Hour
+		}
+	} else {
+		maxAge, err = time.ParseDuration(value)
+	}
+	if err != nil || maxAge <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, it should be a positive number of days (e.g. 30d) or a duration (e.g. 720h)", archiveMaxAgeField, value)
+	}
+	return maxAge, nil
+}
+
+// getArchiveName returns the timestamped archive name of subDir
+func getArchiveName(subDir, pvcNamespace, pvcName string, deletedAt time.Time) string {
+	encodedSubDir := strings.ReplaceAll(strings.Trim(subDir, "/"), "/", "~")
+	ts := deletedAt.UTC().Format(archiveTimeFormat)
+	name := fmt.Sprintf("%s%s_%s_%s_%s", archivePrefix, encodedSubDir, pvcNamespace, pvcName, ts)
+	if len(name) > maxArchiveNameLength {
+		// long PVC names are omitted, archives are then grouped by subDir
+		name = fmt.Sprintf("%s%s___%s", archivePrefix, encodedSubDir, ts)
+	}
+	return name
+}
+
+// parseArchiveName returns the archive info of a timestamped archive name, false if name is not a timestamped archive
+func parseArchiveName(name string) (*archiveInfo, bool) {
+	m := archiveNameRegex.FindStringSubmatch(name)
+	if m == nil {
+		return nil, false
+	}
+	deletedAt, err := time.Parse(archiveTimeFormat, m[4])
+	if err != nil {
+		return nil, false
+	}
+	return &archiveInfo{
+		name:         name,
+		subDir:       strings.ReplaceAll(m[1], "~", "/"),
+		pvcNamespace: m[2],
+		pvcName:      m[3],
+		deletedAt:    deletedAt,
+	}, true
+}
+
+// selectExpiredArchives returns the archives which are older than maxAge, or not in the newest maxCount
+// archives of the same PVC (or the same subDir if PVC is unknown)
+func selectExpiredArchives(archives []*archiveInfo, retention *archiveRetention, now time.Time) []*archiveInfo {
+	groups := map[string][]*archiveInfo{}
+	for _, a := range archives {
+		key := a.pvcNamespace + "/" + a.pvcName
+		if a.pvcName == "" {
+			key = "#" + a.subDir
+		}
+		groups[key] = append(groups[key], a)
+	}
+	var expired []*archiveInfo
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].deletedAt.After(group[j].deletedAt) })
+		for i, a := range group {
+			if (retention.maxCount > 0 && i >= retention.maxCount) || (retention.maxAge > 0 && now.Sub(a.deletedAt) > retention.maxAge) {
+				expired = append(expired, a)
+			}
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].name < expired[j].name })
+	return expired
+}
+
+// archiveLocation is where deleted volumes are archived, parsed from storage class parameters
+type archiveLocation struct {
+	// archive share, empty means the share of the volume
+	source string
+	// directory under the archive share
+	dir string
+	// secret to mount the archive share, empty means the secret of DeleteVolume request
+	secretName      string
+	secretNamespace string
+}
+
+// archiveMetadata is stored alongside an archive as {archive name}.json
+type archiveMetadata struct {
+	VolumeID     string    `json:"volumeID"`
+	Source       string    `json:"source"`
+	SubDir       string    `json:"subDir"`
+	PV           string    `json:"pv,omitempty"`
+	PVCNamespace string    `json:"pvcNamespace,omitempty"`
+	PVCName      string    `json:"pvcName,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+	DeletedAt    time.Time `json:"deletedAt"`
+}
+
+// volumeArchive describes how a deleted volume is archived
+type volumeArchive struct {
+	archiveLocation
+	volumeID string
+	// name of the archive under the archive directory
+	name string
+	// nil if metadata is not stored, i.e. archived-{subDir} in the share of the volume
+	metadata *archiveMetadata
+}
+
+// parseArchiveLocation returns the archive location in params, nil if it's not set
+func parseArchiveLocation(params map[string]string) (*archiveLocation, error) {
+	location := &archiveLocation{}
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case archiveSourceField:
+			location.source = strings.TrimRight(v, `/\`)
+		case archiveDirField:
+			location.dir = strings.Trim(v, `/\`)
+		case archiveSecretNameField:
+			location.secretName = v
+		case archiveSecretNamespaceField:
+			location.secretNamespace = v
+		}
+	}
+	if *location == (archiveLocation{}) {
+		return nil, nil
+	}
+	if err := validatePath(location.source); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", archiveSourceField, location.source, err)
+	}
+	if err := validatePath(location.dir); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", archiveDirField, location.dir, err)
+	}
+	if (location.secretName == "") != (location.secretNamespace == "") {
+		return nil, fmt.Errorf("both %s and %s are required for archive secret", archiveSecretNameField, archiveSecretNamespaceField)
+	}
+	if location.secretName != "" && location.source == "" {
+		return nil, fmt.Errorf("%s is only supported with %s", archiveSecretNameField, archiveSourceField)
+	}
+	return location, nil
+}
+
+// getVolumeArchive returns how the volume is archived according to archive parameters in the volume attributes
+// of its PV. Without archive parameters or PV, the volume is archived to archived-{subDir} in its share.
+func (d *Driver) getVolumeArchive(ctx context.Context, volumeID string, vol *smbVolume) *volumeArchive {
+	a := &volumeArchive{volumeID: volumeID, name: archivePrefix + vol.subDir}
+	if d.kubeClient == nil {
+		return a
+	}
+	pv, err := d.getPVByVolumeID(ctx, volumeID)
+	if err != nil {
+		klog.Warningf("failed to get PV of volume %s, archive to %s: %v", volumeID, a.name, err)
+		return a
+	}
+	if pv == nil {
+		return a
+	}
+	retention, err := parseArchiveRetention(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		klog.Warningf("ignore archive retention of volume %s: %v", volumeID, err)
+	}
+	location, err := parseArchiveLocation(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		klog.Warningf("ignore archive location of volume %s: %v", volumeID, err)
+	}
+	if retention == nil && location == nil {
+		return a
+	}
+
+	deletedAt := timeNow().UTC().Truncate(time.Second)
+	a.metadata = &archiveMetadata{
+		VolumeID:     volumeID,
+		Source:       vol.source,
+		SubDir:       vol.subDir,
+		PV:           pv.Name,
+		StorageClass: pv.Spec.StorageClassName,
+		DeletedAt:    deletedAt,
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		a.metadata.PVCNamespace, a.metadata.PVCName = ref.Namespace, ref.Name
+	}
+	if location != nil {
+		a.archiveLocation = *location
+	}
+	if retention != nil {
+		a.name = getArchiveName(vol.subDir, a.metadata.PVCNamespace, a.metadata.PVCName, deletedAt)
+	}
+	return a
+}
+
+// archiveVolume archives the directory of vol, which is mounted at its internal mount path, to its archive location.
+// The directory is renamed on the same share, or copied to the archive share and removed.
+func (d *Driver) archiveVolume(ctx context.Context, volumeID string, vol *smbVolume, secrets map[string]string) error {
+	internalVolumePath := getInternalVolumePath(d.workingMountDir, vol)
+	if _, err := os.Lstat(internalVolumePath); os.IsNotExist(err) {
+		klog.V(2).Infof("subdirectory %s does not exist, it may have been archived", internalVolumePath)
+		return nil
+	}
+	a := d.getVolumeArchive(ctx, volumeID, vol)
+
+	if a.source == "" {
+		release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpDelete)
+		if err != nil {
+			return err
+		}
+		defer release()
+		archiveRoot := filepath.Join(getInternalMountPath(d.workingMountDir, vol), a.dir)
+		if err := moveToArchive(internalVolumePath, archiveRoot, a, d.removeArchivedVolumePath); err != nil {
+			return status.Errorf(codes.Internal, "archive subdirectory %s failed with %v", internalVolumePath, err)
+		}
+		return nil
+	}
+
+	archiveSecrets := secrets
+	if a.secretName != "" {
+		var err error
+		if archiveSecrets, err = d.getSecretData(ctx, a.secretName, a.secretNamespace); err != nil {
+			return err
+		}
+	}
+	hash := sha256.Sum256([]byte(volumeID))
+	archiveVol := &smbVolume{
+		id:     "archive#" + volumeID,
+		source: a.source,
+		uuid:   "archive-" + hex.EncodeToString(hash[:8]),
+	}
+	if err := d.internalMount(ctx, archiveVol, getInternalMountVolCap(getMountOptions(archiveSecrets)), archiveSecrets); err != nil {
+		return status.Errorf(codes.Internal, "failed to mount archive share %s: %v", a.source, err)
+	}
+	defer func() {
+		if err := d.internalUnmount(ctx, archiveVol); err != nil {
+			klog.Warningf("failed to unmount archive share %s: %v", a.source, err)
+		}
+	}()
+
+	release, err := d.controllerOpLimiter.Acquire(ctx, a.source, serverOpCopy)
+	if err != nil {
+		return err
+	}
+	defer release()
+	serverSide := runtime.GOOS == "linux" && getServerFromSource(vol.source) == getServerFromSource(a.source)
+	archiveRoot := filepath.Join(getInternalMountPath(d.workingMountDir, archiveVol), a.dir)
+	if err := copyToArchive(internalVolumePath, archiveRoot, a, d.removeArchivedVolumePath, serverSide); err != nil {
+		return status.Errorf(codes.Internal, "archive subdirectory %s to %s failed with %v", internalVolumePath, a.source, err)
+	}
+	return nil
+}
+
+// moveToArchive renames volumePath to the archive under archiveRoot on the same share
+func moveToArchive(volumePath, archiveRoot string, a *volumeArchive, removeExisting bool) error {
+	archivePath := filepath.Join(archiveRoot, a.name)
+	// archive name of a nested subDir is nested
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0777); err != nil {
+		return fmt.Errorf("create parent directory of %s failed: %v", archivePath, err)
+	}
+	if removeExisting {
+		klog.V(2).Infof("removing archived subdirectory at %v", archivePath)
+		if err := os.RemoveAll(archivePath); err != nil {
+			return fmt.Errorf("failed to delete archived subdirectory %s: %v", archivePath, err)
+		}
+	} else if _, err := os.Lstat(archivePath); err == nil {
+		return fmt.Errorf("archive %s already exists", archivePath)
+	}
+	// metadata is written before the rename, since a retry after the rename could not find volumePath to archive
+	if err := writeArchiveMetadata(archivePath, a.metadata); err != nil {
+		return fmt.Errorf("failed to write metadata of archive %s: %v", archivePath, err)
+	}
+	klog.V(2).Infof("archiving subdirectory %s --> %s", volumePath, archivePath)
+	if err := os.Rename(volumePath, archivePath); err != nil {
+		if a.metadata != nil {
+			if rmErr := os.Remove(archivePath + archiveMetadataSuffix); rmErr != nil && !os.IsNotExist(rmErr) {
+				klog.Warningf("failed to remove metadata of archive %s: %v", archivePath, rmErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// copyToArchive copies volumePath to the archive under archiveRoot on another share, and removes volumePath.
+// Data is copied into a temporary directory named by volume ID first, so that a partial copy is never taken
+// as an archive and is overwritten by the retry.
+func copyToArchive(volumePath, archiveRoot string, a *volumeArchive, removeExisting, serverSide bool) error {
+	// a retry after the archive is complete only removes what is left of volumePath, the archive is never
+	// replaced by a copy of the remaining data
+	if archivePath, ok := findVolumeArchive(archiveRoot, a); ok {
+		klog.V(2).Infof("volume %s is already archived to %s, removing the remaining subdirectory %s", a.volumeID, archivePath, volumePath)
+		return removeVolumeDirectory(volumePath)
+	}
+	hash := sha256.Sum256([]byte(a.volumeID))
+	tmpPath := filepath.Join(archiveRoot, archivingPrefix+hex.EncodeToString(hash[:8]))
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+	klog.V(2).Infof("copying subdirectory %s --> %s", volumePath, tmpPath)
+	start := time.Now()
+	stats, err := copyDirectory(volumePath, tmpPath, serverSide)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("copied %s --> %s in %v, method: %s", volumePath, tmpPath, time.Since(start), stats.method())
+	if err := moveToArchive(tmpPath, archiveRoot, a, removeExisting); err != nil {
+		return err
+	}
+	klog.V(2).Infof("removing archived subdirectory at %v", volumePath)
+	return removeVolumeDirectory(volumePath)
+}
+
+// findVolumeArchive returns the path of an existing archive of a.volumeID under archiveRoot, it's named a.name,
+// or a timestamped name of the same subDir and PVC deleted earlier. An archive is complete if its metadata records
+// a.volumeID, since the metadata is only kept after the archive is renamed into place.
+func findVolumeArchive(archiveRoot string, a *volumeArchive) (string, bool) {
+	names := []string{a.name}
+	if info, ok := parseArchiveName(a.name); ok {
+		entries, err := os.ReadDir(archiveRoot)
+		if err != nil {
+			return "", false
+		}
+		for _, entry := range entries {
+			if other, ok := parseArchiveName(entry.Name()); ok && entry.IsDir() && other.name != a.name &&
+				other.subDir == info.subDir && other.pvcNamespace == info.pvcNamespace && other.pvcName == info.pvcName {
+				names = append(names, other.name)
+			}
+		}
+	}
+	for _, name := range names {
+		archivePath := filepath.Join(archiveRoot, name)
+		if fi, err := os.Stat(archivePath); err != nil || !fi.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(archivePath + archiveMetadataSuffix)
+		if err != nil {
+			continue
+		}
+		var metadata archiveMetadata
+		if err := json.Unmarshal(data, &metadata); err == nil && metadata.VolumeID == a.volumeID {
+			return archivePath, true
+		}
+	}
+	return "", false
+}
+
+// writeArchiveMetadata writes metadata to {archivePath}.json, it's a no-op if metadata is nil
+func writeArchiveMetadata(archivePath string, metadata *archiveMetadata) error {
+	if metadata == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(archivePath+archiveMetadataSuffix, data, 0644)
+}
+
+// getPVByVolumeID returns the PV of this driver with volumeID, nil if not found. PVs are not listed, the PV is got
+// by the name in volumeID of a dynamically provisioned volume: uuid, or subDir which is the PV name by default.
+func (d *Driver) getPVByVolumeID(ctx context.Context, volumeID string) (*v1.PersistentVolume, error) {
+	vol, err := getSmbVolFromID(volumeID)
+	if err != nil {
+		klog.V(4).Infof("could not get PV name from volume id %s: %v", volumeID, err)
+		return nil, nil
+	}
+	for _, name := range getPVNames(vol) {
+		pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if csiSource := pv.Spec.CSI; csiSource != nil && csiSource.Driver == d.Name && csiSource.VolumeHandle == volumeID {
+			return pv, nil
+		}
+	}
+	klog.V(4).Infof("PV of volume %s is not found", volumeID)
+	return nil, nil
+}
+
+// getPVNames returns the possible PV names of vol, which are valid object names
+func getPVNames(vol *smbVolume) []string {
+	var names []string
+	for _, name := range []string{vol.uuid, path.Base(vol.subDir)} {
+		if name == "" || name == "." || name == "/" || slices.Contains(names, name) {
+			continue
+		}
+		if len(validation.IsDNS1123Subdomain(name)) == 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// archiveGCTarget is a directory on a share with timestamped archives to prune
+type archiveGCTarget struct {
+	source          string
+	dir             string
+	secretName      string
+	secretNamespace string
+	retention       *archiveRetention
+}
+
+// runArchiveGC prunes expired archives every interval until ctx is done
+func (d *Driver) runArchiveGC(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("archive garbage collection is enabled, interval: %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.pruneArchives(ctx)
+		}
+	}
+}
+
+// pruneArchives prunes expired archives on the shares of the storage classes with archive retention
+func (d *Driver) pruneArchives(ctx context.Context) {
+	targets, err := d.getArchiveGCTargets(ctx)
+	if err != nil {
+		klog.Errorf("failed to get shares for archive garbage collection: %v", err)
+		return
+	}
+	for _, target := range targets {
+		if err := d.pruneShareArchives(ctx, target); err != nil {
+			klog.Errorf("failed to prune archives on %s: %v", target.source, err)
+		}
+	}
+}
+
+// getArchiveGCTargets returns the shares of the storage classes of this driver with archive retention. If several
+// storage classes use the same share, the longest retention is used since archives could not be told apart.
+func (d *Driver) getArchiveGCTargets(ctx context.Context) ([]*archiveGCTarget, error) {
+	if d.kubeClient == nil {
+		return nil, fmt.Errorf("KubeClient is nil")
+	}
+	scs, err := d.kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]*archiveGCTarget{}
+	for _, sc := range scs.Items {
+		if sc.Provisioner != d.Name {
+			continue
+		}
+		retention, err := parseArchiveRetention(sc.Parameters)
+		if err != nil {
+			klog.Warningf("skip archive garbage collection of storage class %s: %v", sc.Name, err)
+			continue
+		}
+		if retention == nil {
+			continue
+		}
+		shares, err := getArchiveShares(sc.Parameters)
+		if err != nil {
+			klog.Warningf("skip archive garbage collection of storage class %s: %v", sc.Name, err)
+			continue
+		}
+		for _, target := range shares {
+			target.retention = retention
+			key := target.source + "#" + target.dir
+			existing, ok := targets[key]
+			if !ok {
+				targets[key] = target
+				continue
+			}
+			existing.retention = &archiveRetention{
+				maxAge:   longerLimit(existing.retention.maxAge, retention.maxAge),
+				maxCount: int(longerLimit(time.Duration(existing.retention.maxCount), time.Duration(retention.maxCount))),
+			}
+		}
+	}
+	result := make([]*archiveGCTarget, 0, len(targets))
+	for _, t := range targets {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].source < result[j].source || (result[i].source == result[j].source && result[i].dir < result[j].dir)
+	})
+	return result, nil
+}
+
+// getArchiveShares returns the archive shares of storage class parameters: archiveSource if it's set, otherwise
+// source or every share in sources. They are mounted with the provisioner secret, or with the archive secret if
+// archiveSource is set.
+func getArchiveShares(params map[string]string) ([]*archiveGCTarget, error) {
+	location, err := parseArchiveLocation(params)
+	if err != nil {
+		return nil, err
+	}
+	source, secretName, secretNamespace := getProvisionerShare(params)
+	sources := []string{source}
+	if source == "" {
+		sources = getPoolSources(params)
+	}
+	var dir string
+	if location != nil {
+		dir = location.dir
+		if location.source != "" {
+			sources = []string{location.source}
+		}
+		if location.secretName != "" {
+			secretName, secretNamespace = location.secretName, location.secretNamespace
+		}
+	}
+	if len(sources) == 0 || sources[0] == "" || secretName == "" || secretNamespace == "" ||
+		strings.Contains(secretName+secretNamespace, "${") {
+		return nil, fmt.Errorf("source or sources and a provisioner or archive secret without template are required")
+	}
+	targets := make([]*archiveGCTarget, 0, len(sources))
+	for _, source := range sources {
+		targets = append(targets, &archiveGCTarget{
+			source:          strings.TrimRight(source, `/\`),
+			dir:             dir,
+			secretName:      secretName,
+			secretNamespace: secretNamespace,
+		})
+	}
+	return targets, nil
+}
+
+// mountArchiveShare mounts the share of target and returns the archive directory and a function to unmount it
+func (d *Driver) mountArchiveShare(ctx context.Context, target *archiveGCTarget, purpose string) (string, func(), error) {
+	secrets, err := d.getSecretData(ctx, target.secretName, target.secretNamespace)
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.Sum256([]byte(target.source))
+	vol := &smbVolume{
+		id:     "archive-" + purpose + "#" + target.source,
+		source: target.source,
+		uuid:   "archive-" + purpose + "-" + hex.EncodeToString(hash[:4]),
+	}
+	if err := d.internalMount(ctx, vol, getInternalMountVolCap(getMountOptions(secrets)), secrets); err != nil {
+		return "", nil, fmt.Errorf("failed to mount smb server: %v", err)
+	}
+	unmount := func() {
+		if err := d.internalUnmount(ctx, vol); err != nil {
+			klog.Warningf("failed to unmount smb server: %v", err)
+		}
+	}
+	return filepath.Join(getInternalMountPath(d.workingMountDir, vol), target.dir), unmount, nil
+}
+
+// longerLimit returns the longer of two limits where 0 means no limit
+func longerLimit(a, b time.Duration) time.Duration {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+// pruneShareArchives mounts the share of target and removes its expired archives
+func (d *Driver) pruneShareArchives(ctx context.Context, target *archiveGCTarget) error {
+	archiveRoot, unmount, err := d.mountArchiveShare(ctx, target, "gc")
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	entries, err := os.ReadDir(archiveRoot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var archives []*archiveInfo
+	for _, entry := range entries {
+		if a, ok := parseArchiveName(entry.Name()); ok {
+			archives = append(archives, a)
+		}
+	}
+	expired := selectExpiredArchives(archives, target.retention, timeNow())
+	if len(expired) == 0 {
+		klog.V(4).Infof("no expired archive in %d archives on %s", len(archives), target.source)
+		return nil
+	}
+
+	release, err := d.controllerOpLimiter.Acquire(ctx, target.source, serverOpDelete)
+	if err != nil {
+		return err
+	}
+	defer release()
+	for _, a := range expired {
+		klog.V(2).Infof("removing expired archive %s (deleted at %v) on %s", a.name, a.deletedAt, target.source)
+		if err := os.RemoveAll(filepath.Join(archiveRoot, a.name)); err != nil {
+			return fmt.Errorf("failed to remove archive %s: %v", a.name, err)
+		}
+		if err := os.Remove(filepath.Join(archiveRoot, a.name+archiveMetadataSuffix)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove metadata of archive %s: %v", a.name, err)
+		}
+	}
+	klog.V(2).Infof("removed %d expired archives in %d archives on %s", len(expired), len(archives), target.source)
+	return nil
+}
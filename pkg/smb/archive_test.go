@@ -0,0 +1,431 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseArchiveRetention(t *testing.T) {
+	tests := []struct {
+		params    map[string]string
+		expected  *archiveRetention
+		expectErr bool
+	}{
+		{
+			params: map[string]string{sourceField: "//smb-server/share"},
+		},
+		{
+			params:   map[string]string{"archiveMaxAge": "30d", "archiveMaxCount": "3"},
+			expected: &archiveRetention{maxAge: 30 * 24 * time.Hour, maxCount: 3},
+		},
+		{
+			params:   map[string]string{"archiveMaxAge": "12h"},
+			expected: &archiveRetention{maxAge: 12 * time.Hour},
+		},
+		{
+			params:    map[string]string{"archiveMaxAge": "0d"},
+			expectErr: true,
+		},
+		{
+			params:    map[string]string{"archiveMaxAge": "a month"},
+			expectErr: true,
+		},
+		{
+			params:    map[string]string{"archiveMaxCount": "0"},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		retention, err := parseArchiveRetention(test.params)
+		assert.Equal(t, test.expectErr, err != nil, test.params)
+		assert.Equal(t, test.expected, retention, test.params)
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	deletedAt := time.Date(2026, 10, 18, 10, 20, 30, 0, time.UTC)
+	name := getArchiveName("ns1/pvc_data", "ns1", "pvc-data.v1", deletedAt)
+	assert.Equal(t, "archived-ns1~pvc_data_ns1_pvc-data.v1_20261018T102030Z", name)
+
+	info, ok := parseArchiveName(name)
+	assert.True(t, ok)
+	assert.Equal(t, &archiveInfo{
+		name:         name,
+		subDir:       "ns1/pvc_data",
+		pvcNamespace: "ns1",
+		pvcName:      "pvc-data.v1",
+		deletedAt:    deletedAt,
+	}, info)
+
+	// PVC name is omitted if archive name is too long
+	name = getArchiveName("pv-name", "ns1", strings.Repeat("a", 253), deletedAt)
+	assert.Equal(t, "archived-pv-name___20261018T102030Z", name)
+	info, ok = parseArchiveName(name)
+	assert.True(t, ok)
+	assert.Equal(t, "pv-name", info.subDir)
+	assert.Empty(t, info.pvcName)
+
+	for _, name := range []string{"archived-pv-name", "pv-name_ns1_pvc_20261018T102030Z", "archived-pv-name_ns1_pvc_20261318T102030Z"} {
+		_, ok := parseArchiveName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestSelectExpiredArchives(t *testing.T) {
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	newArchive := func(subDir, pvcName string, age time.Duration) *archiveInfo {
+		a, _ := parseArchiveName(getArchiveName(subDir, "ns1", pvcName, now.Add(-age)))
+		return a
+	}
+	archives := []*archiveInfo{
+		newArchive("pv-1", "pvc-a", 1*time.Hour),
+		newArchive("pv-2", "pvc-a", 2*time.Hour),
+		newArchive("pv-3", "pvc-a", 3*time.Hour),
+		newArchive("pv-4", "pvc-b", 40*24*time.Hour),
+		newArchive("pv-5", "pvc-b", 1*time.Hour),
+	}
+
+	var names []string
+	for _, a := range selectExpiredArchives(archives, &archiveRetention{maxAge: 30 * 24 * time.Hour, maxCount: 2}, now) {
+		names = append(names, a.subDir)
+	}
+	assert.Equal(t, []string{"pv-3", "pv-4"}, names)
+
+	names = nil
+	for _, a := range selectExpiredArchives(archives, &archiveRetention{maxCount: 1}, now) {
+		names = append(names, a.subDir)
+	}
+	assert.Equal(t, []string{"pv-2", "pv-3", "pv-4"}, names)
+}
+
+func TestParseArchiveLocation(t *testing.T) {
+	tests := []struct {
+		desc        string
+		params      map[string]string
+		expected    *archiveLocation
+		expectedErr bool
+	}{
+		{
+			desc:   "not set",
+			params: map[string]string{"source": "//smb-server/share"},
+		},
+		{
+			desc:     "directory on the same share",
+			params:   map[string]string{"archiveDir": "/trash/"},
+			expected: &archiveLocation{dir: "trash"},
+		},
+		{
+			desc:     "another share with secret",
+			params:   map[string]string{"archiveSource": "//smb-server/archive/", "archiveSecretName": "archivecreds", "archiveSecretNamespace": "default"},
+			expected: &archiveLocation{source: "//smb-server/archive", secretName: "archivecreds", secretNamespace: "default"},
+		},
+		{
+			desc:        "invalid dir",
+			params:      map[string]string{"archiveDir": "../trash"},
+			expectedErr: true,
+		},
+		{
+			desc:        "secret namespace missing",
+			params:      map[string]string{"archiveSource": "//smb-server/archive", "archiveSecretName": "archivecreds"},
+			expectedErr: true,
+		},
+		{
+			desc:        "secret without archive source",
+			params:      map[string]string{"archiveDir": "trash", "archiveSecretName": "archivecreds", "archiveSecretNamespace": "default"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		location, err := parseArchiveLocation(test.params)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, location, test.desc)
+	}
+}
+
+func TestGetVolumeArchive(t *testing.T) {
+	d := NewFakeDriver()
+	vol := &smbVolume{source: "//smb-server/share", subDir: "pv-name", uuid: "pv-name"}
+	volumeID := "smb-server/share#pv-name#pv-name#archive"
+	legacy := &volumeArchive{volumeID: volumeID, name: "archived-pv-name"}
+	assert.Equal(t, legacy, d.getVolumeArchive(context.Background(), volumeID, vol))
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-name"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:           d.Name,
+					VolumeHandle:     volumeID,
+					VolumeAttributes: map[string]string{sourceField: "//smb-server/share"},
+				},
+			},
+			ClaimRef:         &v1.ObjectReference{Namespace: "ns1", Name: "pvc-name"},
+			StorageClassName: "smb",
+		},
+	}
+	d.kubeClient = fake.NewSimpleClientset(pv)
+	assert.Equal(t, legacy, d.getVolumeArchive(context.Background(), volumeID, vol))
+
+	deletedAt := time.Date(2026, 10, 18, 10, 20, 30, 0, time.UTC)
+	timeNow = func() time.Time { return deletedAt }
+	defer func() { timeNow = time.Now }()
+	metadata := &archiveMetadata{
+		VolumeID:     volumeID,
+		Source:       "//smb-server/share",
+		SubDir:       "pv-name",
+		PV:           "pv-name",
+		PVCNamespace: "ns1",
+		PVCName:      "pvc-name",
+		StorageClass: "smb",
+		DeletedAt:    deletedAt,
+	}
+
+	pv.Spec.CSI.VolumeAttributes["archiveDir"] = "trash"
+	d.kubeClient = fake.NewSimpleClientset(pv)
+	assert.Equal(t, &volumeArchive{
+		archiveLocation: archiveLocation{dir: "trash"},
+		volumeID:        volumeID,
+		name:            "archived-pv-name",
+		metadata:        metadata,
+	}, d.getVolumeArchive(context.Background(), volumeID, vol))
+
+	pv.Spec.CSI.VolumeAttributes["archiveMaxAge"] = "30d"
+	pv.Spec.CSI.VolumeAttributes["archiveSource"] = "//smb-server/archive"
+	d.kubeClient = fake.NewSimpleClientset(pv)
+	assert.Equal(t, &volumeArchive{
+		archiveLocation: archiveLocation{source: "//smb-server/archive", dir: "trash"},
+		volumeID:        volumeID,
+		name:            "archived-pv-name_ns1_pvc-name_20261018T102030Z",
+		metadata:        metadata,
+	}, d.getVolumeArchive(context.Background(), volumeID, vol))
+}
+
+func TestMoveAndCopyToArchive(t *testing.T) {
+	deletedAt := time.Date(2026, 10, 18, 10, 20, 30, 0, time.UTC)
+	a := &volumeArchive{
+		volumeID: "smb-server/share#a/pv-name#pv-name#archive",
+		name:     "archived-a/pv-name",
+		metadata: &archiveMetadata{VolumeID: "smb-server/share#a/pv-name#pv-name#archive", SubDir: "a/pv-name", DeletedAt: deletedAt},
+	}
+	newVolume := func(t *testing.T) string {
+		volumePath := filepath.Join(t.TempDir(), "a", "pv-name")
+		assert.NoError(t, os.MkdirAll(filepath.Join(volumePath, "dir"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(volumePath, "dir", "file"), []byte("data"), 0644))
+		return volumePath
+	}
+	checkArchive := func(t *testing.T, volumePath, archiveRoot string) {
+		data, err := os.ReadFile(filepath.Join(archiveRoot, "archived-a", "pv-name", "dir", "file"))
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+		_, err = os.Stat(volumePath)
+		assert.True(t, os.IsNotExist(err))
+
+		data, err = os.ReadFile(filepath.Join(archiveRoot, "archived-a", "pv-name.json"))
+		assert.NoError(t, err)
+		var metadata archiveMetadata
+		assert.NoError(t, json.Unmarshal(data, &metadata))
+		assert.Equal(t, *a.metadata, metadata)
+	}
+
+	t.Run("move", func(t *testing.T) {
+		volumePath := newVolume(t)
+		archiveRoot := filepath.Join(t.TempDir(), "trash")
+		assert.NoError(t, moveToArchive(volumePath, archiveRoot, a, false))
+		checkArchive(t, volumePath, archiveRoot)
+
+		// the existing archive and its metadata are kept when archiving fails
+		volumePath = newVolume(t)
+		other := &volumeArchive{volumeID: "vol-2", name: a.name, metadata: &archiveMetadata{VolumeID: "vol-2"}}
+		assert.Error(t, moveToArchive(volumePath, archiveRoot, other, false))
+		checkArchive(t, filepath.Join(t.TempDir(), "archived"), archiveRoot)
+		_, err := os.Stat(volumePath)
+		assert.NoError(t, err)
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		volumePath := newVolume(t)
+		archiveRoot := t.TempDir()
+		// a partial copy of a previous attempt is overwritten
+		hash := sha256.Sum256([]byte(a.volumeID))
+		tmpPath := filepath.Join(archiveRoot, archivingPrefix+hex.EncodeToString(hash[:8]))
+		assert.NoError(t, os.MkdirAll(filepath.Join(tmpPath, "stale"), 0755))
+		assert.NoError(t, copyToArchive(volumePath, archiveRoot, a, false, false))
+		checkArchive(t, volumePath, archiveRoot)
+		_, err := os.Stat(filepath.Join(archiveRoot, "archived-a", "pv-name", "stale"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(tmpPath)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("retry after partial removal", func(t *testing.T) {
+		volumePath := newVolume(t)
+		archiveRoot := t.TempDir()
+		assert.NoError(t, copyToArchive(volumePath, archiveRoot, a, true, false))
+		// the previous attempt failed to remove part of the volume directory
+		assert.NoError(t, os.MkdirAll(filepath.Join(volumePath, "left"), 0755))
+		assert.NoError(t, copyToArchive(volumePath, archiveRoot, a, true, false))
+		checkArchive(t, volumePath, archiveRoot)
+		_, err := os.Stat(filepath.Join(archiveRoot, "archived-a", "pv-name", "left"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("retry of timestamped archive", func(t *testing.T) {
+		ts := &volumeArchive{
+			volumeID: a.volumeID,
+			name:     getArchiveName("a/pv-name", "default", "data", deletedAt),
+			metadata: &archiveMetadata{VolumeID: a.volumeID, SubDir: "a/pv-name", PVCNamespace: "default", PVCName: "data", DeletedAt: deletedAt},
+		}
+		volumePath := newVolume(t)
+		archiveRoot := t.TempDir()
+		assert.NoError(t, copyToArchive(volumePath, archiveRoot, ts, false, false))
+		assert.NoError(t, os.MkdirAll(filepath.Join(volumePath, "left"), 0755))
+		retry := *ts
+		retry.name = getArchiveName("a/pv-name", "default", "data", deletedAt.Add(time.Minute))
+		assert.NoError(t, copyToArchive(volumePath, archiveRoot, &retry, false, false))
+		_, err := os.Stat(volumePath)
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(archiveRoot, retry.name))
+		assert.True(t, os.IsNotExist(err))
+		data, err := os.ReadFile(filepath.Join(archiveRoot, ts.name, "dir", "file"))
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+	})
+}
+
+func TestGetPVByVolumeID(t *testing.T) {
+	d := NewFakeDriver()
+	newPV := func(name, volumeID string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: volumeID},
+				},
+			},
+		}
+	}
+	d.kubeClient = fake.NewSimpleClientset(
+		newPV("pv-1", "smb-server/share#pv-1##"),
+		newPV("pv-2", "smb-server/share#ns/data#pv-2#retain"),
+		newPV("pv-3", "smb-server/share#ns/pv-3##"),
+		newPV("pv-4", "smb-server/other#pv-4##"),
+	)
+
+	tests := []struct {
+		volumeID string
+		expected string
+	}{
+		{volumeID: "smb-server/share#pv-1##", expected: "pv-1"},
+		{volumeID: "smb-server/share#ns/data#pv-2#retain", expected: "pv-2"},
+		{volumeID: "smb-server/share#ns/pv-3##", expected: "pv-3"},
+		// PV with the same name has another volume handle
+		{volumeID: "smb-server/share#pv-4##"},
+		{volumeID: "smb-server/share#Invalid_Name##"},
+		{volumeID: "invalid"},
+	}
+	for _, test := range tests {
+		pv, err := d.getPVByVolumeID(context.Background(), test.volumeID)
+		assert.NoError(t, err, test.volumeID)
+		if test.expected == "" {
+			assert.Nil(t, pv, test.volumeID)
+			continue
+		}
+		if assert.NotNil(t, pv, test.volumeID) {
+			assert.Equal(t, test.expected, pv.Name, test.volumeID)
+		}
+	}
+
+	// PVs are got by name instead of listed
+	for _, action := range d.kubeClient.(*fake.Clientset).Actions() {
+		assert.NotEqual(t, "list", action.GetVerb())
+	}
+}
+
+func TestGetArchiveGCTargets(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.getArchiveGCTargets(context.Background())
+	assert.Error(t, err)
+
+	newSC := func(name, provisioner string, params map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Provisioner: provisioner, Parameters: params}
+	}
+	secretParams := func(params map[string]string) map[string]string {
+		params[provisionerSecretNameKey] = "smbcreds"
+		params[provisionerSecretNamespaceKey] = "default"
+		return params
+	}
+	d.kubeClient = fake.NewSimpleClientset(
+		newSC("sc-1", d.Name, secretParams(map[string]string{"source": "//smb-server/share/", "archiveMaxAge": "30d", "archiveMaxCount": "5"})),
+		newSC("sc-2", d.Name, secretParams(map[string]string{"source": "//smb-server/share", "archiveMaxAge": "60d"})),
+		newSC("sc-3", d.Name, secretParams(map[string]string{"source": "//smb-server/other"})),
+		newSC("sc-4", d.Name, map[string]string{"source": "//smb-server/nosecret", "archiveMaxAge": "30d"}),
+		newSC("sc-5", "other.csi.k8s.io", secretParams(map[string]string{"source": "//smb-server/foreign", "archiveMaxAge": "30d"})),
+		newSC("sc-6", d.Name, secretParams(map[string]string{"source": "//smb-server/share", "archiveMaxAge": "7d", "archiveDir": "trash",
+			"archiveSource": "//smb-server/archive", "archiveSecretName": "archivecreds", "archiveSecretNamespace": "archive"})),
+		newSC("sc-7", d.Name, secretParams(map[string]string{"sources": "//smb-server/pool-b;weight=2,//smb-server/pool-a/", "archiveMaxCount": "3"})),
+	)
+	targets, err := d.getArchiveGCTargets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []*archiveGCTarget{
+		{
+			source:          "//smb-server/archive",
+			dir:             "trash",
+			secretName:      "archivecreds",
+			secretNamespace: "archive",
+			retention:       &archiveRetention{maxAge: 7 * 24 * time.Hour},
+		},
+		{
+			// every share in sources is pruned
+			source:          "//smb-server/pool-a",
+			secretName:      "smbcreds",
+			secretNamespace: "default",
+			retention:       &archiveRetention{maxCount: 3},
+		},
+		{
+			source:          "//smb-server/pool-b",
+			secretName:      "smbcreds",
+			secretNamespace: "default",
+			retention:       &archiveRetention{maxCount: 3},
+		},
+		{
+			source:          "//smb-server/share",
+			secretName:      "smbcreds",
+			secretNamespace: "default",
+			// the longest retention of storage classes on the same share, sc-2 has no count limit
+			retention: &archiveRetention{maxAge: 60 * 24 * time.Hour},
+		},
+	}, targets)
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// methods used to copy a file when cloning a volume
+const (
+	// cloneMethodServerSideCopy copies data on server (FSCTL_SRV_COPYCHUNK) via copy_file_range
+	cloneMethodServerSideCopy = "server-side-copy"
+	// cloneMethodClientSideCopy reads data from server and writes it back through the controller
+	cloneMethodClientSideCopy = "client-side-copy"
+
+	// maxCopyFileRangeChunk is the max number of bytes copied by a single copy_file_range call
+	maxCopyFileRangeChunk = 1 << 30
+
+	// annotations set by external-provisioner on PV if provisioner secret is configured in storage class
+	provisionerDeletionSecretNameAnnotation      = "volume.kubernetes.io/provisioner-deletion-secret-name"
+	provisionerDeletionSecretNamespaceAnnotation = "volume.kubernetes.io/provisioner-deletion-secret-namespace"
+)
+
+// cloneStats records the number of files and bytes copied by each method
+type cloneStats struct {
+	files map[string]int
+	bytes map[string]int64
+}
+
+func newCloneStats() *cloneStats {
+	return &cloneStats{
+		files: map[string]int{},
+		bytes: map[string]int64{},
+	}
+}
+
+func (s *cloneStats) add(method string, size int64) {
+	s.files[method]++
+	s.bytes[method] += size
+	cloneBytes.WithLabelValues(method).Add(float64(size))
+}
+
+// method returns the copy method used by all files, or a summary if files are copied by different methods
+func (s *cloneStats) method() string {
+	if len(s.files) == 0 {
+		return "none"
+	}
+	methods := make([]string, 0, len(s.files))
+	for m := range s.files {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	if len(methods) == 1 {
+		return methods[0]
+	}
+	summary := make([]string, 0, len(methods))
+	for _, m := range methods {
+		summary = append(summary, fmt.Sprintf("%s(%d files, %d bytes)", m, s.files[m], s.bytes[m]))
+	}
+	return "mixed: " + strings.Join(summary, ", ")
+}
+
+// copyDirectory copies the content of srcDir into dstDir, preserving file modes, timestamps, symlinks, ownership and
+// extended attributes like `cp -a`. Ownership and extended attributes are preserved on Linux on a best effort basis,
+// failures and special files (devices, fifos and sockets), which are skipped, are logged as warnings.
+// If serverSide is true, server-side copy is tried for each file before falling back to client-side copy,
+// which is only possible when both directories are on the same SMB server.
+func copyDirectory(srcDir, dstDir string, serverSide bool) (*cloneStats, error) {
+	stats := newCloneStats()
+	var dirs []string
+	var ownershipFailures int
+	var ownershipErr error
+	preserveOwnership := func(src, dst string, info fs.FileInfo) {
+		if err := copyOwnership(src, dst, info); err != nil {
+			ownershipFailures++
+			ownershipErr = fmt.Errorf("%s: %v", dst, err)
+		}
+	}
+	defer func() {
+		if ownershipFailures > 0 {
+			klog.Warningf("ownership or extended attributes of %d entries under %s are not preserved, last error: %v", ownershipFailures, dstDir, ownershipErr)
+		}
+	}()
+	err := filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(dst, mode.Perm()); err != nil {
+				return err
+			}
+			dirs = append(dirs, rel)
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, dst); err != nil && !os.IsExist(err) {
+				return err
+			}
+			preserveOwnership(path, dst, info)
+		case mode.IsRegular():
+			method, err := copyFile(path, dst, info, serverSide)
+			if err != nil {
+				return fmt.Errorf("copy %s to %s failed: %v", path, dst, err)
+			}
+			stats.add(method, info.Size())
+			preserveOwnership(path, dst, info)
+		default:
+			klog.Warningf("skip copying %s with unsupported file mode %v", path, mode)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// restore directory modes and timestamps after their content is copied, children first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		src := filepath.Join(srcDir, dirs[i])
+		info, err := os.Stat(src)
+		if err != nil {
+			return stats, err
+		}
+		dst := filepath.Join(dstDir, dirs[i])
+		preserveOwnership(src, dst, info)
+		if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+			return stats, err
+		}
+		if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// copyFile copies the regular file src to dst and returns the copy method
+func copyFile(src, dst string, info fs.FileInfo, serverSide bool) (string, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	defer dstFile.Close()
+
+	method := ""
+	if serverSide {
+		if method, err = serverSideCopyFile(dstFile, srcFile, info.Size()); err != nil {
+			klog.V(4).Infof("server side copy %s -> %s failed, fall back to client side copy: %v", src, dst, err)
+			method = ""
+			if err := resetCopy(dstFile, srcFile); err != nil {
+				return "", err
+			}
+		}
+	}
+	if method == "" {
+		// hide ReadFrom of *os.File, which may try copy_file_range again
+		if _, err := io.Copy(struct{ io.Writer }{dstFile}, struct{ io.Reader }{srcFile}); err != nil {
+			return "", err
+		}
+		method = cloneMethodClientSideCopy
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return "", err
+	}
+	return method, nil
+}
+
+// resetCopy truncates dst and rewinds both files after a partial server side copy
+func resetCopy(dst, src *os.File) error {
+	if err := dst.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := src.Seek(0, io.SeekStart)
+	return err
+}
+
+// getCloneSourceSecrets returns the secrets used to mount the source volume of a clone, which could be
+// on a different server with different credentials. The secret is resolved in order of:
+//   - cloneSourceSecretName and cloneSourceSecretNamespace in storage class parameters
+//   - nodeStageSecretRef of the source PV
+//   - provisioner secret of the source PV
+//
+// secrets of the request are returned if none of above is found.
+func (d *Driver) getCloneSourceSecrets(ctx context.Context, srcVolumeID string, params, secrets map[string]string) (map[string]string, error) {
+	var name, namespace string
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case srcSecretNameField:
+			name = v
+		case srcSecretNamespaceField:
+			namespace = v
+		}
+	}
+	if name != "" || namespace != "" {
+		if name == "" || namespace == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "both %s and %s are required for clone source secret", srcSecretNameField, srcSecretNamespaceField)
+		}
+		klog.V(2).Infof("use secret %s/%s in parameters to mount source volume %s", namespace, name, srcVolumeID)
+		return d.getSecretData(ctx, name, namespace)
+	}
+
+	if d.kubeClient == nil {
+		return secrets, nil
+	}
+	ref, err := d.getVolumeSecretRef(ctx, srcVolumeID)
+	if err != nil {
+		klog.Warningf("failed to get secret of source volume %s, use secrets in request: %v", srcVolumeID, err)
+		return secrets, nil
+	}
+	if ref == nil {
+		return secrets, nil
+	}
+	klog.V(2).Infof("use secret %s/%s of source PV to mount source volume %s", ref.Namespace, ref.Name, srcVolumeID)
+	return d.getSecretData(ctx, ref.Name, ref.Namespace)
+}
+
+// getVolumeSecretRef returns nodeStageSecretRef or provisioner secret of the PV with volumeID, nil if not found
+func (d *Driver) getVolumeSecretRef(ctx context.Context, volumeID string) (*v1.SecretReference, error) {
+	pv, err := d.getPVByVolumeID(ctx, volumeID)
+	if err != nil || pv == nil {
+		return nil, err
+	}
+	if ref := pv.Spec.CSI.NodeStageSecretRef; ref != nil && ref.Name != "" {
+		return ref, nil
+	}
+	name := pv.Annotations[provisionerDeletionSecretNameAnnotation]
+	namespace := pv.Annotations[provisionerDeletionSecretNamespaceAnnotation]
+	if name != "" && namespace != "" {
+		return &v1.SecretReference{Name: name, Namespace: namespace}, nil
+	}
+	return nil, nil
+}
+
+// getSecretData returns the data of secret
+func (d *Driver) getSecretData(ctx context.Context, name, namespace string) (map[string]string, error) {
+	if d.kubeClient == nil {
+		return nil, status.Errorf(codes.Internal, "could not get secret %s/%s: KubeClient is nil", namespace, name)
+	}
+	secret, err := d.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get secret %s/%s: %v", namespace, name, err)
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCopyDirectory(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("skipping on windows: symlink requires privilege")
+	}
+	srcDir := t.TempDir()
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "dir1", "dir2"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "file1"), []byte("file1 content"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "dir1", "dir2", "file2"), []byte("file2 content"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "empty"), nil, 0644))
+	assert.NoError(t, os.Symlink("dir1/dir2/file2", filepath.Join(srcDir, "link")))
+	assert.NoError(t, os.Chtimes(filepath.Join(srcDir, "file1"), mtime, mtime))
+	assert.NoError(t, os.Chtimes(filepath.Join(srcDir, "dir1"), mtime, mtime))
+
+	for _, serverSide := range []bool{false, true} {
+		dstDir := t.TempDir()
+		stats, err := copyDirectory(srcDir, dstDir, serverSide)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(dstDir, "dir1", "dir2", "file2"))
+		assert.NoError(t, err)
+		assert.Equal(t, "file2 content", string(content))
+		content, err = os.ReadFile(filepath.Join(dstDir, "link"))
+		assert.NoError(t, err)
+		assert.Equal(t, "file2 content", string(content))
+		link, err := os.Readlink(filepath.Join(dstDir, "link"))
+		assert.NoError(t, err)
+		assert.Equal(t, "dir1/dir2/file2", link)
+
+		info, err := os.Stat(filepath.Join(dstDir, "file1"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		assert.True(t, mtime.Equal(info.ModTime()))
+		info, err = os.Stat(filepath.Join(dstDir, "dir1"))
+		assert.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.True(t, mtime.Equal(info.ModTime()))
+
+		assert.Equal(t, 3, stats.files[cloneMethodClientSideCopy]+stats.files[cloneMethodServerSideCopy])
+		if !serverSide {
+			assert.Equal(t, cloneMethodClientSideCopy, stats.method())
+			assert.Equal(t, int64(26), stats.bytes[cloneMethodClientSideCopy])
+		} else if goruntime.GOOS == "linux" {
+			assert.Zero(t, stats.files[cloneMethodClientSideCopy])
+		}
+	}
+}
+
+func TestCloneStatsMethod(t *testing.T) {
+	stats := newCloneStats()
+	assert.Equal(t, "none", stats.method())
+
+	stats.add(cloneMethodServerSideCopy, 100)
+	stats.add(cloneMethodServerSideCopy, 200)
+	assert.Equal(t, cloneMethodServerSideCopy, stats.method())
+
+	stats.add(cloneMethodClientSideCopy, 10)
+	assert.Equal(t, "mixed: client-side-copy(1 files, 10 bytes), server-side-copy(2 files, 300 bytes)", stats.method())
+}
+
+func TestGetCloneSourceSecrets(t *testing.T) {
+	srcVolumeID := "smb-server-2/share#pvc-src##"
+	reqSecrets := map[string]string{usernameField: "dst-user", passwordField: "dst-password"}
+	newSecret := func(namespace, name, username string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data: map[string][]byte{
+				usernameField: []byte(username),
+				passwordField: []byte("password"),
+			},
+		}
+	}
+	newPV := func(name, volumeHandle string, nodeStageSecretRef *v1.SecretReference, annotations map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						Driver:             DefaultDriverName,
+						VolumeHandle:       volumeHandle,
+						NodeStageSecretRef: nodeStageSecretRef,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc            string
+		objects         []runtime.Object
+		noKubeClient    bool
+		params          map[string]string
+		expectedSecrets map[string]string
+		expectedErr     codes.Code
+	}{
+		{
+			desc:            "no kube client",
+			noKubeClient:    true,
+			expectedSecrets: reqSecrets,
+		},
+		{
+			desc:            "source PV not found",
+			objects:         []runtime.Object{newPV("pv-other", "smb-server/share#other##", &v1.SecretReference{Name: "src", Namespace: "default"}, nil)},
+			expectedSecrets: reqSecrets,
+		},
+		{
+			desc: "secret in parameters",
+			objects: []runtime.Object{
+				newSecret("ns1", "param-secret", "param-user"),
+				newPV("pvc-src", srcVolumeID, &v1.SecretReference{Name: "src", Namespace: "default"}, nil),
+			},
+			params:          map[string]string{"cloneSourceSecretName": "param-secret", "cloneSourceSecretNamespace": "ns1"},
+			expectedSecrets: map[string]string{usernameField: "param-user", passwordField: "password"},
+		},
+		{
+			desc:        "secret namespace missing in parameters",
+			params:      map[string]string{"cloneSourceSecretName": "param-secret"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			desc:        "secret in parameters not found",
+			params:      map[string]string{"cloneSourceSecretName": "param-secret", "cloneSourceSecretNamespace": "ns1"},
+			expectedErr: codes.Internal,
+		},
+		{
+			desc: "nodeStageSecretRef of source PV",
+			objects: []runtime.Object{
+				newSecret("default", "src", "src-user"),
+				newPV("pvc-src", srcVolumeID, &v1.SecretReference{Name: "src", Namespace: "default"}, nil),
+			},
+			expectedSecrets: map[string]string{usernameField: "src-user", passwordField: "password"},
+		},
+		{
+			desc: "provisioner secret of source PV",
+			objects: []runtime.Object{
+				newSecret("ns2", "provisioner", "provisioner-user"),
+				newPV("pvc-src", srcVolumeID, nil, map[string]string{
+					provisionerDeletionSecretNameAnnotation:      "provisioner",
+					provisionerDeletionSecretNamespaceAnnotation: "ns2",
+				}),
+			},
+			expectedSecrets: map[string]string{usernameField: "provisioner-user", passwordField: "password"},
+		},
+		{
+			desc:        "secret of source PV not found",
+			objects:     []runtime.Object{newPV("pvc-src", srcVolumeID, &v1.SecretReference{Name: "src", Namespace: "default"}, nil)},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		if !test.noKubeClient {
+			d.kubeClient = fake.NewSimpleClientset(test.objects...)
+		}
+		secrets, err := d.getCloneSourceSecrets(context.Background(), srcVolumeID, test.params, reqSecrets)
+		assert.Equal(t, test.expectedErr, status.Code(err), test.desc)
+		assert.Equal(t, test.expectedSecrets, secrets, test.desc)
+	}
+}
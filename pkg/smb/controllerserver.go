@@ -22,20 +22,20 @@ import (
 	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
 )
 
-const (
-	separator = "#"
-)
-
 // smbVolume is an internal representation of a volume
 // created by the provisioner.
 type smbVolume struct {
@@ -51,18 +51,30 @@ type smbVolume struct {
 	uuid string
 	// on delete action
 	onDelete string
+	// volume type, image volume is a filesystem image file under subDir
+	volumeType string
+	// filesystem type of image volume
+	fsType string
+	// share created by share backend for the volume, source is //{server}/{share} if it's set
+	share string
+	// directory on SMB server exported by share
+	sharePath string
+	// valid users of share
+	shareValidUsers string
+	// user created by user backend for the volume, it's also the name of the secret with its password
+	volumeUser string
+	// namespace of the secret of volumeUser
+	volumeUserSecretNamespace string
+	// permissions applied on the volume directory, nil means default mode
+	dirPermissions *dirPermissions
+	// name of the archive which is restored into the volume directory
+	restoreFromArchive string
+	// servers which serve the same share as the server of source, they are tried by internal mounts in order
+	alternateServers []string
+	// attributes in volume id which are not known by this driver version
+	idAttributes map[string]string
 }
 
-// Ordering of elements in the CSI volume id.
-// ID is of the form {server}/{subDir}.
-const (
-	idSource = iota
-	idSubDir
-	idUUID
-	idOnDelete
-	totalIDElements // Always last
-)
-
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	name := req.GetName()
 	if len(name) == 0 {
@@ -70,28 +82,85 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	volumeCapabilities := req.GetVolumeCapabilities()
-	if err := isValidVolumeCapabilities(volumeCapabilities); err != nil {
+	if err := validateVolumeCapabilities(volumeCapabilities, req.GetParameters()); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	if req.GetVolumeContentSource().GetSnapshot() != nil {
+		return d.createVolumeFromSnapshot(req)
+	}
+
 	reqCapacity := req.GetCapacityRange().GetRequiredBytes()
 	parameters := req.GetParameters()
 	if parameters == nil {
 		parameters = make(map[string]string)
 	}
-	smbVol, err := newSMBVolume(name, reqCapacity, parameters, d.defaultOnDeletePolicy)
+	pvc, err := d.getPVC(ctx, parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPVCOverrides(parameters, pvc); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	accessibleTopology, err := d.placeVolume(ctx, name, parameters, req.GetSecrets(), volumeCapabilities[0], req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, err
+	}
+	smbVol, err := newSMBVolume(name, reqCapacity, parameters, d.defaultOnDeletePolicy, pvc)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	if smbVol.share != "" && d.shareBackend == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is set but share backend is not configured in driver", sharePathField)
+	}
+	if smbVol.volumeUser != "" && d.userBackend == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is set but user backend is not configured in driver", perVolumeUserField)
+	}
+
 	secrets := req.GetSecrets()
 	createSubDir := len(secrets) > 0
-	if len(smbVol.uuid) > 0 {
+	if len(smbVol.uuid) > 0 && smbVol.subDir != "" {
 		klog.V(2).Infof("create subdirectory(%s) if not exists", smbVol.subDir)
 		createSubDir = true
 	}
+	if smbVol.volumeType == volumeTypeImage {
+		klog.V(2).Infof("create subdirectory(%s) and image file for image volume", smbVol.subDir)
+		createSubDir = true
+	}
+	if smbVol.volumeUser != "" && smbVol.subDir != "" {
+		klog.V(2).Infof("create subdirectory(%s) to grant access to user %s", smbVol.subDir, smbVol.volumeUser)
+		createSubDir = true
+	}
+	if smbVol.dirPermissions != nil {
+		klog.V(2).Infof("create subdirectory(%s) to apply permissions", smbVol.subDir)
+		createSubDir = true
+	}
+	if smbVol.restoreFromArchive != "" {
+		if req.GetVolumeContentSource() != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with volume content source", restoreFromArchiveField)
+		}
+		if len(secrets) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "provisioner secret is required to restore archive %s", smbVol.restoreFromArchive)
+		}
+		klog.V(2).Infof("create subdirectory(%s) from archive %s", smbVol.subDir, smbVol.restoreFromArchive)
+		createSubDir = true
+	}
 
 	volCap := volumeCapabilities[0]
+	// image volume is formatted in CreateVolume unless it's a block volume
+	var imageFsType string
+	if smbVol.volumeType == volumeTypeImage && volCap.GetBlock() == nil {
+		imageFsType = getImageFsType(volCap, smbVol.fsType)
+	}
+	// base share is always mounted as filesystem
+	internalMountCap := volCap
+	if volCap.GetBlock() != nil {
+		internalMountCap = nil
+	}
+	// mount options and credential mode are recorded in volume context for internal mounts of the volume
+	setKeyValueInMap(parameters, internalMountOptionsField, strings.Join(volCap.GetMount().GetMountFlags(), ","))
+	setKeyValueInMap(parameters, credentialModeField, getCredentialMode(volCap.GetMount().GetMountFlags()))
 	if volCap.GetMount() != nil {
 		options := volCap.GetMount().GetMountFlags()
 		if !createSubDir && hasGuestMountOptions(options) {
@@ -105,14 +174,37 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		})
 	}
 
-	if acquired := d.volumeLocks.TryAcquire(name); !acquired {
+	token, acquired := d.volumeLocks.Acquire(ctx, name, "CreateVolume")
+	if !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, name)
 	}
-	defer d.volumeLocks.Release(name)
+	defer d.volumeLocks.Release(name, token)
+
+	// volume user is created first since a new share is only valid for it, it's deleted with its secret
+	// if CreateVolume fails, so that no account is left without a volume
+	created := false
+	if smbVol.volumeUser != "" {
+		defer func() {
+			if !created {
+				if err := d.deleteVolumeUser(ctx, smbVol); err != nil {
+					klog.Warningf("failed to clean up user %s of volume %s: %v", smbVol.volumeUser, name, err)
+				}
+			}
+		}()
+		if err := d.createVolumeUser(ctx, smbVol, name); err != nil {
+			return nil, err
+		}
+	}
+	if smbVol.share != "" {
+		if err := d.createShare(ctx, smbVol); err != nil {
+			return nil, err
+		}
+		setKeyValueInMap(parameters, sourceField, smbVol.source)
+	}
 
 	if createSubDir {
 		// Mount smb base share so we can create a subdirectory
-		if err := d.internalMount(ctx, smbVol, volCap, secrets); err != nil {
+		if err := d.internalMount(ctx, smbVol, internalMountCap, secrets); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to mount smb server: %v", err)
 		}
 		defer func() {
@@ -120,11 +212,30 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				klog.Warningf("failed to unmount smb server: %v", err)
 			}
 		}()
-		// Create subdirectory under base-dir
-		// TODO: revisit permissions
-		internalVolumePath := getInternalVolumePath(d.workingMountDir, smbVol)
-		if err = os.MkdirAll(internalVolumePath, 0777); err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to make subdirectory: %v", err)
+		if smbVol.restoreFromArchive != "" {
+			// restored directory keeps the data and permissions of the archive
+			if err := d.restoreArchive(ctx, smbVol, parameters, secrets); err != nil {
+				return nil, err
+			}
+			setKeyValueInMap(parameters, restoreFromArchiveField, smbVol.restoreFromArchive)
+		} else {
+			// Create subdirectory under base-dir
+			internalVolumePath := getInternalVolumePath(d.workingMountDir, smbVol)
+			release, err := d.controllerOpLimiter.Acquire(ctx, smbVol.source, serverOpMkdir)
+			if err != nil {
+				return nil, err
+			}
+			err = makeVolumeDir(getInternalMountPath(d.workingMountDir, smbVol), smbVol.subDir, smbVol.dirPermissions)
+			if err == nil && smbVol.volumeType == volumeTypeImage && req.GetVolumeContentSource() == nil {
+				if err = createImageFile(internalVolumePath, smbVol.size, imageFsType); err != nil {
+					release()
+					return nil, status.Errorf(codes.Internal, "failed to create image file: %v", err)
+				}
+			}
+			release()
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to make subdirectory: %v", err)
+			}
 		}
 
 		if req.GetVolumeContentSource() != nil {
@@ -133,9 +244,23 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			}
 		}
 
+		// marker copied from source volume or archive is replaced
+		overwrite := req.GetVolumeContentSource() != nil || smbVol.restoreFromArchive != ""
+		if smbVol.subDir != "" {
+			if err := writeVolumeMarker(getInternalVolumePath(d.workingMountDir, smbVol), smbVol.id, name, overwrite); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to write volume marker: %v", err)
+			}
+		}
+
 		setKeyValueInMap(parameters, subDirField, smbVol.subDir)
 	} else {
 		klog.V(2).Infof("CreateVolume(%s) does not create subdirectory", name)
+		for k, v := range parameters {
+			if strings.EqualFold(k, subDirField) && hasExtendedTemplate(v) {
+				// node could only replace pv/pvc name namespace metadata in subDir
+				parameters[k] = smbVol.subDir
+			}
+		}
 
 		if req.GetVolumeContentSource() != nil {
 			if err := d.copyVolume(ctx, req, smbVol); err != nil {
@@ -143,7 +268,19 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			}
 		}
 	}
-	return &csi.CreateVolumeResponse{Volume: d.smbVolToCSI(smbVol, req, parameters)}, nil
+
+	if smbVol.volumeUser != "" {
+		if err := d.grantVolumeUser(ctx, smbVol); err != nil {
+			return nil, err
+		}
+		// node mounts the volume with the credentials of volume user
+		setKeyValueInMap(parameters, secretNameField, smbVol.volumeUser)
+		setKeyValueInMap(parameters, secretNamespaceField, smbVol.volumeUserSecretNamespace)
+	}
+	volume := d.smbVolToCSI(smbVol, req, parameters)
+	volume.AccessibleTopology = accessibleTopology
+	created = true
+	return &csi.CreateVolumeResponse{Volume: volume}, nil
 }
 
 // DeleteVolume only supports static provisioning, no delete volume action
@@ -159,34 +296,25 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
-	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
+	token, acquired := d.volumeLocks.Acquire(ctx, volumeID, "DeleteVolume")
+	if !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
 	}
-	defer d.volumeLocks.Release(volumeID)
+	defer d.volumeLocks.Release(volumeID, token)
 
 	secrets := req.GetSecrets()
-	mountOptions := getMountOptions(secrets)
-	if mountOptions != "" {
-		klog.V(2).Infof("DeleteVolume: found mountOptions(%v) for volume(%s)", mountOptions, volumeID)
-	}
-	// set default file/dir mode
-	volCap := &csi.VolumeCapability{
-		AccessType: &csi.VolumeCapability_Mount{
-			Mount: &csi.VolumeCapability_MountVolume{
-				MountFlags: appendMountOptions([]string{mountOptions},
-					map[string]string{
-						fileMode: defaultFileMode,
-						dirMode:  defaultDirMode,
-					}),
-			},
-		},
-	}
 
 	if smbVol.onDelete == "" {
 		smbVol.onDelete = d.defaultOnDeletePolicy
 	}
 
-	if len(req.GetSecrets()) > 0 && !strings.EqualFold(smbVol.onDelete, retain) {
+	if smbVol.share != "" {
+		if strings.EqualFold(smbVol.onDelete, retain) {
+			klog.V(2).Infof("DeleteVolume(%s) does not delete share %s", volumeID, smbVol.share)
+		} else if err := d.deleteShare(ctx, smbVol); err != nil {
+			return nil, err
+		}
+	} else if len(req.GetSecrets()) > 0 && !strings.EqualFold(smbVol.onDelete, retain) {
 		klog.V(2).Infof("begin to delete or archive subdirectory since secret is provided")
 		// check whether volumeID is in the cache
 		cache, err := d.volDeletionCache.Get(volumeID, azcache.CacheReadTypeDefault)
@@ -198,8 +326,16 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 			return &csi.DeleteVolumeResponse{}, nil
 		}
 
+		mountOptions, err := d.getVolumeMountOptions(ctx, volumeID, secrets)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if mountOptions != "" {
+			klog.V(2).Infof("DeleteVolume: found mountOptions(%v) for volume(%s)", mountOptions, volumeID)
+		}
+		smbVol.alternateServers = d.getVolumeAlternateServers(ctx, volumeID)
 		// mount smb base share so we can delete or archive the subdirectory
-		if err = d.internalMount(ctx, smbVol, volCap, secrets); err != nil {
+		if err = d.internalMount(ctx, smbVol, getInternalMountVolCap(mountOptions), secrets); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to mount smb server: %v", err)
 		}
 		defer func() {
@@ -208,55 +344,63 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 			}
 		}()
 
-		internalVolumePath := getInternalVolumePath(d.workingMountDir, smbVol)
-		if strings.EqualFold(smbVol.onDelete, archive) {
-			archivedInternalVolumePath := filepath.Join(getInternalMountPath(d.workingMountDir, smbVol), "archived-"+smbVol.subDir)
-
-			if strings.Contains(smbVol.subDir, "/") {
-				parentDir := filepath.Dir(archivedInternalVolumePath)
-				klog.V(2).Infof("DeleteVolume: subdirectory(%s) contains '/', make sure the parent directory(%s) exists", smbVol.subDir, parentDir)
-				if err = os.MkdirAll(parentDir, 0777); err != nil {
-					return nil, status.Errorf(codes.Internal, "create parent directory(%s) of %s failed with %v", parentDir, archivedInternalVolumePath, err)
-				}
-			}
+		if err := d.checkDeletionSafety(smbVol, volumeID); err != nil {
+			d.warnDeletionRefused(ctx, volumeID, err)
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 
-			// archive subdirectory under base-dir. Remove stale archived copy if exists.
-			klog.V(2).Infof("archiving subdirectory %s --> %s", internalVolumePath, archivedInternalVolumePath)
-			if d.removeArchivedVolumePath {
-				klog.V(2).Infof("removing archived subdirectory at %v", archivedInternalVolumePath)
-				if err = os.RemoveAll(archivedInternalVolumePath); err != nil {
-					return nil, status.Errorf(codes.Internal, "failed to delete archived subdirectory %s: %v", archivedInternalVolumePath, err)
-				}
-				klog.V(2).Infof("removed archived subdirectory at %v", archivedInternalVolumePath)
-			}
-			if err = os.Rename(internalVolumePath, archivedInternalVolumePath); err != nil {
-				return nil, status.Errorf(codes.Internal, "archive subdirectory(%s, %s) failed with %v", internalVolumePath, archivedInternalVolumePath, err)
+		if strings.EqualFold(smbVol.onDelete, archive) {
+			// archive subdirectory under base-dir or to the archive location in storage class
+			if err = d.archiveVolume(ctx, volumeID, smbVol, secrets); err != nil {
+				return nil, err
 			}
 		} else {
-			if _, err := os.Lstat(internalVolumePath); err == nil {
-				if err2 := filepath.WalkDir(internalVolumePath, func(path string, _ fs.DirEntry, _ error) error {
-					return os.Chmod(path, 0777)
-				}); err2 != nil {
-					klog.Errorf("failed to chmod subdirectory: %v", err2)
-				}
+			internalVolumePath := getInternalVolumePath(d.workingMountDir, smbVol)
+			release, err := d.controllerOpLimiter.Acquire(ctx, smbVol.source, serverOpDelete)
+			if err != nil {
+				return nil, err
 			}
-
-			rootDir := getRootDir(smbVol.subDir)
-			if rootDir != "" {
-				rootDir = filepath.Join(getInternalMountPath(d.workingMountDir, smbVol), rootDir)
+			defer release()
+			if d.trashDeleter != nil {
+				// large subdirectory takes too long to delete over SMB, move it to trash and delete it in background
+				if _, err := os.Lstat(internalVolumePath); err == nil {
+					if err = moveToTrash(internalVolumePath, getInternalMountPath(d.workingMountDir, smbVol), volumeID); err != nil {
+						return nil, status.Errorf(codes.Internal, "failed to move subdirectory to trash: %v", err)
+					}
+				}
+				d.trashDeleter.add(smbVol.source, mountOptions, secrets)
 			} else {
-				rootDir = internalVolumePath
-			}
+				if _, err := os.Lstat(internalVolumePath); err == nil {
+					if err2 := filepath.WalkDir(internalVolumePath, func(path string, _ fs.DirEntry, _ error) error {
+						return os.Chmod(path, 0777)
+					}); err2 != nil {
+						klog.Errorf("failed to chmod subdirectory: %v", err2)
+					}
+				}
+
+				rootDir := getRootDir(smbVol.subDir)
+				if rootDir != "" {
+					rootDir = filepath.Join(getInternalMountPath(d.workingMountDir, smbVol), rootDir)
+				} else {
+					rootDir = internalVolumePath
+				}
 
-			klog.V(2).Infof("removing subdirectory at %v on internalVolumePath %s", rootDir, internalVolumePath)
-			if err = os.RemoveAll(internalVolumePath); err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to delete subdirectory: %v", err)
+				klog.V(2).Infof("removing subdirectory at %v on internalVolumePath %s", rootDir, internalVolumePath)
+				if err = removeVolumeDirectory(internalVolumePath); err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to delete subdirectory: %v", err)
+				}
 			}
 		}
 	} else {
 		klog.V(2).Infof("DeleteVolume(%s) does not delete subdirectory", volumeID)
 	}
 
+	if smbVol.volumeUser != "" {
+		if err := d.deleteVolumeUser(ctx, smbVol); err != nil {
+			return nil, err
+		}
+	}
+
 	d.volDeletionCache.Set(volumeID, "")
 	return &csi.DeleteVolumeResponse{}, nil
 }
@@ -285,7 +429,7 @@ func (d *Driver) ValidateVolumeCapabilities(_ context.Context, req *csi.Validate
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
-	if err := isValidVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities(), req.GetVolumeContext()); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
@@ -308,8 +452,9 @@ func (d *Driver) ListVolumes(_ context.Context, _ *csi.ListVolumesRequest) (*csi
 }
 
 // ControllerExpandVolume expand volume
-func (d *Driver) ControllerExpandVolume(_ context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	if len(req.GetVolumeId()) == 0 {
+func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
 
@@ -318,21 +463,157 @@ func (d *Driver) ControllerExpandVolume(_ context.Context, req *csi.ControllerEx
 	}
 
 	volSizeBytes := int64(req.GetCapacityRange().GetRequiredBytes())
-	klog.V(2).Infof("ControllerExpandVolume(%s) successfully, currentQuota: %d bytes", req.VolumeId, volSizeBytes)
+	secrets := req.GetSecrets()
+	smbVol, err := getSmbVolFromID(volumeID)
+	if err != nil && len(secrets) > 0 {
+		return nil, status.Errorf(codes.NotFound, "failed to get smb volume for volume id %s: %v", volumeID, err)
+	}
+	if err != nil || !d.isImageVolumeID(ctx, smbVol) {
+		// image volume is always recorded in its volume id or PV, a smb share volume has nothing to expand
+		klog.V(2).Infof("ControllerExpandVolume(%s) successfully, currentQuota: %d bytes", volumeID, volSizeBytes)
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: volSizeBytes}, nil
+	}
+	if len(secrets) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "secrets are required to expand image file of %s volume %s, set csi.storage.k8s.io/controller-expand-secret-name in storage class", volumeTypeImage, volumeID)
+	}
+
+	token, acquired := d.volumeLocks.Acquire(ctx, volumeID, "ControllerExpandVolume")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer d.volumeLocks.Release(volumeID, token)
+
+	mountOptions, err := d.getVolumeMountOptions(ctx, volumeID, secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{mountOptions},
+			},
+		},
+	}
+	smbVol.alternateServers = d.getVolumeAlternateServers(ctx, volumeID)
+	if err = d.internalMount(ctx, smbVol, volCap, secrets); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to mount smb server: %v", err)
+	}
+	defer func() {
+		if err = d.internalUnmount(ctx, smbVol); err != nil {
+			klog.Warningf("failed to unmount smb server: %v", err)
+		}
+	}()
 
-	return &csi.ControllerExpandVolumeResponse{CapacityBytes: req.GetCapacityRange().GetRequiredBytes()}, nil
+	imagePath := filepath.Join(getInternalVolumePath(d.workingMountDir, smbVol), imageFileName)
+	size, err := expandImageFile(imagePath, volSizeBytes)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "image file %s of volume %s is not found", imagePath, volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to expand image file %s: %v", imagePath, err)
+	}
+	klog.V(2).Infof("ControllerExpandVolume(%s) expanded image file %s to %d bytes", volumeID, imagePath, size)
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: size, NodeExpansionRequired: true}, nil
 }
 
+// CreateSnapshot is not supported, snapshots are taken on SMB server and imported by VolumeSnapshotContent
 func (d *Driver) CreateSnapshot(_ context.Context, _ *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	return nil, status.Error(codes.Unimplemented, "creating snapshot is not supported, import an existing @GMT snapshot listed by ListSnapshots with VolumeSnapshotContent")
 }
 
-func (d *Driver) DeleteSnapshot(_ context.Context, _ *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// DeleteSnapshot does not delete the snapshot on SMB server, which is managed by server retention policy
+func (d *Driver) DeleteSnapshot(_ context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if len(req.GetSnapshotId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID missing in request")
+	}
+	klog.V(2).Infof("DeleteSnapshot(%s): snapshot is managed by SMB server, skip deletion", req.GetSnapshotId())
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
-func (d *Driver) ListSnapshots(_ context.Context, _ *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// ListSnapshots lists the @GMT snapshots (previous versions) of the subdirectory of a volume on SMB server
+func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max entries %d", req.GetMaxEntries())
+	}
+	volumeID := req.GetSourceVolumeId()
+	var token string
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		srcVolumeID, t, err := splitSnapshotID(snapshotID)
+		if err != nil || (volumeID != "" && volumeID != srcVolumeID) {
+			klog.V(2).Infof("ListSnapshots: snapshot %s not found: %v", snapshotID, err)
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		volumeID, token = srcVolumeID, t
+	}
+	if volumeID == "" {
+		// snapshots are discovered per volume
+		klog.V(2).Infof("ListSnapshots: neither source volume id nor snapshot id is specified")
+		return &csi.ListSnapshotsResponse{}, nil
+	}
+	smbVol, err := getSmbVolFromID(volumeID)
+	if err != nil {
+		klog.V(2).Infof("ListSnapshots: invalid volume id %s: %v", volumeID, err)
+		return &csi.ListSnapshotsResponse{}, nil
+	}
+
+	lock, acquired := d.volumeLocks.Acquire(ctx, volumeID, "ListSnapshots")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer d.volumeLocks.Release(volumeID, lock)
+
+	secrets := req.GetSecrets()
+	mountOptions, err := d.getVolumeMountOptions(ctx, volumeID, secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{mountOptions},
+			},
+		},
+	}
+	smbVol.alternateServers = d.getVolumeAlternateServers(ctx, volumeID)
+	if err = d.internalMount(ctx, smbVol, volCap, secrets); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to mount smb server: %v", err)
+	}
+	defer func() {
+		if err = d.internalUnmount(ctx, smbVol); err != nil {
+			klog.Warningf("failed to unmount smb server: %v", err)
+		}
+	}()
+
+	internalVolumePath := getInternalVolumePath(d.workingMountDir, smbVol)
+	tokens, err := listShadowCopies(internalVolumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list snapshots of %s: %v", internalVolumePath, err)
+	}
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, t := range tokens {
+		if token != "" && t != token {
+			continue
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: newSnapshot(volumeID, t)})
+	}
+	klog.V(2).Infof("ListSnapshots: found %d snapshots of volume %s", len(entries), volumeID)
+	return paginateSnapshots(entries, req.GetStartingToken(), req.GetMaxEntries())
+}
+
+// getInternalMountVolCap returns the volume capability to mount smb server at base-dir in controller
+// with mountOptions in secrets and default file/dir mode
+func getInternalMountVolCap(mountOptions string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: appendMountOptions([]string{mountOptions},
+					map[string]string{
+						fileMode: defaultFileMode,
+						dirMode:  defaultDirMode,
+					}),
+			},
+		},
+	}
 }
 
 // Mount smb server at base-dir
@@ -347,15 +628,25 @@ func (d *Driver) internalMount(ctx context.Context, vol *smbVolume, volCap *csi.
 		}
 	}
 
+	release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpInternalMount)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	volumeContext := map[string]string{
+		sourceField: vol.source,
+	}
+	if len(vol.alternateServers) > 0 {
+		volumeContext[alternateServersField] = strings.Join(vol.alternateServers, ",")
+	}
 	klog.V(4).Infof("internally mounting %v at %v", vol.source, stagingPath)
-	_, err := d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
 		StagingTargetPath: stagingPath,
-		VolumeContext: map[string]string{
-			sourceField: vol.source,
-		},
-		VolumeCapability: volCap,
-		VolumeId:         vol.id,
-		Secrets:          secrets,
+		VolumeContext:     volumeContext,
+		VolumeCapability:  volCap,
+		VolumeId:          vol.id,
+		Secrets:           secrets,
 	})
 	return err
 }
@@ -390,7 +681,16 @@ func (d *Driver) copyFromVolume(ctx context.Context, req *csi.CreateVolumeReques
 	}
 
 	secrets := req.GetSecrets()
-	if err = d.internalMount(ctx, srcVol, volCap, secrets); err != nil {
+	srcSecrets, err := d.getCloneSourceSecrets(ctx, req.GetVolumeContentSource().GetVolume().GetVolumeId(), req.GetParameters(), secrets)
+	if err != nil {
+		return err
+	}
+	srcMountOptions, err := d.getVolumeMountOptions(ctx, req.GetVolumeContentSource().GetVolume().GetVolumeId(), srcSecrets)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "source volume: %v", err)
+	}
+	srcVol.alternateServers = d.getVolumeAlternateServers(ctx, req.GetVolumeContentSource().GetVolume().GetVolumeId())
+	if err = d.internalMount(ctx, srcVol, getInternalMountVolCap(srcMountOptions), srcSecrets); err != nil {
 		return status.Errorf(codes.Internal, "failed to mount src nfs server: %v", err)
 	}
 	defer func() {
@@ -407,12 +707,29 @@ func (d *Driver) copyFromVolume(ctx context.Context, req *csi.CreateVolumeReques
 		}
 	}()
 
+	release, err := d.controllerOpLimiter.Acquire(ctx, dstVol.source, serverOpCopy)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if runtime.GOOS == "linux" && getServerFromSource(srcVol.source) == getServerFromSource(dstVol.source) {
+		// both volumes are on the same server, copy data on server instead of streaming it through controller
+		start := time.Now()
+		stats, err := copyDirectory(getInternalVolumePath(d.workingMountDir, srcVol), dstPath, true)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to copy volume %s -> %s: %v", srcPath, dstPath, err)
+		}
+		klog.V(2).Infof("copied %s -> %s in %v, method: %s", srcPath, dstPath, time.Since(start), stats.method())
+		return nil
+	}
+
 	// recursive 'cp' with '-a' to handle symlinks
 	out, err := exec.Command("cp", "-a", srcPath, dstPath).CombinedOutput()
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to copy volume %v: %v", err, string(out))
 	}
-	klog.V(2).Infof("copied %s -> %s", srcPath, dstPath)
+	klog.V(2).Infof("copied %s -> %s, method: %s", srcPath, dstPath, cloneMethodClientSideCopy)
 	return nil
 }
 
@@ -428,18 +745,6 @@ func (d *Driver) copyVolume(ctx context.Context, req *csi.CreateVolumeRequest, v
 	}
 }
 
-// Given a smbVolume, return a CSI volume id
-func getVolumeIDFromSmbVol(vol *smbVolume) string {
-	idElements := make([]string, totalIDElements)
-	idElements[idSource] = strings.Trim(vol.source, "/")
-	idElements[idSubDir] = strings.Trim(vol.subDir, "/")
-	idElements[idUUID] = vol.uuid
-	if strings.EqualFold(vol.onDelete, retain) || strings.EqualFold(vol.onDelete, archive) {
-		idElements[idOnDelete] = vol.onDelete
-	}
-	return strings.Join(idElements, separator)
-}
-
 // getInternalMountPath: get working directory for CreateVolume and DeleteVolume
 func getInternalMountPath(workingMountDir string, vol *smbVolume) string {
 	if vol == nil {
@@ -453,8 +758,11 @@ func getInternalMountPath(workingMountDir string, vol *smbVolume) string {
 }
 
 // Convert VolumeCreate parameters to an smbVolume
-func newSMBVolume(name string, size int64, params map[string]string, defaultOnDeletePolicy string) (*smbVolume, error) {
-	var source, subDir, onDelete string
+func newSMBVolume(name string, size int64, params map[string]string, defaultOnDeletePolicy string, pvc *v1.PersistentVolumeClaim) (*smbVolume, error) {
+	var source, subDir, onDelete, volumeType, fsType, sharePath, shareValidUsers, pvcNamespace, restoreFromArchive string
+	var perVolumeUser bool
+	var perms *dirPermissions
+	var alternateServers []string
 	subDirReplaceMap := map[string]string{}
 
 	// validate parameters (case-insensitive).
@@ -466,8 +774,52 @@ func newSMBVolume(name string, size int64, params map[string]string, defaultOnDe
 			subDir = v
 		case paramOnDelete:
 			onDelete = v
+		case volumeTypeField:
+			volumeType = strings.ToLower(v)
+		case fsTypeField:
+			fsType = strings.ToLower(v)
+		case sharePathField:
+			sharePath = v
+		case shareValidUsersField:
+			shareValidUsers = v
+		case perVolumeUserField:
+			perVolumeUser = strings.EqualFold(v, trueValue)
+		case subDirUIDField, subDirGIDField, subDirModeField, parentDirModeField, subDirSDDLField:
+			if perms == nil {
+				perms = newDirPermissions()
+			}
+			if err := perms.parse(strings.ToLower(k), v); err != nil {
+				return nil, err
+			}
+		case pvcMountOptionOverridesField:
+			if _, err := parseMountOptionOverrides(v); err != nil {
+				return nil, err
+			}
+		case archiveMaxAgeField, archiveMaxCountField:
+			if _, err := parseArchiveRetention(map[string]string{k: v}); err != nil {
+				return nil, err
+			}
+		case archiveSourceField, archiveDirField, archiveSecretNameField, archiveSecretNamespaceField:
+			// validated by parseArchiveLocation below, used in DeleteVolume
+		case restoreFromArchiveField:
+			restoreFromArchive = v
+		case crossNamespaceRestoreField:
+			// checked by restoreArchive
+		case pvcSubDirOverrideField, pvcMountOptionsField:
+			// applied from PVC annotations by applyPVCOverrides, mount options are merged on node
+		case srcSecretNameField, srcSecretNamespaceField:
+			// used by copyFromVolume to mount the source volume
+		case sourcesField, placementPolicyField:
+			// source is chosen from sources by placeVolume
+		case alternateServersField:
+			// servers are tried in order by NodeStageVolume and internal mounts if the server of source could not be mounted
+			var err error
+			if alternateServers, err = parseAlternateServers(v); err != nil {
+				return nil, err
+			}
 		case pvcNamespaceKey:
 			subDirReplaceMap[pvcNamespaceMetadata] = v
+			pvcNamespace = v
 		case pvcNameKey:
 			subDirReplaceMap[pvcNameMetadata] = v
 		case pvNameKey:
@@ -480,17 +832,79 @@ func newSMBVolume(name string, size int64, params map[string]string, defaultOnDe
 	if source == "" {
 		return nil, fmt.Errorf("%v is a required parameter", sourceField)
 	}
+	if _, err := parseArchiveLocation(params); err != nil {
+		return nil, err
+	}
+
+	if err := validateVolumeType(volumeType); err != nil {
+		return nil, err
+	}
+	if err := validateImageFsType(fsType); err != nil {
+		return nil, err
+	}
+	if volumeType == volumeTypeImage && size <= 0 {
+		return nil, fmt.Errorf("capacity is required for %s volume", volumeTypeImage)
+	}
+
+	if volumeType == volumeTypeImage && len(alternateServers) > 0 {
+		return nil, fmt.Errorf("%s of %s volume is not supported", alternateServersField, volumeTypeImage)
+	}
 
 	vol := &smbVolume{
-		source: source,
-		size:   size,
+		source:           source,
+		size:             size,
+		volumeType:       volumeType,
+		fsType:           fsType,
+		dirPermissions:   perms,
+		alternateServers: alternateServers,
+	}
+	if volumeType == volumeTypeImage {
+		// record volume type in volume id, so image volume could be identified without mounting the share
+		vol.idAttributes = map[string]string{volumeTypeField: volumeTypeImage}
+	}
+	if perVolumeUser {
+		if pvcNamespace == "" {
+			return nil, fmt.Errorf("%s requires %s in parameters, enable --extra-create-metadata in csi-provisioner", perVolumeUserField, pvcNamespaceKey)
+		}
+		vol.volumeUser = getVolumeUser(name)
+		vol.volumeUserSecretNamespace = pvcNamespace
 	}
-	if subDir == "" {
+	if sharePath != "" {
+		// create a share named by pv name, volume is the root of the share unless subDir is specified
+		vol.share = name
+		renderedSharePath, err := renderTemplate(sharePath, subDirReplaceMap, name, pvc)
+		if err != nil {
+			return nil, err
+		}
+		vol.sharePath = path.Join(renderedSharePath, name)
+		if vol.shareValidUsers, err = renderTemplate(shareValidUsers, subDirReplaceMap, name, pvc); err != nil {
+			return nil, err
+		}
+		if vol.volumeUser != "" {
+			vol.shareValidUsers = strings.TrimLeft(vol.shareValidUsers+", "+vol.volumeUser, ", ")
+		}
+		vol.source = getShareSource(source, name)
+		if vol.subDir, err = renderTemplate(subDir, subDirReplaceMap, name, pvc); err != nil {
+			return nil, err
+		}
+		vol.uuid = name
+		if err := validateShareName(vol.share); err != nil {
+			return nil, err
+		}
+		if err := validateSharePath(vol.sharePath); err != nil {
+			return nil, err
+		}
+	} else if shareValidUsers != "" {
+		return nil, fmt.Errorf("%s is only supported with %s", shareValidUsersField, sharePathField)
+	} else if subDir == "" {
 		// use pv name by default if not specified
 		vol.subDir = name
 	} else {
-		// replace pv/pvc name namespace metadata in subDir
-		vol.subDir = replaceWithMap(subDir, subDirReplaceMap)
+		// replace pv/pvc name namespace metadata and other template variables in subDir
+		var err error
+		if vol.subDir, err = renderTemplate(subDir, subDirReplaceMap, name, pvc); err != nil {
+			return nil, err
+		}
 		// make volume id unique if subDir is provided
 		vol.uuid = name
 	}
@@ -505,6 +919,19 @@ func newSMBVolume(name string, size int64, params map[string]string, defaultOnDe
 		return nil, fmt.Errorf("invalid subDir %q: %v", vol.subDir, err)
 	}
 
+	if restoreFromArchive != "" {
+		if vol.share != "" {
+			return nil, fmt.Errorf("%s is not supported with %s", restoreFromArchiveField, sharePathField)
+		}
+		var err error
+		if vol.restoreFromArchive, err = renderTemplate(restoreFromArchive, subDirReplaceMap, name, pvc); err != nil {
+			return nil, err
+		}
+		if err := validateArchiveName(vol.restoreFromArchive); err != nil {
+			return nil, err
+		}
+	}
+
 	vol.onDelete = defaultOnDeletePolicy
 	if onDelete != "" {
 		vol.onDelete = onDelete
@@ -527,49 +954,18 @@ func getInternalVolumePath(workingMountDir string, vol *smbVolume) string {
 
 // Convert into smbVolume into a csi.Volume
 func (d *Driver) smbVolToCSI(vol *smbVolume, req *csi.CreateVolumeRequest, parameters map[string]string) *csi.Volume {
+	var capacity int64 // by setting it to zero, Provisioner will use PVC requested size as PV size
+	if vol.volumeType == volumeTypeImage {
+		capacity = vol.size
+	}
 	return &csi.Volume{
-		CapacityBytes: 0, // by setting it to zero, Provisioner will use PVC requested size as PV size
+		CapacityBytes: capacity,
 		VolumeId:      vol.id,
 		VolumeContext: parameters,
 		ContentSource: req.GetVolumeContentSource(),
 	}
 }
 
-// Given a CSI volume id, return a smbVolume
-// sample volume Id:
-//
-//	smb-server.default.svc.cluster.local/share#pvc-4729891a-f57e-4982-9c60-e9884af1be2f
-//	smb-server.default.svc.cluster.local/share#subdir#pvc-4729891a-f57e-4982-9c60-e9884af1be2f
-func getSmbVolFromID(id string) (*smbVolume, error) {
-	segments := strings.Split(id, separator)
-	if len(segments) < 2 {
-		return nil, fmt.Errorf("could not split %q into server and subDir", id)
-	}
-	source := segments[0]
-	if !strings.HasPrefix(segments[0], "//") {
-		source = "//" + source
-	}
-	subDir := segments[1]
-	if err := validatePath(subDir); err != nil {
-		return nil, fmt.Errorf("invalid subDir %q: %v", subDir, err)
-	}
-	vol := &smbVolume{
-		id:     id,
-		source: source,
-		subDir: subDir,
-	}
-	if len(segments) >= 3 {
-		vol.uuid = segments[2]
-		if err := validatePath(vol.uuid); err != nil {
-			return nil, fmt.Errorf("invalid uuid %q: %v", vol.uuid, err)
-		}
-	}
-	if len(segments) >= 4 {
-		vol.onDelete = segments[3]
-	}
-	return vol, nil
-}
-
 // isValidVolumeCapabilities validates the given VolumeCapability array is valid
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
 	if len(volCaps) == 0 {
@@ -582,3 +978,37 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
 	}
 	return nil
 }
+
+// createShare creates the share of vol with share backend
+func (d *Driver) createShare(ctx context.Context, vol *smbVolume) error {
+	release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpShare)
+	if err != nil {
+		return err
+	}
+	defer release()
+	share := &smbShare{
+		name:       vol.share,
+		path:       vol.sharePath,
+		validUsers: vol.shareValidUsers,
+	}
+	if err := d.shareBackend.CreateShare(ctx, share); err != nil {
+		return status.Errorf(codes.Internal, "failed to create share %s: %v", vol.share, err)
+	}
+	return nil
+}
+
+// deleteShare deletes or archives the share of vol with share backend
+func (d *Driver) deleteShare(ctx context.Context, vol *smbVolume) error {
+	if d.shareBackend == nil {
+		return status.Errorf(codes.FailedPrecondition, "share backend is not configured in driver to delete share %s", vol.share)
+	}
+	release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpShare)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := d.shareBackend.DeleteShare(ctx, vol.share, strings.EqualFold(vol.onDelete, archive)); err != nil {
+		return status.Errorf(codes.Internal, "failed to delete share %s: %v", vol.share, err)
+	}
+	return nil
+}
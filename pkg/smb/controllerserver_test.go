@@ -24,12 +24,16 @@ import (
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-driver-smb/test/utils/testutil"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const (
@@ -115,8 +119,10 @@ func TestCreateVolume(t *testing.T) {
 				Volume: &csi.Volume{
 					VolumeId: testVolumeID,
 					VolumeContext: map[string]string{
-						sourceField: testServer,
-						subDirField: testCSIVolume,
+						sourceField:               testServer,
+						subDirField:               testCSIVolume,
+						internalMountOptionsField: "",
+						credentialModeField:       credentialModePassword,
 					},
 				},
 			},
@@ -440,6 +446,116 @@ func TestControllerExpandVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "invalid volume ID with secrets",
+			testFunc: func(t *testing.T) {
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId: "unit-test",
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 10000,
+					},
+					Secrets: map[string]string{usernameField: "test", passwordField: "test"},
+				}
+				_, err := d.ControllerExpandVolume(context.Background(), req)
+				assert.Equal(t, codes.NotFound, status.Code(err))
+			},
+		},
+		{
+			name: "share volume with disk.img in its data",
+			testFunc: func(t *testing.T) {
+				if runtime.GOOS == "windows" {
+					t.Skip("skipping on windows: internal mount requires csi-proxy mounter")
+				}
+				d.workingMountDir = t.TempDir()
+				mounter, err := NewFakeMounter()
+				if err != nil {
+					t.Fatalf("failed to get fake mounter: %v", err)
+				}
+				d.mounter = mounter
+
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId: testVolumeID,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 2 << 20,
+					},
+					Secrets: map[string]string{usernameField: "test", passwordField: "test"},
+				}
+				// smb share volume without image file
+				resp, err := d.ControllerExpandVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2<<20), resp.GetCapacityBytes())
+				assert.False(t, resp.GetNodeExpansionRequired())
+
+				imageDir := filepath.Join(d.workingMountDir, testCSIVolume, testCSIVolume)
+				assert.NoError(t, os.MkdirAll(imageDir, 0750))
+				imagePath := filepath.Join(imageDir, imageFileName)
+				assert.NoError(t, os.WriteFile(imagePath, nil, 0600))
+				assert.NoError(t, os.Truncate(imagePath, 1<<20))
+
+				// disk.img of a volume which is not recorded as image volume is user data
+				resp, err = d.ControllerExpandVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2<<20), resp.GetCapacityBytes())
+				assert.False(t, resp.GetNodeExpansionRequired())
+				info, err := os.Stat(imagePath)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1<<20), info.Size())
+			},
+		},
+		{
+			name: "image volume recorded in volume id",
+			testFunc: func(t *testing.T) {
+				if runtime.GOOS == "windows" {
+					t.Skip("skipping on windows: internal mount requires csi-proxy mounter")
+				}
+				d.workingMountDir = t.TempDir()
+				mounter, err := NewFakeMounter()
+				if err != nil {
+					t.Fatalf("failed to get fake mounter: %v", err)
+				}
+				d.mounter = mounter
+
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId: "v2:source=test-server%2FbaseDir&subdir=test-csi&volumetype=image",
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 2 << 20,
+					},
+				}
+				_, err = d.ControllerExpandVolume(context.Background(), req)
+				assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+				req.Secrets = map[string]string{usernameField: "test", passwordField: "test"}
+				_, err = d.ControllerExpandVolume(context.Background(), req)
+				assert.Equal(t, codes.NotFound, status.Code(err))
+			},
+		},
+		{
+			name: "image volume recorded in PV",
+			testFunc: func(t *testing.T) {
+				d.kubeClient = fake.NewSimpleClientset(&v1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: testCSIVolume},
+					Spec: v1.PersistentVolumeSpec{
+						PersistentVolumeSource: v1.PersistentVolumeSource{
+							CSI: &v1.CSIPersistentVolumeSource{
+								Driver:           d.Name,
+								VolumeHandle:     testVolumeID,
+								VolumeAttributes: map[string]string{"volumeType": volumeTypeImage},
+							},
+						},
+					},
+				})
+				defer func() { d.kubeClient = nil }()
+
+				req := &csi.ControllerExpandVolumeRequest{
+					VolumeId: testVolumeID,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 2 << 20,
+					},
+				}
+				_, err := d.ControllerExpandVolume(context.Background(), req)
+				assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -462,9 +578,7 @@ func TestCreateSnapshot(t *testing.T) {
 	req := csi.CreateSnapshotRequest{}
 	resp, err := d.CreateSnapshot(context.Background(), &req)
 	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
-		t.Errorf("Unexpected error: %v", err)
-	}
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
 }
 
 func TestDeleteSnapshot(t *testing.T) {
@@ -472,19 +586,114 @@ func TestDeleteSnapshot(t *testing.T) {
 	req := csi.DeleteSnapshotRequest{}
 	resp, err := d.DeleteSnapshot(context.Background(), &req)
 	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
+	if !reflect.DeepEqual(err, status.Error(codes.InvalidArgument, "Snapshot ID missing in request")) {
 		t.Errorf("Unexpected error: %v", err)
 	}
+
+	// snapshot on server is not deleted
+	req.SnapshotId = testVolumeID + "#@GMT-2024.01.02-03.04.05"
+	resp, err = d.DeleteSnapshot(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
 }
 
 func TestListSnapshots(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("listing snapshots is only supported on linux")
+	}
 	d := NewFakeDriver()
-	req := csi.ListSnapshotsRequest{}
-	resp, err := d.ListSnapshots(context.Background(), &req)
-	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
-		t.Errorf("Unexpected error: %v", err)
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
 	}
+	d.mounter = mounter
+
+	internalVolumePath := filepath.Join(d.workingMountDir, testCSIVolume, testCSIVolume)
+	runner := &fakeCommandRunner{
+		outputs: map[string]string{
+			"smbinfo list-snapshots " + internalVolumePath: "Number of snapshots: 3 Number of snapshots returned: 3\n" +
+				"Snapshot list in GMT format\n@GMT-2024.01.02-03.00.00\n@GMT-2024.01.02-01.00.00\n@GMT-2024.01.02-02.00.00\n",
+		},
+	}
+	setFakeCommandRunner(t, runner)
+
+	snapshotIDs := func(resp *csi.ListSnapshotsResponse) []string {
+		var ids []string
+		for _, e := range resp.GetEntries() {
+			ids = append(ids, e.GetSnapshot().GetSnapshotId())
+		}
+		return ids
+	}
+	secrets := map[string]string{usernameField: "test", passwordField: "test"}
+
+	tests := []struct {
+		desc              string
+		req               *csi.ListSnapshotsRequest
+		expectedIDs       []string
+		expectedNextToken string
+		expectedErr       codes.Code
+	}{
+		{
+			desc: "no volume id nor snapshot id",
+			req:  &csi.ListSnapshotsRequest{},
+		},
+		{
+			desc:        "invalid max entries",
+			req:         &csi.ListSnapshotsRequest{MaxEntries: -1},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			desc: "list snapshots of volume",
+			req:  &csi.ListSnapshotsRequest{SourceVolumeId: testVolumeID, Secrets: secrets},
+			expectedIDs: []string{
+				testVolumeID + "#@GMT-2024.01.02-01.00.00",
+				testVolumeID + "#@GMT-2024.01.02-02.00.00",
+				testVolumeID + "#@GMT-2024.01.02-03.00.00",
+			},
+		},
+		{
+			desc:              "list snapshots with pagination",
+			req:               &csi.ListSnapshotsRequest{SourceVolumeId: testVolumeID, Secrets: secrets, MaxEntries: 1, StartingToken: "1"},
+			expectedIDs:       []string{testVolumeID + "#@GMT-2024.01.02-02.00.00"},
+			expectedNextToken: "2",
+		},
+		{
+			desc:        "invalid starting token",
+			req:         &csi.ListSnapshotsRequest{SourceVolumeId: testVolumeID, Secrets: secrets, StartingToken: "10"},
+			expectedErr: codes.Aborted,
+		},
+		{
+			desc:        "get snapshot by id",
+			req:         &csi.ListSnapshotsRequest{SnapshotId: testVolumeID + "#@GMT-2024.01.02-02.00.00", Secrets: secrets},
+			expectedIDs: []string{testVolumeID + "#@GMT-2024.01.02-02.00.00"},
+		},
+		{
+			desc: "snapshot not found",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: testVolumeID + "#@GMT-2020.01.01-00.00.00", Secrets: secrets},
+		},
+		{
+			desc: "invalid snapshot id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: testVolumeID},
+		},
+		{
+			desc: "snapshot id does not match source volume id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: testVolumeID + "#@GMT-2024.01.02-02.00.00", SourceVolumeId: "test-server/baseDir#other##"},
+		},
+	}
+	for _, test := range tests {
+		resp, err := d.ListSnapshots(context.Background(), test.req)
+		assert.Equal(t, test.expectedErr, status.Code(err), test.desc)
+		assert.Equal(t, test.expectedIDs, snapshotIDs(resp), test.desc)
+		assert.Equal(t, test.expectedNextToken, resp.GetNextToken(), test.desc)
+	}
+
+	resp, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: testVolumeID + "#@GMT-2024.01.02-02.00.00", Secrets: secrets})
+	assert.NoError(t, err)
+	snapshot := resp.GetEntries()[0].GetSnapshot()
+	assert.Equal(t, testVolumeID, snapshot.GetSourceVolumeId())
+	assert.True(t, snapshot.GetReadyToUse())
+	assert.Equal(t, "2024-01-02T02:00:00Z", snapshot.GetCreationTime().AsTime().Format(time.RFC3339))
 }
 
 func TestGetSmbVolFromID(t *testing.T) {
@@ -495,6 +704,7 @@ func TestGetSmbVolFromID(t *testing.T) {
 		subDir    string
 		uuid      string
 		onDelete  string
+		share     string
 		expectErr bool
 	}{
 		{
@@ -551,6 +761,31 @@ func TestGetSmbVolFromID(t *testing.T) {
 			onDelete:  "archive",
 			expectErr: false,
 		},
+		{
+			desc:     "volume with share created by share backend",
+			volumeID: "smb-server.default.svc.cluster.local/pvc-4729891a##pvc-4729891a#archive#pvc-4729891a",
+			source:   "//smb-server.default.svc.cluster.local/pvc-4729891a",
+			uuid:     "pvc-4729891a",
+			onDelete: "archive",
+			share:    "pvc-4729891a",
+		},
+		{
+			desc:     "volume with volume user",
+			volumeID: "smb-server.default.svc.cluster.local/share#subdir#pvc-4729891a###tenant1/smb-0123456789abcdef",
+			source:   "//smb-server.default.svc.cluster.local/share",
+			subDir:   "subdir",
+			uuid:     "pvc-4729891a",
+		},
+		{
+			desc:      "invalid volume user should be rejected",
+			volumeID:  "smb-server.default.svc.cluster.local/share#subdir#pvc-4729891a###tenant1/root",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid share name should be rejected",
+			volumeID:  "smb-server.default.svc.cluster.local/share##pvc-4729891a##share;rm",
+			expectErr: true,
+		},
 		{
 			desc:      "incorrect volume id",
 			volumeID:  "smb-server.default.svc.cluster.local/share",
@@ -578,6 +813,7 @@ func TestGetSmbVolFromID(t *testing.T) {
 				assert.Equal(t, smbVolume.subDir, test.subDir)
 				assert.Equal(t, smbVolume.uuid, test.uuid)
 				assert.Equal(t, smbVolume.onDelete, test.onDelete)
+				assert.Equal(t, smbVolume.share, test.share)
 				assert.Nil(t, err)
 			} else {
 				assert.NotNil(t, err)
@@ -626,6 +862,15 @@ func TestGetVolumeIDFromSmbVol(t *testing.T) {
 			},
 			result: "smb-server.default.svc.cluster.local/share#subdir#uuid#retain",
 		},
+		{
+			desc: "volume with share created by share backend",
+			vol: &smbVolume{
+				source: "//smb-server.default.svc.cluster.local/pv-name",
+				uuid:   "pv-name",
+				share:  "pv-name",
+			},
+			result: "v2:share=pv-name&source=smb-server.default.svc.cluster.local%2Fpv-name&uuid=pv-name",
+		},
 	}
 
 	for _, test := range cases {
@@ -731,6 +976,126 @@ func TestNewSMBVolume(t *testing.T) {
 				uuid:   "",
 			},
 		},
+		{
+			desc: "image volume",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":     "//smb-server.default.svc.cluster.local/share",
+				"volumeType": "Image",
+				"fsType":     "XFS",
+			},
+			expectVol: &smbVolume{
+				id:           "v2:source=smb-server.default.svc.cluster.local%2Fshare&subdir=pv-name&volumetype=image",
+				source:       "//smb-server.default.svc.cluster.local/share",
+				subDir:       "pv-name",
+				size:         100,
+				uuid:         "",
+				volumeType:   volumeTypeImage,
+				fsType:       "xfs",
+				idAttributes: map[string]string{volumeTypeField: volumeTypeImage},
+			},
+		},
+		{
+			desc: "clone source secret is specified",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":                     "//smb-server.default.svc.cluster.local/share",
+				"cloneSourceSecretName":      "src-secret",
+				"cloneSourceSecretNamespace": "default",
+			},
+			expectVol: &smbVolume{
+				id:     "smb-server.default.svc.cluster.local/share#pv-name##",
+				source: "//smb-server.default.svc.cluster.local/share",
+				subDir: "pv-name",
+				size:   100,
+				uuid:   "",
+			},
+		},
+		{
+			desc: "share is created by share backend",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":          "//smb-server.default.svc.cluster.local",
+				"sharePath":       "/srv/samba/" + pvcNamespaceMetadata,
+				"shareValidUsers": "@" + pvcNamespaceMetadata,
+				pvcNamespaceKey:   "tenant1",
+			},
+			expectVol: &smbVolume{
+				id:              "v2:share=pv-name&source=smb-server.default.svc.cluster.local%2Fpv-name&uuid=pv-name",
+				source:          "//smb-server.default.svc.cluster.local/pv-name",
+				size:            100,
+				uuid:            "pv-name",
+				share:           "pv-name",
+				sharePath:       "/srv/samba/tenant1/pv-name",
+				shareValidUsers: "@tenant1",
+			},
+		},
+		{
+			desc: "relative sharePath",
+			name: "pv-name",
+			params: map[string]string{
+				"source":    "//smb-server.default.svc.cluster.local",
+				"sharePath": "samba",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("share path %q should be an absolute path other than /", "samba/pv-name"),
+		},
+		{
+			desc: "perVolumeUser without pvc namespace",
+			name: "pv-name",
+			params: map[string]string{
+				"source":        "//smb-server.default.svc.cluster.local/share",
+				"perVolumeUser": "true",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("pervolumeuser requires csi.storage.k8s.io/pvc/namespace in parameters, enable --extra-create-metadata in csi-provisioner"),
+		},
+		{
+			desc: "shareValidUsers without sharePath",
+			name: "pv-name",
+			params: map[string]string{
+				"source":          "//smb-server.default.svc.cluster.local/share",
+				"shareValidUsers": "user1",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("sharevalidusers is only supported with sharepath"),
+		},
+		{
+			desc: "image volume without capacity",
+			name: "pv-name",
+			params: map[string]string{
+				"source":     "//smb-server.default.svc.cluster.local/share",
+				"volumeType": "image",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("capacity is required for image volume"),
+		},
+		{
+			desc: "invalid volumeType",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":     "//smb-server.default.svc.cluster.local/share",
+				"volumeType": "disk",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("volumetype(disk) is not supported, supported value: image"),
+		},
+		{
+			desc: "invalid fsType",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":     "//smb-server.default.svc.cluster.local/share",
+				"volumeType": "image",
+				"fsType":     "ntfs",
+			},
+			expectVol: nil,
+			expectErr: fmt.Errorf("fstype(ntfs) is not supported, supported values: [ext3 ext4 xfs]"),
+		},
 		{
 			desc:      "invalid parameter",
 			params:    map[string]string{"invalid-parameter": "value"},
@@ -746,7 +1111,7 @@ func TestNewSMBVolume(t *testing.T) {
 	}
 
 	for _, test := range cases {
-		vol, err := newSMBVolume(test.name, test.size, test.params, "")
+		vol, err := newSMBVolume(test.name, test.size, test.params, "", nil)
 		if !reflect.DeepEqual(err, test.expectErr) {
 			t.Errorf("[test: %s] Unexpected error: %v, expected error: %v", test.desc, err, test.expectErr)
 		}
@@ -1111,7 +1476,7 @@ func TestNewSMBVolumeExtended(t *testing.T) {
 
 	for _, test := range cases {
 		t.Run(test.desc, func(t *testing.T) {
-			vol, err := newSMBVolume(test.name, test.size, test.params, test.defaultOnDeletePolicy)
+			vol, err := newSMBVolume(test.name, test.size, test.params, test.defaultOnDeletePolicy, nil)
 			if test.expectErr {
 				assert.NotNil(t, err)
 				if test.expectErrMsg != "" {
@@ -1208,6 +1573,66 @@ func TestNewSMBVolumeValidation(t *testing.T) {
 			expectErr:    true,
 			expectErrMsg: "invalid parameter unknownParameter in storage class",
 		},
+		{
+			desc: "subDir permissions are specified",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":        "//smb-server/share",
+				"subDir":        "ns1/pv-name",
+				"subDirUid":     "1000",
+				"subDirGid":     "2000",
+				"subDirMode":    "0750",
+				"parentDirMode": "0755",
+			},
+			expectVol: &smbVolume{
+				id:             "smb-server/share#ns1/pv-name#pv-name#",
+				source:         "//smb-server/share",
+				subDir:         "ns1/pv-name",
+				size:           100,
+				uuid:           "pv-name",
+				dirPermissions: &dirPermissions{uid: 1000, gid: 2000, mode: 0750, parentMode: 0755},
+			},
+		},
+		{
+			desc: "invalid subDirMode",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":     "//smb-server/share",
+				"subDirMode": "rwxr-x---",
+			},
+			expectErr:    true,
+			expectErrMsg: "invalid subdirmode \"rwxr-x---\"",
+		},
+		{
+			desc: "restore from archive",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":                           "//smb-server/share",
+				"restoreFromArchive":               "archived-${pvc.metadata.namespace}-data",
+				"csi.storage.k8s.io/pvc/namespace": "ns1",
+			},
+			expectVol: &smbVolume{
+				id:                 "smb-server/share#pv-name##",
+				source:             "//smb-server/share",
+				subDir:             "pv-name",
+				size:               100,
+				restoreFromArchive: "archived-ns1-data",
+			},
+		},
+		{
+			desc: "invalid archive name to restore",
+			name: "pv-name",
+			size: 100,
+			params: map[string]string{
+				"source":             "//smb-server/share",
+				"restoreFromArchive": "pv-data",
+			},
+			expectErr:    true,
+			expectErrMsg: "invalid restorefromarchive \"pv-data\", it should be an archive name with prefix archived-",
+		},
 		{
 			desc:                  "default onDelete policy applied",
 			name:                  "pv-name",
@@ -1426,7 +1851,7 @@ func TestNewSMBVolumeValidation(t *testing.T) {
 
 	for _, test := range cases {
 		t.Run(test.desc, func(t *testing.T) {
-			vol, err := newSMBVolume(test.name, test.size, test.params, test.defaultOnDeletePolicy)
+			vol, err := newSMBVolume(test.name, test.size, test.params, test.defaultOnDeletePolicy, nil)
 			if test.expectErr {
 				assert.NotNil(t, err)
 				if test.expectErrMsg != "" {
@@ -0,0 +1,257 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+// pendingMount records a mount goroutine started by mountWithTimeout
+type pendingMount struct {
+	VolumeID  string    `json:"volumeID"`
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type cacheEntryInfo struct {
+	Key       string    `json:"key"`
+	CreatedOn time.Time `json:"createdOn"`
+}
+
+type mountInfo struct {
+	Device string   `json:"device"`
+	Path   string   `json:"path"`
+	Type   string   `json:"type"`
+	Opts   []string `json:"opts,omitempty"`
+}
+
+type krb5CacheLink struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// debugState is the internal state of the driver returned by /debug/state,
+// secrets (mount options with password, cache contents) are never included.
+type debugState struct {
+	Locks              []lockInfo        `json:"locks"`
+	PendingMounts      []pendingMount    `json:"pendingMounts"`
+	VolStatsCache      []cacheEntryInfo  `json:"volStatsCache"`
+	VolDeletionCache   []cacheEntryInfo  `json:"volDeletionCache"`
+	PlacementCache     []cacheEntryInfo  `json:"placementCache"`
+	LastServers        map[string]string `json:"lastServers"`
+	Mounts             []mountInfo       `json:"mounts"`
+	MountsError        string            `json:"mountsError,omitempty"`
+	Krb5CacheLinks     []krb5CacheLink   `json:"krb5CacheLinks"`
+	Krb5CacheLinksErr  string            `json:"krb5CacheLinksError,omitempty"`
+	AdminActionEnabled bool              `json:"adminActionEnabled"`
+}
+
+// DebugHandler returns the http handler exposing driver internal state:
+//   - GET  /debug/state: held volume locks, pending mounts, cache entries, CIFS mounts and kerberos cache symlinks
+//   - GET  /debug/archives?storageclass=<name>: archives with metadata in the archive location of a storage class
+//   - POST /debug/locks/release?key=<key>: force release a stale volume lock
+//   - POST /debug/cache/evict?cache=<stats|deletion|placement>&key=<key>: evict a cache entry
+//
+// Admin actions return 403 unless enableAdmin is true, they are the POST actions and listing archives,
+// which reads the provisioner secret and mounts the archive shares of a storage class.
+func (d *Driver) DebugHandler(enableAdmin bool) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		state := d.getDebugState()
+		state.AdminActionEnabled = enableAdmin
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			klog.Warningf("failed to encode debug state: %v", err)
+		}
+	})
+	m.HandleFunc("/debug/archives", d.adminHandler(enableAdmin, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		scName := r.URL.Query().Get("storageclass")
+		if scName == "" {
+			http.Error(w, "storageclass is required", http.StatusBadRequest)
+			return
+		}
+		archives, err := d.listStorageClassArchives(r.Context(), scName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(archives); err != nil {
+			klog.Warningf("failed to encode archives: %v", err)
+		}
+	}))
+	m.HandleFunc("/debug/locks/release", d.adminHandler(enableAdmin, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		if !d.volumeLocks.ForceRelease(key) {
+			http.Error(w, fmt.Sprintf("lock %s is not held", key), http.StatusNotFound)
+			return
+		}
+		klog.Warningf("volume lock %s is force released by debug endpoint", key)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	m.HandleFunc("/debug/cache/evict", d.adminHandler(enableAdmin, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		var c azcache.Resource
+		switch cacheName := r.URL.Query().Get("cache"); cacheName {
+		case "stats":
+			c = d.volStatsCache
+		case "deletion":
+			c = d.volDeletionCache
+		case "placement":
+			c = d.placementCache
+		default:
+			http.Error(w, fmt.Sprintf("unknown cache %q, supported: stats, deletion, placement", cacheName), http.StatusBadRequest)
+			return
+		}
+		if c == nil {
+			http.Error(w, "cache is not initialized", http.StatusNotFound)
+			return
+		}
+		if err := c.Delete(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		klog.Warningf("cache entry %s is evicted by debug endpoint", key)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	return m
+}
+
+func (d *Driver) adminHandler(enableAdmin bool, method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enableAdmin {
+			http.Error(w, "admin actions are disabled, set --enable-debug-admin to enable", http.StatusForbidden)
+			return
+		}
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (d *Driver) getDebugState() debugState {
+	state := debugState{
+		Locks:            d.volumeLocks.List(),
+		PendingMounts:    []pendingMount{},
+		VolStatsCache:    listCacheEntries(d.volStatsCache),
+		VolDeletionCache: listCacheEntries(d.volDeletionCache),
+		PlacementCache:   listCacheEntries(d.placementCache),
+		LastServers:      d.serverSelector.list(),
+		Mounts:           []mountInfo{},
+		Krb5CacheLinks:   []krb5CacheLink{},
+	}
+
+	d.pendingMounts.Range(func(_, value interface{}) bool {
+		state.PendingMounts = append(state.PendingMounts, value.(pendingMount))
+		return true
+	})
+	sort.Slice(state.PendingMounts, func(i, j int) bool {
+		return state.PendingMounts[i].StartedAt.Before(state.PendingMounts[j].StartedAt)
+	})
+
+	if d.mounter != nil {
+		mountPoints, err := d.mounter.List()
+		if err != nil {
+			state.MountsError = err.Error()
+		}
+		for _, mp := range mountPoints {
+			if isCIFSMountType(mp.Type) {
+				state.Mounts = append(state.Mounts, mountInfo{Device: mp.Device, Path: mp.Path, Type: mp.Type, Opts: mp.Opts})
+			}
+		}
+	}
+
+	if links, err := listKrb5CacheLinks(d.krb5CacheDirectory); err != nil {
+		state.Krb5CacheLinksErr = err.Error()
+	} else {
+		state.Krb5CacheLinks = links
+	}
+	return state
+}
+
+func listCacheEntries(c azcache.Resource) []cacheEntryInfo {
+	result := []cacheEntryInfo{}
+	if c == nil {
+		return result
+	}
+	for _, obj := range c.GetStore().List() {
+		if entry, ok := obj.(*azcache.AzureCacheEntry); ok {
+			result = append(result, cacheEntryInfo{Key: entry.Key, CreatedOn: entry.CreatedOn})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+func isCIFSMountType(fsType string) bool {
+	fsType = strings.ToLower(fsType)
+	return fsType == "cifs" || fsType == "smb3"
+}
+
+// listKrb5CacheLinks returns the per-volume symlinks created in krb5CacheDirectory,
+// returns empty list if the directory does not exist
+func listKrb5CacheLinks(krb5CacheDirectory string) ([]krb5CacheLink, error) {
+	result := []krb5CacheLink{}
+	if krb5CacheDirectory == "" {
+		return result, nil
+	}
+	entries, err := os.ReadDir(krb5CacheDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(krb5CacheDirectory, entry.Name()))
+		if err != nil {
+			klog.Warningf("failed to read symlink %s in %s: %v", entry.Name(), krb5CacheDirectory, err)
+			continue
+		}
+		result = append(result, krb5CacheLink{Name: entry.Name(), Target: target})
+	}
+	return result, nil
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mount "k8s.io/mount-utils"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+func TestDebugState(t *testing.T) {
+	d := NewFakeDriver()
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: mount.NewFakeMounter([]mount.MountPoint{
+			{Device: "//server/share", Path: "/var/lib/kubelet/staging", Type: "cifs"},
+			{Device: "/dev/sda1", Path: "/", Type: "ext4"},
+		}),
+	}
+	d.krb5CacheDirectory = t.TempDir()
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink(filepath.Join(d.krb5CacheDirectory, "krb5cc_1000"), filepath.Join(d.krb5CacheDirectory, "vol-link")); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(d.krb5CacheDirectory, "krb5cc_1000"), []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to write cache file: %v", err)
+	}
+
+	_, ok := d.volumeLocks.TryAcquireWithOwner("vol-1", "DeleteVolume")
+	assert.True(t, ok)
+	d.pendingMounts.Store("vol-2-/staging", pendingMount{VolumeID: "vol-2", Source: "//server/share", Target: "/staging", StartedAt: time.Now()})
+	d.volStatsCache.Set("vol-3", "stats")
+	d.volDeletionCache.Set("vol-4", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/state", nil)
+	w := httptest.NewRecorder()
+	d.DebugHandler(false).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var state debugState
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatalf("failed to unmarshal debug state: %v", err)
+	}
+	assert.Len(t, state.Locks, 1)
+	assert.Equal(t, "vol-1", state.Locks[0].Key)
+	assert.Equal(t, "DeleteVolume", state.Locks[0].Owner)
+	assert.Len(t, state.PendingMounts, 1)
+	assert.Equal(t, "vol-2", state.PendingMounts[0].VolumeID)
+	assert.Equal(t, []cacheEntryInfo{{Key: "vol-3", CreatedOn: state.VolStatsCache[0].CreatedOn}}, state.VolStatsCache)
+	assert.Equal(t, "vol-4", state.VolDeletionCache[0].Key)
+	assert.Equal(t, []mountInfo{{Device: "//server/share", Path: "/var/lib/kubelet/staging", Type: "cifs"}}, state.Mounts)
+	assert.False(t, state.AdminActionEnabled)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, []krb5CacheLink{{Name: "vol-link", Target: filepath.Join(d.krb5CacheDirectory, "krb5cc_1000")}}, state.Krb5CacheLinks)
+	}
+	assert.NotContains(t, w.Body.String(), "secret")
+
+	w = httptest.NewRecorder()
+	d.DebugHandler(false).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/state", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestDebugAdminActions(t *testing.T) {
+	tests := []struct {
+		desc         string
+		enableAdmin  bool
+		method       string
+		url          string
+		expectedCode int
+		verify       func(t *testing.T, d *Driver)
+	}{
+		{
+			desc:         "release lock is forbidden if admin is disabled",
+			method:       http.MethodPost,
+			url:          "/debug/locks/release?key=vol-1",
+			expectedCode: http.StatusForbidden,
+			verify: func(t *testing.T, d *Driver) {
+				assert.Len(t, d.volumeLocks.List(), 1)
+			},
+		},
+		{
+			desc:         "release lock with GET is not allowed",
+			enableAdmin:  true,
+			method:       http.MethodGet,
+			url:          "/debug/locks/release?key=vol-1",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:         "release lock without key",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/locks/release",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc:         "release lock which is not held",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/locks/release?key=vol-2",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			desc:         "release lock",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/locks/release?key=vol-1",
+			expectedCode: http.StatusOK,
+			verify: func(t *testing.T, d *Driver) {
+				assert.Empty(t, d.volumeLocks.List())
+				_, ok := d.volumeLocks.TryAcquire("vol-1")
+				assert.True(t, ok)
+			},
+		},
+		{
+			desc:         "evict cache entry is forbidden if admin is disabled",
+			method:       http.MethodPost,
+			url:          "/debug/cache/evict?cache=deletion&key=vol-1",
+			expectedCode: http.StatusForbidden,
+			verify: func(t *testing.T, d *Driver) {
+				assert.Len(t, listCacheEntries(d.volDeletionCache), 1)
+			},
+		},
+		{
+			desc:         "evict from unknown cache",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/cache/evict?cache=unknown&key=vol-1",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc:         "evict deletion cache entry",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/cache/evict?cache=deletion&key=vol-1",
+			expectedCode: http.StatusOK,
+			verify: func(t *testing.T, d *Driver) {
+				assert.Empty(t, listCacheEntries(d.volDeletionCache))
+				assert.Len(t, listCacheEntries(d.volStatsCache), 1)
+			},
+		},
+		{
+			desc:         "evict stats cache entry",
+			enableAdmin:  true,
+			method:       http.MethodPost,
+			url:          "/debug/cache/evict?cache=stats&key=vol-1",
+			expectedCode: http.StatusOK,
+			verify: func(t *testing.T, d *Driver) {
+				assert.Empty(t, listCacheEntries(d.volStatsCache))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.volumeLocks.TryAcquireWithOwner("vol-1", "NodeStageVolume")
+			d.volStatsCache.Set("vol-1", "stats")
+			d.volDeletionCache.Set("vol-1", "")
+
+			w := httptest.NewRecorder()
+			d.DebugHandler(test.enableAdmin).ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+			assert.Equal(t, test.expectedCode, w.Code, w.Body.String())
+			if test.verify != nil {
+				test.verify(t, d)
+			}
+		})
+	}
+}
+
+func TestListCacheEntries(t *testing.T) {
+	assert.Empty(t, listCacheEntries(nil))
+
+	c, err := azcache.NewTimedCache(time.Minute, func(_ string) (interface{}, error) { return nil, nil }, false)
+	assert.NoError(t, err)
+	c.Set("b", "")
+	c.Set("a", "")
+	entries := listCacheEntries(c)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a", entries[0].Key)
+	assert.Equal(t, "b", entries[1].Key)
+}
+
+func TestListKrb5CacheLinks(t *testing.T) {
+	links, err := listKrb5CacheLinks(filepath.Join(t.TempDir(), "non-existing"))
+	assert.NoError(t, err)
+	assert.Empty(t, links)
+
+	links, err = listKrb5CacheLinks("")
+	assert.NoError(t, err)
+	assert.Empty(t, links)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// default mode of directories created by CreateVolume
+const defaultVolumeDirMode = 0777
+
+// dirPermissions are applied on the volume directory created by CreateVolume
+type dirPermissions struct {
+	// owner uid and gid, -1 means unchanged
+	uid int
+	gid int
+	// mode of volume directory, 0 means default mode
+	mode os.FileMode
+	// mode of parent directories created for nested subDir, 0 means default mode
+	parentMode os.FileMode
+	// NT security descriptor set with cifs xattr
+	securityDescriptor *securityDescriptor
+}
+
+func newDirPermissions() *dirPermissions {
+	return &dirPermissions{uid: -1, gid: -1}
+}
+
+// parse sets the permission of parameter key
+func (p *dirPermissions) parse(key, value string) error {
+	var err error
+	switch key {
+	case subDirUIDField:
+		p.uid, err = parseDirOwner(key, value)
+	case subDirGIDField:
+		p.gid, err = parseDirOwner(key, value)
+	case subDirModeField:
+		p.mode, err = parseDirMode(key, value)
+	case parentDirModeField:
+		p.parentMode, err = parseDirMode(key, value)
+	case subDirSDDLField:
+		p.securityDescriptor, err = parseSecurityDescriptor(value)
+	default:
+		err = fmt.Errorf("invalid parameter %s", key)
+	}
+	return err
+}
+
+// parseDirOwner parses uid or gid parameter
+func parseDirOwner(key, value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return -1, fmt.Errorf("invalid %s %q, it should be a non-negative integer", key, value)
+	}
+	return id, nil
+}
+
+// parseDirMode parses mode parameter in octal, e.g. 0750
+func parseDirMode(key, value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode == 0 || mode > 07777 {
+		return 0, fmt.Errorf("invalid %s %q, it should be an octal mode between 0001 and 7777", key, value)
+	}
+	fileMode := os.FileMode(mode & 0777)
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	return fileMode, nil
+}
+
+// makeVolumeDir creates subDir under mountPath and applies perms on it. Missing parent directories of
+// nested subDir are created with parent mode, existing directories other than subDir are not changed.
+func makeVolumeDir(mountPath, subDir string, perms *dirPermissions) error {
+	if perms == nil {
+		return os.MkdirAll(filepath.Join(mountPath, subDir), defaultVolumeDirMode)
+	}
+
+	dir := mountPath
+	segments := strings.FieldsFunc(subDir, func(r rune) bool { return r == '/' || r == '\\' })
+	for i, segment := range segments {
+		dir = filepath.Join(dir, segment)
+		if i == len(segments)-1 {
+			break
+		}
+		if err := os.Mkdir(dir, defaultVolumeDirMode); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+		if perms.parentMode != 0 {
+			if err := os.Chmod(dir, perms.parentMode); err != nil {
+				return fmt.Errorf("chmod parent directory %s to %v failed: %v", dir, perms.parentMode, err)
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, defaultVolumeDirMode); err != nil {
+		return err
+	}
+
+	// apply permissions on every call so that a failed CreateVolume could be retried
+	if perms.uid >= 0 || perms.gid >= 0 {
+		if err := os.Chown(dir, perms.uid, perms.gid); err != nil {
+			return fmt.Errorf("chown %s to %d:%d failed: %v", dir, perms.uid, perms.gid, err)
+		}
+	}
+	if perms.mode != 0 {
+		if err := os.Chmod(dir, perms.mode); err != nil {
+			return fmt.Errorf("chmod %s to %v failed: %v", dir, perms.mode, err)
+		}
+	}
+	if perms.securityDescriptor != nil {
+		if err := setSecurityDescriptor(dir, perms.securityDescriptor); err != nil {
+			return fmt.Errorf("set security descriptor on %s failed: %v", dir, err)
+		}
+	}
+	klog.V(2).Infof("applied permissions(uid: %d, gid: %d, mode: %v, security descriptor: %t) on %s", perms.uid, perms.gid, perms.mode, perms.securityDescriptor != nil, dir)
+	return nil
+}
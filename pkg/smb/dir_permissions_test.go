@@ -0,0 +1,99 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDirMode(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  os.FileMode
+		expectErr bool
+	}{
+		{value: "0750", expected: 0750},
+		{value: "755", expected: 0755},
+		{value: "2770", expected: 0770 | os.ModeSetgid},
+		{value: "1777", expected: 0777 | os.ModeSticky},
+		{value: "0", expectErr: true},
+		{value: "0888", expectErr: true},
+		{value: "17777", expectErr: true},
+		{value: "rwx", expectErr: true},
+	}
+	for _, test := range tests {
+		mode, err := parseDirMode(subDirModeField, test.value)
+		assert.Equal(t, test.expectErr, err != nil, test.value)
+		assert.Equal(t, test.expected, mode, test.value)
+	}
+}
+
+func TestDirPermissionsParse(t *testing.T) {
+	perms := newDirPermissions()
+	assert.NoError(t, perms.parse(subDirUIDField, "1000"))
+	assert.NoError(t, perms.parse(subDirGIDField, "2000"))
+	assert.NoError(t, perms.parse(subDirModeField, "0750"))
+	assert.NoError(t, perms.parse(parentDirModeField, "0755"))
+	assert.NoError(t, perms.parse(subDirSDDLField, "D:(A;OICI;FA;;;WD)"))
+	assert.Equal(t, 1000, perms.uid)
+	assert.Equal(t, 2000, perms.gid)
+	assert.Equal(t, os.FileMode(0750), perms.mode)
+	assert.Equal(t, os.FileMode(0755), perms.parentMode)
+	assert.NotNil(t, perms.securityDescriptor)
+
+	assert.Error(t, perms.parse(subDirUIDField, "-1"))
+	assert.Error(t, perms.parse(subDirGIDField, "root"))
+	assert.Error(t, perms.parse(subDirSDDLField, "invalid"))
+}
+
+func TestMakeVolumeDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows: unix permission bits are not supported")
+	}
+	mountPath := t.TempDir()
+	existing := filepath.Join(mountPath, "existing")
+	assert.NoError(t, os.Mkdir(existing, 0700))
+
+	assert.NoError(t, makeVolumeDir(mountPath, "pv-default", nil))
+	assert.DirExists(t, filepath.Join(mountPath, "pv-default"))
+
+	perms := &dirPermissions{uid: os.Getuid(), gid: os.Getgid(), mode: 0750, parentMode: 0711}
+	assert.NoError(t, makeVolumeDir(mountPath, "existing/ns1/pv-name", perms))
+	// retry applies permissions on existing volume directory
+	assert.NoError(t, makeVolumeDir(mountPath, "existing/ns1/pv-name", perms))
+
+	info, err := os.Stat(filepath.Join(mountPath, "existing/ns1/pv-name"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(mountPath, "existing/ns1"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0711), info.Mode().Perm())
+	// existing parent directory is not changed
+	info, err = os.Stat(existing)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	// security descriptor could only be set on cifs mount
+	sd, err := parseSecurityDescriptor("D:(A;OICI;FA;;;WD)")
+	assert.NoError(t, err)
+	assert.Error(t, makeVolumeDir(mountPath, "pv-sd", &dirPermissions{uid: -1, gid: -1, securityDescriptor: sd}))
+}
@@ -82,12 +82,13 @@ func TestMountWithTimeout(t *testing.T) {
 			}
 
 			lockKey := "test-lock"
-			if !d.volumeLocks.TryAcquire(lockKey) {
+			token, ok := d.volumeLocks.TryAcquire(lockKey)
+			if !ok {
 				t.Fatal("failed to acquire volume lock")
 			}
 
 			ctx := context.Background()
-			keepLock, err := d.mountWithTimeout(ctx, "source", "/target", nil, nil, "vol-1", lockKey, tc.timeout)
+			keepLock, err := d.mountWithTimeout(ctx, "source", "/target", nil, nil, "vol-1", lockKey, token, tc.timeout)
 
 			if keepLock != tc.wantKeepLock {
 				t.Errorf("keepLockHeld = %v, want %v", keepLock, tc.wantKeepLock)
@@ -109,18 +110,21 @@ func TestMountWithTimeout(t *testing.T) {
 
 			if tc.checkLockAsync {
 				// Lock should be held right now (retry should fail)
-				if d.volumeLocks.TryAcquire(lockKey) {
+				if retry, ok := d.volumeLocks.TryAcquire(lockKey); ok {
 					t.Error("expected lock to be held after timeout, but TryAcquire succeeded")
-					d.volumeLocks.Release(lockKey)
+					d.volumeLocks.Release(lockKey, retry)
+				}
+				if _, ok := d.pendingMounts.Load(lockKey); !ok {
+					t.Error("expected pending mount to be tracked after timeout")
 				}
 				// Poll for the async lock release with an overall deadline so
 				// we wait just long enough on slow CI runners without flaking.
 				deadline := time.Now().Add(tc.mountDelay + 5*time.Second)
 				acquired := false
 				for time.Now().Before(deadline) {
-					if d.volumeLocks.TryAcquire(lockKey) {
+					if retry, ok := d.volumeLocks.TryAcquire(lockKey); ok {
 						acquired = true
-						d.volumeLocks.Release(lockKey)
+						d.volumeLocks.Release(lockKey, retry)
 						break
 					}
 					time.Sleep(10 * time.Millisecond)
@@ -128,9 +132,12 @@ func TestMountWithTimeout(t *testing.T) {
 				if !acquired {
 					t.Error("expected lock to be released after mount goroutine finished")
 				}
+				if _, ok := d.pendingMounts.Load(lockKey); ok {
+					t.Error("expected pending mount to be removed after mount goroutine finished")
+				}
 			} else if !keepLock {
 				// When keepLock is false, caller releases the lock — just clean up
-				d.volumeLocks.Release(lockKey)
+				d.volumeLocks.Release(lockKey, token)
 			}
 		})
 	}
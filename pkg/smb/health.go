@@ -0,0 +1,103 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// kubeAPICheckTimeout is the max time to wait for the API server in readiness check
+const kubeAPICheckTimeout = 10 * time.Second
+
+var (
+	// cifsMountHelper is the mount helper installed by cifs-utils
+	cifsMountHelper = "mount.cifs"
+	// procFilesystems lists the filesystems supported by the kernel
+	procFilesystems = "/proc/filesystems"
+)
+
+// CheckReadiness returns nil if all the dependencies of the driver are available, it's used by /readyz and CSI Probe:
+//   - the local dependencies, see checkDependencies
+//   - Kubernetes API server is reachable (controller only, skipped if there is no kubeClient)
+//
+// The driver is considered as controller if node id is empty.
+func (d *Driver) CheckReadiness(ctx context.Context) error {
+	if err := d.checkDependencies(ctx); err != nil {
+		return err
+	}
+	if d.NodeID == "" && d.kubeClient != nil {
+		if err := d.checkKubeAPI(ctx); err != nil {
+			return fmt.Errorf("kubernetes API server is not reachable: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkDependencies returns nil if the local dependencies of the driver are available:
+//   - cifs-utils or the kernel cifs module is present
+//   - krb5CacheDirectory exists if kerberos check is enabled
+//   - workingMountDir is writable (controller only)
+func (d *Driver) checkDependencies(ctx context.Context) error {
+	if err := checkCIFSAvailable(); err != nil {
+		return err
+	}
+	if d.checkKrb5CacheDirectory {
+		if _, err := kerberosCacheDirectoryExists(d.krb5CacheDirectory); err != nil {
+			return fmt.Errorf("kerberos cache directory(%s) check failed: %v", d.krb5CacheDirectory, err)
+		}
+	}
+	if d.NodeID == "" {
+		if err := checkDirWritable(d.workingMountDir); err != nil {
+			return fmt.Errorf("working mount directory(%s) is not writable: %v", d.workingMountDir, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// checkKubeAPI checks whether Kubernetes API server is reachable before ctx is done or kubeAPICheckTimeout
+func (d *Driver) checkKubeAPI(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, kubeAPICheckTimeout)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := d.kubeClient.Discovery().ServerVersion()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkDirWritable creates and removes a temp file under dir
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".csi-smb-readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return os.Remove(name)
+}
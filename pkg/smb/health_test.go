@@ -0,0 +1,171 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	origMountHelper, origProcFilesystems := cifsMountHelper, procFilesystems
+	defer func() {
+		cifsMountHelper, procFilesystems = origMountHelper, origProcFilesystems
+	}()
+	cifsMountHelper = "non-existing-mount.cifs"
+
+	tmpDir := t.TempDir()
+	cifsFilesystems := filepath.Join(tmpDir, "filesystems")
+	if err := os.WriteFile(cifsFilesystems, []byte("nodev\tproc\nnodev\tcifs\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", cifsFilesystems, err)
+	}
+	noCIFSFilesystems := filepath.Join(tmpDir, "filesystems-nocifs")
+	if err := os.WriteFile(noCIFSFilesystems, []byte("nodev\tproc\n\text4\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", noCIFSFilesystems, err)
+	}
+
+	tests := []struct {
+		desc            string
+		nodeID          string
+		procFilesystems string
+		checkKrb5       bool
+		krb5CacheDir    string
+		workingMountDir string
+		kubeClient      bool
+		kubeAPIErr      bool
+		expectedErr     string
+		linuxOnly       bool
+	}{
+		{
+			desc:            "node is ready",
+			nodeID:          fakeNodeID,
+			procFilesystems: cifsFilesystems,
+		},
+		{
+			desc:            "cifs is not available",
+			nodeID:          fakeNodeID,
+			procFilesystems: noCIFSFilesystems,
+			expectedErr:     "cifs-utils must be installed",
+			linuxOnly:       true,
+		},
+		{
+			desc:            "kerberos cache directory does not exist",
+			nodeID:          fakeNodeID,
+			procFilesystems: cifsFilesystems,
+			checkKrb5:       true,
+			krb5CacheDir:    filepath.Join(tmpDir, "non-existing") + "/",
+			expectedErr:     "kerberos cache directory",
+		},
+		{
+			desc:            "kerberos cache directory exists",
+			nodeID:          fakeNodeID,
+			procFilesystems: cifsFilesystems,
+			checkKrb5:       true,
+			krb5CacheDir:    tmpDir + "/",
+		},
+		{
+			desc:            "controller working mount directory does not exist",
+			procFilesystems: cifsFilesystems,
+			workingMountDir: filepath.Join(tmpDir, "non-existing"),
+			kubeClient:      true,
+			expectedErr:     "is not writable",
+		},
+		{
+			desc:            "controller kubeClient is nil",
+			procFilesystems: cifsFilesystems,
+			workingMountDir: tmpDir,
+		},
+		{
+			desc:            "controller kubernetes API server is not reachable",
+			procFilesystems: cifsFilesystems,
+			workingMountDir: tmpDir,
+			kubeClient:      true,
+			kubeAPIErr:      true,
+			expectedErr:     "kubernetes API server is not reachable",
+		},
+		{
+			desc:            "controller is ready",
+			procFilesystems: cifsFilesystems,
+			workingMountDir: tmpDir,
+			kubeClient:      true,
+		},
+	}
+
+	for _, test := range tests {
+		if test.linuxOnly && goruntime.GOOS != "linux" {
+			continue
+		}
+		procFilesystems = test.procFilesystems
+		d := NewFakeDriver()
+		d.NodeID = test.nodeID
+		d.checkKrb5CacheDirectory = test.checkKrb5
+		d.krb5CacheDirectory = test.krb5CacheDir
+		d.workingMountDir = test.workingMountDir
+		d.kubeClient = nil
+		if test.kubeClient {
+			kubeClient := fake.NewSimpleClientset()
+			if test.kubeAPIErr {
+				kubeClient.PrependReactor("get", "version", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("connection refused")
+				})
+			}
+			d.kubeClient = kubeClient
+		}
+
+		err := d.CheckReadiness(context.Background())
+		if test.expectedErr == "" {
+			assert.NoError(t, err, "test[%s]", test.desc)
+		} else if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("test[%s]: expected error containing %q, got %v", test.desc, test.expectedErr, err)
+		}
+
+		resp, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+		assert.NoError(t, err, "test[%s]", test.desc)
+		assert.Equal(t, test.expectedErr == "", resp.GetReady().GetValue(), "test[%s]", test.desc)
+	}
+
+	// readiness check is canceled with the context
+	procFilesystems = cifsFilesystems
+	d := NewFakeDriver()
+	d.NodeID = ""
+	d.workingMountDir = tmpDir
+	d.kubeClient = fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, d.CheckReadiness(ctx), context.Canceled)
+}
+
+func TestCheckDirWritable(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, checkDirWritable(tmpDir))
+	entries, err := os.ReadDir(tmpDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Error(t, checkDirWritable(filepath.Join(tmpDir, "non-existing")))
+}
@@ -21,6 +21,7 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/protobuf/types/known/wrapperspb"
@@ -42,25 +43,36 @@ func (f *Driver) GetPluginInfo(_ context.Context, _ *csi.GetPluginInfoRequest) (
 	}, nil
 }
 
-// Probe check whether the plugin is running or not.
-// This method does not need to return anything.
-// Currently the spec does not dictate what you should return either.
-// Hence, return an empty response
-func (f *Driver) Probe(_ context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	return &csi.ProbeResponse{Ready: &wrapperspb.BoolValue{Value: true}}, nil
+// Probe check whether the plugin is running and ready to serve requests.
+// It runs the same checks as the /readyz endpoint.
+func (f *Driver) Probe(ctx context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	ready := true
+	if err := f.CheckReadiness(ctx); err != nil {
+		klog.Warningf("Probe: driver is not ready: %v", err)
+		ready = false
+	}
+	return &csi.ProbeResponse{Ready: &wrapperspb.BoolValue{Value: ready}}, nil
 }
 
 // GetPluginCapabilities returns the capabilities of the plugin
 func (f *Driver) GetPluginCapabilities(_ context.Context, _ *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
+	capabilities := []*csi.PluginCapability{
+		{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
 				},
 			},
 		},
-	}, nil
+	}
+	if f.topologyEnabled() {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+				},
+			},
+		})
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: capabilities}, nil
 }
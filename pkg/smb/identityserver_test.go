@@ -22,7 +22,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -63,12 +66,31 @@ func TestGetPluginInfo(t *testing.T) {
 }
 
 func TestProbe(t *testing.T) {
+	origMountHelper, origProcFilesystems := cifsMountHelper, procFilesystems
+	defer func() {
+		cifsMountHelper, procFilesystems = origMountHelper, origProcFilesystems
+	}()
+	cifsMountHelper = "non-existing-mount.cifs"
+	procFilesystems = filepath.Join(t.TempDir(), "filesystems")
+	if err := os.WriteFile(procFilesystems, []byte("nodev\tproc\nnodev\tcifs\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", procFilesystems, err)
+	}
+
 	d := NewFakeDriver()
 	req := csi.ProbeRequest{}
 	resp, err := d.Probe(context.Background(), &req)
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.Equal(t, resp.Ready.Value, true)
+
+	if runtime.GOOS == "linux" {
+		// cifs is not available
+		procFilesystems = filepath.Join(t.TempDir(), "non-existing")
+		resp, err = d.Probe(context.Background(), &req)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.Equal(t, resp.Ready.Value, false)
+	}
 }
 
 func TestGetPluginCapabilities(t *testing.T) {
@@ -0,0 +1,396 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// imageFileName is the filesystem image file created under the volume subdirectory
+	imageFileName = "disk.img"
+	// imageShareMountSuffix is appended to staging path to get the mount path of the SMB share which holds the image
+	imageShareMountSuffix = ".smbshare"
+	defaultImageFsType    = "ext4"
+)
+
+var supportedImageFsTypes = []string{"ext3", "ext4", "xfs"}
+
+// runCommand runs a command and returns its combined output
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// runCommandWithInput runs a command with input as stdin and returns its combined output
+var runCommandWithInput = func(input, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return cmd.CombinedOutput()
+}
+
+// resizeFs resizes the filesystem on devicePath mounted on deviceMountPath
+var resizeFs = func(exec utilexec.Interface, devicePath, deviceMountPath string) (bool, error) {
+	return mount.NewResizeFs(exec).Resize(devicePath, deviceMountPath)
+}
+
+// isImageVolume returns true if volumeType in parameters or volume context is image
+func isImageVolume(context map[string]string) bool {
+	for k, v := range context {
+		if strings.EqualFold(k, volumeTypeField) {
+			return strings.EqualFold(v, volumeTypeImage)
+		}
+	}
+	return false
+}
+
+// isImageVolumeID returns true if vol is an image volume by the volume type recorded in its volume id, or by the
+// volume attributes of its PV if it's created before the volume type is recorded in volume id
+func (d *Driver) isImageVolumeID(ctx context.Context, vol *smbVolume) bool {
+	if isImageVolume(vol.idAttributes) {
+		return true
+	}
+	if d.kubeClient == nil {
+		return false
+	}
+	pv, err := d.getPVByVolumeID(ctx, vol.id)
+	if err != nil {
+		klog.Warningf("failed to get PV of volume %s to check volume type: %v", vol.id, err)
+		return false
+	}
+	return pv != nil && isImageVolume(pv.Spec.CSI.VolumeAttributes)
+}
+
+func validateVolumeType(volumeType string) error {
+	if volumeType == "" || strings.EqualFold(volumeType, volumeTypeImage) {
+		return nil
+	}
+	return fmt.Errorf("%s(%s) is not supported, supported value: %s", volumeTypeField, volumeType, volumeTypeImage)
+}
+
+func validateImageFsType(fsType string) error {
+	if fsType == "" {
+		return nil
+	}
+	for _, t := range supportedImageFsTypes {
+		if strings.EqualFold(fsType, t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s(%s) is not supported, supported values: %v", fsTypeField, fsType, supportedImageFsTypes)
+}
+
+// getImageFsType returns the filesystem type of image volume, fsType in volume capability takes precedence
+func getImageFsType(volCap *csi.VolumeCapability, fsType string) string {
+	if t := volCap.GetMount().GetFsType(); t != "" {
+		fsType = t
+	}
+	if fsType == "" {
+		fsType = defaultImageFsType
+	}
+	return strings.ToLower(fsType)
+}
+
+// isReaderOnlyAccessMode returns true if the access mode of volCap only allows reading the volume
+func isReaderOnlyAccessMode(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// getImageReadOnlyMountOptions returns the options to mount the filesystem of image read-only, the journal
+// is not replayed since replaying writes to the image
+func getImageReadOnlyMountOptions(fsType string) []string {
+	switch fsType {
+	case "ext3", "ext4":
+		return []string{"ro", "noload"}
+	case "xfs":
+		return []string{"ro", "norecovery"}
+	}
+	return []string{"ro"}
+}
+
+// validateVolumeCapabilities validates volume capabilities according to the volume type in parameters or volume context
+func validateVolumeCapabilities(volCaps []*csi.VolumeCapability, context map[string]string) error {
+	if isImageVolume(context) {
+		return isValidImageVolumeCapabilities(volCaps)
+	}
+	return isValidVolumeCapabilities(volCaps)
+}
+
+// isValidImageVolumeCapabilities validates the capabilities of image volume, both block and mount
+// access types are supported while the image could only be written by one node at a time
+func isValidImageVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
+	if len(volCaps) == 0 {
+		return fmt.Errorf("volume capabilities missing in request")
+	}
+	for _, c := range volCaps {
+		switch c.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:
+			return fmt.Errorf("access mode %v is not supported by image volume", c.GetAccessMode().GetMode())
+		}
+		if err := validateImageFsType(c.GetMount().GetFsType()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createImageFile creates a sparse image file with size under dir and formats it with fsType,
+// formatting is skipped if fsType is empty (block volume) or the image file already exists.
+func createImageFile(dir string, size int64, fsType string) error {
+	imagePath := filepath.Join(dir, imageFileName)
+	if info, err := os.Stat(imagePath); err == nil {
+		if info.Size() < size {
+			klog.V(2).Infof("image file %s exists with size %d, extend to %d", imagePath, info.Size(), size)
+			return os.Truncate(imagePath, size)
+		}
+		klog.V(2).Infof("image file %s already exists", imagePath)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(imagePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		os.Remove(imagePath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(imagePath)
+		return err
+	}
+	klog.V(2).Infof("created sparse image file %s with size %d", imagePath, size)
+
+	if fsType == "" {
+		return nil
+	}
+	if err := formatImageFile(imagePath, fsType); err != nil {
+		os.Remove(imagePath)
+		return err
+	}
+	return nil
+}
+
+func formatImageFile(imagePath, fsType string) error {
+	args := []string{"-F", "-q", imagePath}
+	if fsType == "xfs" {
+		args = []string{"-f", "-q", imagePath}
+	}
+	start := time.Now()
+	if out, err := runCommand("mkfs."+fsType, args...); err != nil {
+		return fmt.Errorf("format image file %s with %s failed: %v, output: %s", imagePath, fsType, err, string(out))
+	}
+	klog.V(2).Infof("formatted image file %s with %s in %v", imagePath, fsType, time.Since(start))
+	return nil
+}
+
+// expandImageFile grows the image file to size, the image file is never shrunk.
+// Returns the size of image file after expansion.
+func expandImageFile(imagePath string, size int64) (int64, error) {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() >= size {
+		klog.V(2).Infof("image file %s size %d is not smaller than %d, skip expansion", imagePath, info.Size(), size)
+		return info.Size(), nil
+	}
+	if err := os.Truncate(imagePath, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// getImageShareMountPath returns the path where the SMB share holding the image is mounted on node
+func getImageShareMountPath(stagingPath string) string {
+	return strings.TrimRight(stagingPath, "/") + imageShareMountSuffix
+}
+
+// stageImageVolume mounts the SMB share next to stagingPath, attaches the image to a loop device
+// and mounts the filesystem on stagingPath, the filesystem is formatted if it's not formatted yet.
+// For block volume, the loop device is only attached and is bind mounted in NodePublishVolume.
+func (d *Driver) stageImageVolume(ctx context.Context, volumeID, source, stagingPath string, volCap *csi.VolumeCapability, fsType string, mountOptions, sensitiveMountOptions []string, lockKey string, token lockToken) (keepLockHeld bool, err error) {
+	if runtime.GOOS != "linux" {
+		return false, status.Errorf(codes.InvalidArgument, "image volume is not supported on %s node", runtime.GOOS)
+	}
+
+	shareMountPath := getImageShareMountPath(stagingPath)
+	mounted, err := d.ensureMountPoint(shareMountPath)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "Could not mount target %s: %v", shareMountPath, err)
+	}
+	if !mounted {
+		if err := os.MkdirAll(shareMountPath, 0750); err != nil {
+			return false, status.Errorf(codes.Internal, "MkdirAll %s failed with error: %v", shareMountPath, err)
+		}
+		if keepLockHeld, err := d.mountWithTimeout(ctx, source, shareMountPath, mountOptions, sensitiveMountOptions, volumeID, lockKey, token, time.Duration(mountTimeoutInSec)*time.Second); err != nil {
+			return keepLockHeld, err
+		}
+		klog.V(2).Infof("volume(%s) mount %q on %q succeeded", volumeID, source, shareMountPath)
+	}
+
+	imagePath := filepath.Join(shareMountPath, imageFileName)
+	if _, err := os.Stat(imagePath); err != nil {
+		if os.IsNotExist(err) {
+			return false, status.Errorf(codes.NotFound, "image file %s not found in %s", imageFileName, source)
+		}
+		return false, status.Errorf(codes.Internal, "failed to stat image file %s: %v", imagePath, err)
+	}
+	// image of reader-only volume could be staged on several nodes, it's never written
+	readOnly := isReaderOnlyAccessMode(volCap)
+	device, err := attachLoopDevice(imagePath, readOnly)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to attach image file %s to loop device: %v", imagePath, err)
+	}
+	klog.V(2).Infof("volume(%s) image %s is attached to %s", volumeID, imagePath, device)
+
+	if volCap.GetBlock() != nil {
+		return false, nil
+	}
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, status.Errorf(codes.Internal, "failed to check mount point %s: %v", stagingPath, err)
+	}
+	if err == nil && !notMnt {
+		klog.V(2).Infof("NodeStageVolume: already mounted volume %s on target %s", volumeID, stagingPath)
+		return false, nil
+	}
+	if err := os.MkdirAll(stagingPath, 0750); err != nil {
+		return false, status.Errorf(codes.Internal, "MkdirAll %s failed with error: %v", stagingPath, err)
+	}
+	fsType = getImageFsType(volCap, fsType)
+	var fsMountOptions []string
+	if readOnly {
+		fsMountOptions = getImageReadOnlyMountOptions(fsType)
+	}
+	if err := d.mounter.FormatAndMount(device, stagingPath, fsType, fsMountOptions); err != nil {
+		return false, status.Errorf(codes.Internal, "failed to mount %s(%s) on %s: %v", device, fsType, stagingPath, err)
+	}
+	klog.V(2).Infof("volume(%s) filesystem(%s) on %s is mounted on %s", volumeID, fsType, device, stagingPath)
+	return false, nil
+}
+
+// unstageImageVolume unmounts the filesystem on stagingPath, detaches the loop device and unmounts the SMB share
+func (d *Driver) unstageImageVolume(volumeID, stagingPath string) error {
+	if err := CleanupMountPoint(d.mounter, stagingPath, true /*extensiveMountPointCheck*/); err != nil {
+		return fmt.Errorf("failed to unmount staging target %q: %v", stagingPath, err)
+	}
+	shareMountPath := getImageShareMountPath(stagingPath)
+	imagePath := filepath.Join(shareMountPath, imageFileName)
+	if err := detachLoopDevice(imagePath); err != nil {
+		return fmt.Errorf("failed to detach loop device of %s: %v", imagePath, err)
+	}
+	if err := CleanupSMBMountPoint(d.mounter, shareMountPath, true /*extensiveMountPointCheck*/, volumeID); err != nil {
+		return fmt.Errorf("failed to unmount %q: %v", shareMountPath, err)
+	}
+	return nil
+}
+
+// isImageVolumeStaged returns true if the SMB share holding image is mounted (or left) next to stagingPath
+func isImageVolumeStaged(stagingPath string) bool {
+	_, err := os.Lstat(getImageShareMountPath(stagingPath))
+	return err == nil || !os.IsNotExist(err)
+}
+
+// expandImageVolume refreshes the capacity of loop device attached in NodeStageVolume and resizes
+// the filesystem mounted on stagingPath online, returns the size of image file.
+func (d *Driver) expandImageVolume(volumeID, stagingPath string, volCap *csi.VolumeCapability) (int64, error) {
+	imagePath := filepath.Join(getImageShareMountPath(stagingPath), imageFileName)
+	device, err := findLoopDevice(imagePath)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to find loop device of %s: %v", imagePath, err)
+	}
+	if device == "" {
+		return 0, status.Errorf(codes.FailedPrecondition, "volume %s is not staged on %s", volumeID, stagingPath)
+	}
+	if err := resizeLoopDevice(device); err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to resize loop device %s: %v", device, err)
+	}
+	if volCap.GetBlock() == nil {
+		if _, err := resizeFs(d.mounter.Exec, device, stagingPath); err != nil {
+			return 0, status.Errorf(codes.Internal, "failed to resize filesystem on %s(%s): %v", device, stagingPath, err)
+		}
+	}
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to stat image file %s: %v", imagePath, err)
+	}
+	return info.Size(), nil
+}
+
+// publishBlockVolume bind mounts the loop device attached in NodeStageVolume to target file
+func (d *Driver) publishBlockVolume(volumeID, stagingPath, target string, readOnly bool) error {
+	imagePath := filepath.Join(getImageShareMountPath(stagingPath), imageFileName)
+	device, err := findLoopDevice(imagePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find loop device of %s: %v", imagePath, err)
+	}
+	if device == "" {
+		return status.Errorf(codes.FailedPrecondition, "volume %s is not staged on %s", volumeID, stagingPath)
+	}
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(target)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "failed to check mount point %s: %v", target, err)
+	}
+	if err == nil && !notMnt {
+		klog.V(2).Infof("NodePublishVolume: %s is already mounted", target)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return status.Errorf(codes.Internal, "MkdirAll %s failed with error: %v", filepath.Dir(target), err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE, 0660)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create target file %s: %v", target, err)
+	}
+	f.Close()
+
+	options := []string{"bind"}
+	if readOnly {
+		options = append(options, "ro")
+	}
+	klog.V(2).Infof("NodePublishVolume: mounting block device %s at %s with mountOptions: %v volumeID(%s)", device, target, options, volumeID)
+	if err := d.mounter.Mount(device, target, "", options); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
+		}
+		return status.Errorf(codes.Internal, "Could not mount %q at %q: %v", device, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommandRunner records the commands and their stdin and returns the configured output
+type fakeCommandRunner struct {
+	commands []string
+	inputs   map[string]string
+	outputs  map[string]string
+	errors   map[string]error
+}
+
+func (f *fakeCommandRunner) run(name string, args ...string) ([]byte, error) {
+	cmd := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	f.commands = append(f.commands, cmd)
+	return []byte(f.outputs[cmd]), f.errors[cmd]
+}
+
+func (f *fakeCommandRunner) runWithInput(input, name string, args ...string) ([]byte, error) {
+	cmd := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	if f.inputs == nil {
+		f.inputs = map[string]string{}
+	}
+	f.inputs[cmd] = input
+	return f.run(name, args...)
+}
+
+func setFakeCommandRunner(t *testing.T, f *fakeCommandRunner) {
+	orig, origWithInput := runCommand, runCommandWithInput
+	runCommand, runCommandWithInput = f.run, f.runWithInput
+	t.Cleanup(func() { runCommand, runCommandWithInput = orig, origWithInput })
+}
+
+func TestIsImageVolume(t *testing.T) {
+	assert.True(t, isImageVolume(map[string]string{"volumeType": "Image"}))
+	assert.True(t, isImageVolume(map[string]string{volumeTypeField: volumeTypeImage}))
+	assert.False(t, isImageVolume(map[string]string{volumeTypeField: ""}))
+	assert.False(t, isImageVolume(nil))
+}
+
+func TestValidateImageVolumeCapabilities(t *testing.T) {
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	multiWriterCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	btrfsCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "btrfs"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	imageContext := map[string]string{volumeTypeField: volumeTypeImage}
+
+	tests := []struct {
+		desc        string
+		volCaps     []*csi.VolumeCapability
+		context     map[string]string
+		expectedErr error
+	}{
+		{
+			desc:        "block volume is not supported by share volume",
+			volCaps:     []*csi.VolumeCapability{blockCap},
+			expectedErr: fmt.Errorf("block volume capability not supported"),
+		},
+		{
+			desc:    "block volume is supported by image volume",
+			volCaps: []*csi.VolumeCapability{blockCap},
+			context: imageContext,
+		},
+		{
+			desc:    "multi node writer is supported by share volume",
+			volCaps: []*csi.VolumeCapability{multiWriterCap},
+		},
+		{
+			desc:        "multi node writer is not supported by image volume",
+			volCaps:     []*csi.VolumeCapability{multiWriterCap},
+			context:     imageContext,
+			expectedErr: fmt.Errorf("access mode MULTI_NODE_MULTI_WRITER is not supported by image volume"),
+		},
+		{
+			desc:        "unsupported fsType",
+			volCaps:     []*csi.VolumeCapability{btrfsCap},
+			context:     imageContext,
+			expectedErr: fmt.Errorf("fstype(btrfs) is not supported, supported values: [ext3 ext4 xfs]"),
+		},
+		{
+			desc:        "volume capabilities missing",
+			context:     imageContext,
+			expectedErr: fmt.Errorf("volume capabilities missing in request"),
+		},
+	}
+	for _, test := range tests {
+		err := validateVolumeCapabilities(test.volCaps, test.context)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+	}
+}
+
+func TestGetImageFsType(t *testing.T) {
+	assert.Equal(t, defaultImageFsType, getImageFsType(nil, ""))
+	assert.Equal(t, "xfs", getImageFsType(nil, "XFS"))
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext3"}},
+	}
+	assert.Equal(t, "ext3", getImageFsType(volCap, "xfs"))
+}
+
+func TestImageReadOnlyMountOptions(t *testing.T) {
+	readerOnly := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY}}
+	writer := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}
+	assert.True(t, isReaderOnlyAccessMode(readerOnly))
+	assert.False(t, isReaderOnlyAccessMode(writer))
+	assert.Equal(t, []string{"ro", "noload"}, getImageReadOnlyMountOptions("ext4"))
+	assert.Equal(t, []string{"ro", "norecovery"}, getImageReadOnlyMountOptions("xfs"))
+}
+
+func TestCreateImageFile(t *testing.T) {
+	runner := &fakeCommandRunner{errors: map[string]error{}}
+	setFakeCommandRunner(t, runner)
+
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, imageFileName)
+	assert.NoError(t, createImageFile(dir, 1<<20, "ext4"))
+	info, err := os.Stat(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20), info.Size())
+	assert.Equal(t, []string{"mkfs.ext4 -F -q " + imagePath}, runner.commands)
+
+	// existing image is not formatted again, but extended if it's smaller
+	runner.commands = nil
+	assert.NoError(t, createImageFile(dir, 2<<20, "ext4"))
+	info, err = os.Stat(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2<<20), info.Size())
+	assert.Empty(t, runner.commands)
+
+	// block volume is not formatted
+	blockDir := t.TempDir()
+	assert.NoError(t, createImageFile(blockDir, 1<<20, ""))
+	assert.Empty(t, runner.commands)
+
+	// image is removed if format failed
+	xfsDir := t.TempDir()
+	xfsImagePath := filepath.Join(xfsDir, imageFileName)
+	runner.errors["mkfs.xfs -f -q "+xfsImagePath] = fmt.Errorf("exit status 1")
+	assert.Error(t, createImageFile(xfsDir, 1<<20, "xfs"))
+	_, err = os.Stat(xfsImagePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExpandImageFile(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), imageFileName)
+	_, err := expandImageFile(imagePath, 1<<20)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.WriteFile(imagePath, nil, 0600))
+	size, err := expandImageFile(imagePath, 2<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2<<20), size)
+
+	// image file is not shrunk
+	size, err = expandImageFile(imagePath, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2<<20), size)
+}
+
+func TestCreateImageVolume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows: internal mount requires csi-proxy mounter")
+	}
+	runner := &fakeCommandRunner{}
+	setFakeCommandRunner(t, runner)
+
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+
+	req := &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 20},
+		Parameters: map[string]string{
+			sourceField:     testServer,
+			volumeTypeField: volumeTypeImage,
+		},
+		Secrets: map[string]string{
+			usernameField: "test",
+			passwordField: "test",
+		},
+	}
+	resp, err := d.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20), resp.GetVolume().GetCapacityBytes())
+	assert.Equal(t, volumeTypeImage, resp.GetVolume().GetVolumeContext()[volumeTypeField])
+	assert.Contains(t, resp.GetVolume().GetVolumeId(), "volumetype=image")
+
+	imagePath := filepath.Join(d.workingMountDir, testCSIVolume, testCSIVolume, imageFileName)
+	info, err := os.Stat(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20), info.Size())
+	assert.Equal(t, []string{"mkfs.xfs -f -q " + imagePath}, runner.commands)
+
+	// capacity is required
+	req.CapacityRange = nil
+	_, err = d.CreateVolume(context.Background(), req)
+	assert.Error(t, err)
+}
+
+func TestGetImageShareMountPath(t *testing.T) {
+	assert.Equal(t, "/var/lib/kubelet/globalmount.smbshare", getImageShareMountPath("/var/lib/kubelet/globalmount/"))
+	assert.False(t, isImageVolumeStaged(filepath.Join(t.TempDir(), "globalmount")))
+
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	assert.NoError(t, os.MkdirAll(getImageShareMountPath(stagingPath), 0750))
+	assert.True(t, isImageVolumeStaged(stagingPath))
+}
+
+func TestStageImageVolumeNotSupported(t *testing.T) {
+	if runtime.GOOS == "linux" {
+		t.Skip("image volume is supported on linux")
+	}
+	d := NewFakeDriver()
+	_, err := d.stageImageVolume(context.Background(), "vol", "//server/share", t.TempDir(), nil, "", nil, nil, "lock", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// credential modes of internal mounts, recorded in volume context by CreateVolume
+const (
+	credentialModePassword = "password"
+	credentialModeKerberos = "kerberos"
+	credentialModeGuest    = "guest"
+)
+
+// getCredentialMode returns how the share is authenticated with mountFlags
+func getCredentialMode(mountFlags []string) string {
+	switch {
+	case hasKerberosMountOption(mountFlags):
+		return credentialModeKerberos
+	case hasGuestMountOptions(mountFlags):
+		return credentialModeGuest
+	default:
+		return credentialModePassword
+	}
+}
+
+// splitMountOptions splits comma separated mount options
+func splitMountOptions(mountOptions string) []string {
+	var options []string
+	for _, option := range strings.Split(mountOptions, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// checkCredentials returns an error if secrets do not have the credentials of credential mode
+func (d *Driver) checkCredentials(mode string, secrets map[string]string) error {
+	switch mode {
+	case credentialModePassword:
+		for k := range secrets {
+			if strings.EqualFold(k, usernameField) {
+				return nil
+			}
+		}
+		return fmt.Errorf("volume is provisioned with %s credentials, but %s is not found in secrets", mode, usernameField)
+	case credentialModeKerberos:
+		for k := range secrets {
+			if strings.HasPrefix(k, d.krb5Prefix) {
+				return nil
+			}
+		}
+		return fmt.Errorf("volume is provisioned with %s credentials, but kerberos cache %s<uid> is not found in secrets", mode, d.krb5Prefix)
+	}
+	return nil
+}
+
+// getVolumeMountOptions returns the mount options of internal mounts of an existing volume: the mount options
+// recorded in volume context by CreateVolume, or mount options of the PV if it's created before they are recorded,
+// overridden by mountOptions in secrets. An error is returned if secrets do not match the recorded credential mode.
+func (d *Driver) getVolumeMountOptions(ctx context.Context, volumeID string, secrets map[string]string) (string, error) {
+	var options []string
+	var mode string
+	if d.kubeClient != nil {
+		pv, err := d.getPVByVolumeID(ctx, volumeID)
+		if err != nil {
+			klog.Warningf("failed to get PV of volume %s, use mount options in secrets: %v", volumeID, err)
+		} else if pv != nil {
+			options = pv.Spec.MountOptions
+			for k, v := range pv.Spec.CSI.VolumeAttributes {
+				switch strings.ToLower(k) {
+				case internalMountOptionsField:
+					options = splitMountOptions(v)
+				case credentialModeField:
+					mode = v
+				}
+			}
+		}
+	}
+	if secretOptions := getMountOptions(secrets); secretOptions != "" {
+		options = mergeMountOptions(options, splitMountOptions(secretOptions))
+	}
+	if mode != "" {
+		if err := d.checkCredentials(mode, secrets); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(options, ","), nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetCredentialMode(t *testing.T) {
+	tests := []struct {
+		mountFlags []string
+		expected   string
+	}{
+		{mountFlags: nil, expected: credentialModePassword},
+		{mountFlags: []string{"vers=3.0", "sec=ntlmssp"}, expected: credentialModePassword},
+		{mountFlags: []string{"sec=krb5", "cruid=1000"}, expected: credentialModeKerberos},
+		{mountFlags: []string{"guest"}, expected: credentialModeGuest},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getCredentialMode(test.mountFlags), "%v", test.mountFlags)
+	}
+}
+
+func TestSplitMountOptions(t *testing.T) {
+	assert.Nil(t, splitMountOptions(""))
+	assert.Equal(t, []string{"vers=3.0", "sec=ntlmssp"}, splitMountOptions(" vers=3.0,,sec=ntlmssp "))
+}
+
+func TestCheckCredentials(t *testing.T) {
+	d := NewFakeDriver()
+	tests := []struct {
+		desc        string
+		mode        string
+		secrets     map[string]string
+		expectedErr bool
+	}{
+		{desc: "password", mode: credentialModePassword, secrets: map[string]string{usernameField: "user", passwordField: "pass"}},
+		{desc: "password without username", mode: credentialModePassword, secrets: map[string]string{d.krb5Prefix + "1000": "cache"}, expectedErr: true},
+		{desc: "kerberos", mode: credentialModeKerberos, secrets: map[string]string{d.krb5Prefix + "1000": "cache"}},
+		{desc: "kerberos without cache", mode: credentialModeKerberos, secrets: map[string]string{usernameField: "user"}, expectedErr: true},
+		{desc: "guest", mode: credentialModeGuest},
+	}
+	for _, test := range tests {
+		err := d.checkCredentials(test.mode, test.secrets)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+	}
+}
+
+func TestGetVolumeMountOptions(t *testing.T) {
+	d := NewFakeDriver()
+	newPV := func(name, volumeID string, mountOptions []string, attributes map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				MountOptions: mountOptions,
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: volumeID, VolumeAttributes: attributes},
+				},
+			},
+		}
+	}
+	d.kubeClient = fake.NewSimpleClientset(
+		newPV("pv-1", "smb-server/share#pv-1##", []string{"dir_mode=0777"}, map[string]string{
+			internalMountOptionsField: "vers=3.0,sec=ntlmssp",
+			credentialModeField:       credentialModePassword,
+		}),
+		newPV("pv-2", "smb-server/share#pv-2##", []string{"vers=2.1"}, nil),
+		newPV("pv-3", "smb-server/share#pv-3##", nil, map[string]string{
+			internalMountOptionsField: "sec=krb5,cruid=1000",
+			credentialModeField:       credentialModeKerberos,
+		}),
+	)
+	password := map[string]string{usernameField: "user", passwordField: "pass"}
+
+	tests := []struct {
+		desc        string
+		volumeID    string
+		secrets     map[string]string
+		expected    string
+		expectedErr bool
+	}{
+		{desc: "recorded mount options", volumeID: "smb-server/share#pv-1##", secrets: password, expected: "vers=3.0,sec=ntlmssp"},
+		{desc: "mount options in secrets override recorded ones", volumeID: "smb-server/share#pv-1##",
+			secrets: map[string]string{usernameField: "user", mountOptionsField: "vers=3.1.1,nobrl"}, expected: "sec=ntlmssp,vers=3.1.1,nobrl"},
+		{desc: "mount options of PV created before they are recorded", volumeID: "smb-server/share#pv-2##", secrets: password, expected: "vers=2.1"},
+		{desc: "PV not found", volumeID: "smb-server/share#pv-4##", secrets: map[string]string{mountOptionsField: "vers=3.0"}, expected: "vers=3.0"},
+		{desc: "kerberos volume without kerberos cache", volumeID: "smb-server/share#pv-3##", secrets: password, expectedErr: true},
+		{desc: "kerberos volume", volumeID: "smb-server/share#pv-3##", secrets: map[string]string{d.krb5Prefix + "1000": "cache"}, expected: "sec=krb5,cruid=1000"},
+	}
+	for _, test := range tests {
+		options, err := d.getVolumeMountOptions(context.Background(), test.volumeID, test.secrets)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, options, test.desc)
+	}
+}
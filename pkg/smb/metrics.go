@@ -0,0 +1,113 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	metricsSubsystem = "smb_csi_driver"
+
+	lockResultAcquired = "acquired"
+	lockResultAborted  = "aborted"
+)
+
+var (
+	// lockWaitDuration records how long an operation waits for a volume lock,
+	// keys are not used as label since there could be a large number of volumes.
+	lockWaitDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "volume_lock_wait_duration_seconds",
+			Help:           "Time spent waiting for a volume lock, by operation and result.",
+			Buckets:        []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "result"},
+	)
+	lockWaiters = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "volume_lock_waiters",
+			Help:           "Number of operations waiting for a volume lock, by operation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+	serverOpWaitDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "server_operation_wait_duration_seconds",
+			Help:           "Time spent waiting for the per SMB server concurrency and rate limits, by limiter, operation and result.",
+			Buckets:        []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"limiter", "operation", "result"},
+	)
+	serverOpQueueDepth = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "server_operation_queue_depth",
+			Help:           "Number of operations waiting for the per SMB server concurrency and rate limits, by limiter and server.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"limiter", "server"},
+	)
+	cloneBytes = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "volume_clone_bytes_total",
+			Help:           "Number of bytes copied when cloning volumes, by copy method (server-side-copy or client-side-copy).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method"},
+	)
+	sourcePlacements = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "source_placement_total",
+			Help:           "Number of placement decisions on sources of source pools in CreateVolume, by placement policy, source and result (selected or unreachable).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"policy", "source", "result"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+func init() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(lockWaitDuration, lockWaiters, serverOpWaitDuration, serverOpQueueDepth, cloneBytes, sourcePlacements)
+	})
+}
+
+func recordLockWait(operation, result string, duration time.Duration) {
+	lockWaitDuration.WithLabelValues(operation, result).Observe(duration.Seconds())
+}
+
+func recordServerOpWait(limiter, operation, result string, duration time.Duration) {
+	serverOpWaitDuration.WithLabelValues(limiter, operation, result).Observe(duration.Seconds())
+}
+
+func recordPlacement(policy, source, result string) {
+	sourcePlacements.WithLabelValues(policy, source, result).Inc()
+}
@@ -80,6 +80,13 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Error(codes.InvalidArgument, "Staging target not provided")
 	}
 
+	lockKey := fmt.Sprintf("%s-%s", volumeID, target)
+	token, acquired := d.volumeLocks.Acquire(ctx, lockKey, "NodePublishVolume")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer d.volumeLocks.Release(lockKey, token)
+
 	mountOptions := []string{"bind"}
 	readOnly := req.GetReadonly()
 
@@ -109,6 +116,14 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		mountOptions = append(mountOptions, "ro")
 	}
 
+	if volCap.GetBlock() != nil {
+		if err := d.publishBlockVolume(volumeID, source, target, readOnly); err != nil {
+			return nil, err
+		}
+		klog.V(2).Infof("NodePublishVolume: publish block volume %s at %s successfully", volumeID, target)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
 	mnt, err := d.ensureMountPoint(target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not mount target %q: %v", target, err)
@@ -134,7 +149,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 }
 
 // NodeUnpublishVolume unmount the volume from the target path
-func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
@@ -144,6 +159,13 @@ func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVo
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
+	lockKey := fmt.Sprintf("%s-%s", volumeID, targetPath)
+	token, acquired := d.volumeLocks.Acquire(ctx, lockKey, "NodeUnpublishVolume")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer d.volumeLocks.Release(lockKey, token)
+
 	klog.V(2).Infof("NodeUnpublishVolume: unmounting volume %s on %s", volumeID, targetPath)
 	err := CleanupMountPoint(d.mounter, targetPath, true /*extensiveMountPointCheck*/)
 	if err != nil {
@@ -183,8 +205,9 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	secrets := req.GetSecrets()
 	gidPresent := checkGidPresentInMountFlags(mountFlags)
 
-	var source, subDir, secretName, secretNamespace, ephemeralVolMountOptions string
-	var ephemeralVol bool
+	var source, subDir, secretName, secretNamespace, ephemeralVolMountOptions, fsType, snapshot string
+	var pvcMountOptions, pvcMountOptionOverrides, alternateServersValue, pvName string
+	var ephemeralVol, imageVol bool
 	subDirReplaceMap := map[string]string{}
 	for k, v := range context {
 		switch strings.ToLower(k) {
@@ -198,6 +221,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			subDirReplaceMap[pvcNameMetadata] = v
 		case pvNameKey:
 			subDirReplaceMap[pvNameMetadata] = v
+			pvName = v
 		case secretNameField:
 			secretName = v
 		case secretNamespaceField:
@@ -206,21 +230,54 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			ephemeralVol = strings.EqualFold(v, trueValue)
 		case mountOptionsField:
 			ephemeralVolMountOptions = v
+		case volumeTypeField:
+			imageVol = strings.EqualFold(v, volumeTypeImage)
+		case fsTypeField:
+			fsType = v
+		case snapshotField:
+			snapshot = v
+		case pvcMountOptionsField:
+			pvcMountOptions = v
+		case pvcMountOptionOverridesField:
+			pvcMountOptionOverrides = v
+		case alternateServersField:
+			alternateServersValue = v
 		}
 	}
 
 	if source == "" {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("%s field is missing, current context: %v", sourceField, context))
 	}
+	if imageVol && ephemeralVol {
+		return nil, status.Error(codes.InvalidArgument, "image volume could not be used as ephemeral volume")
+	}
+	alternateServers, err := parseAlternateServers(alternateServersValue)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if imageVol && len(alternateServers) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s of image volume is not supported", alternateServersField)
+	}
+	var snapshotMountOptions []string
+	if snapshot != "" {
+		if imageVol {
+			return nil, status.Error(codes.InvalidArgument, "snapshot of image volume is not supported")
+		}
+		var err error
+		if snapshotMountOptions, err = getSnapshotMountOptions(snapshot); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
 
 	lockKey := fmt.Sprintf("%s-%s", volumeID, targetPath)
-	if acquired := d.volumeLocks.TryAcquire(lockKey); !acquired {
+	token, acquired := d.volumeLocks.Acquire(ctx, lockKey, "NodeStageVolume")
+	if !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
 	}
 	releaseLock := true
 	defer func() {
 		if releaseLock {
-			d.volumeLocks.Release(lockKey)
+			d.volumeLocks.Release(lockKey, token)
 		}
 	}()
 
@@ -238,6 +295,14 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 
 	if ephemeralVol {
 		mountFlags = strings.Split(ephemeralVolMountOptions, ",")
+	} else if pvcMountOptions != "" {
+		// mount options in PVC annotation, validated again in case volume context is edited
+		overrides, err := validateMountOptionOverrides(pvcMountOptions, pvcMountOptionOverrides)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s in volume context: %v", pvcMountOptionsField, err)
+		}
+		mountFlags = mergeMountOptions(mountFlags, overrides)
+		gidPresent = checkGidPresentInMountFlags(mountFlags)
 	}
 
 	// in guest login, username and password options are not needed
@@ -250,6 +315,15 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			return nil, status.Error(codes.Internal, fmt.Sprintf("Error getting username and password from secret %s in namespace %s: %v", secretName, secretNamespace, err))
 		}
 	}
+	if !ephemeralVol && requireUsernamePwdOption && username == "" && secretName != "" {
+		// secret of the user created for the volume in CreateVolume
+		klog.V(2).Infof("NodeStageVolume: getting username and password of volume user from secret %s in namespace %s", secretName, secretNamespace)
+		var err error
+		username, password, domain, err = d.GetUserNamePasswordFromSecret(ctx, secretName, secretNamespace)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Error getting username and password from secret %s in namespace %s: %v", secretName, secretNamespace, err))
+		}
+	}
 
 	var mountOptions, sensitiveMountOptions []string
 	if runtime.GOOS == "windows" {
@@ -291,11 +365,28 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		if domain != "" {
 			mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", domainField, domain))
 		}
+		// mount the snapshot (previous version) of the share read-only
+		mountOptions = append(mountOptions, snapshotMountOptions...)
 	}
 
 	klog.V(2).Infof("NodeStageVolume: targetPath(%v) volumeID(%v) context(%v) mountflags(%v) mountOptions(%v)",
 		targetPath, volumeID, context, mountFlags, mountOptions)
 
+	if imageVol {
+		source, err := getSourceWithSubDir(source, subDir, subDirReplaceMap)
+		if err != nil {
+			return nil, err
+		}
+		keepLockHeld, err := d.stageImageVolume(ctx, volumeID, source, targetPath, volumeCapability, fsType, mountOptions, sensitiveMountOptions, lockKey, token)
+		if keepLockHeld {
+			releaseLock = false
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	isDirMounted, err := d.ensureMountPoint(targetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not mount target %s: %v", targetPath, err)
@@ -306,18 +397,20 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		if err = prepareStagePath(targetPath, d.mounter); err != nil {
 			return nil, fmt.Errorf("prepare stage path failed for %s with error: %v", targetPath, err)
 		}
-		if subDir != "" {
-			// replace pv/pvc name namespace metadata in subDir
-			subDir = replaceWithMap(subDir, subDirReplaceMap)
-
-			source = strings.TrimRight(source, "/")
-			source = fmt.Sprintf("%s/%s", source, subDir)
+		if len(alternateServers) > 0 {
+			keepLockHeld, mountErr := d.mountWithFailover(ctx, source, subDir, subDirReplaceMap, alternateServers, targetPath, mountOptions, sensitiveMountOptions, volumeID, lockKey, token, pvName)
+			if keepLockHeld {
+				releaseLock = false
+			}
+			if mountErr != nil {
+				return nil, mountErr
+			}
+			return &csi.NodeStageVolumeResponse{}, nil
 		}
-
-		if err := validatePath(source); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid source path %q: %v", source, err)
+		if source, err = getSourceWithSubDir(source, subDir, subDirReplaceMap); err != nil {
+			return nil, err
 		}
-		keepLockHeld, mountErr := d.mountWithTimeout(ctx, source, targetPath, mountOptions, sensitiveMountOptions, volumeID, lockKey, time.Duration(mountTimeoutInSec)*time.Second)
+		keepLockHeld, mountErr := d.mountWithTimeout(ctx, source, targetPath, mountOptions, sensitiveMountOptions, volumeID, lockKey, token, time.Duration(mountTimeoutInSec)*time.Second)
 		if keepLockHeld {
 			releaseLock = false
 		}
@@ -330,6 +423,22 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// getSourceWithSubDir returns source/subDir, pv/pvc name namespace metadata in subDir is replaced
+func getSourceWithSubDir(source, subDir string, subDirReplaceMap map[string]string) (string, error) {
+	if subDir != "" {
+		// replace pv/pvc name namespace metadata in subDir
+		subDir = replaceWithMap(subDir, subDirReplaceMap)
+
+		source = strings.TrimRight(source, "/")
+		source = fmt.Sprintf("%s/%s", source, subDir)
+	}
+
+	if err := validatePath(source); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid source path %q: %v", source, err)
+	}
+	return source, nil
+}
+
 // mountWithTimeout runs Mount in a goroutine and waits for it to finish, the
 // gRPC context to be canceled, or the configured mount timeout to elapse. When
 // the mount is still running after the wait ends (timeout or ctx cancellation),
@@ -338,15 +447,31 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 // returns. This prevents kubelet retries from spawning additional mount
 // goroutines against the same target while a slow mount is still in flight.
 //
+// The mount waits for the per server mount limit before it's started.
+//
 // Returned errors are already wrapped in gRPC status errors:
+//   - mount limit not acquired before ctx done -> codes.Aborted
 //   - mount failure                            -> codes.Internal
 //   - timer expired                            -> codes.DeadlineExceeded
 //   - ctx canceled with DeadlineExceeded cause -> codes.DeadlineExceeded
 //   - ctx canceled (client canceled)           -> codes.Canceled
-func (d *Driver) mountWithTimeout(ctx context.Context, source, targetPath string, mountOptions, sensitiveMountOptions []string, volumeID, lockKey string, timeout time.Duration) (keepLockHeld bool, err error) {
+func (d *Driver) mountWithTimeout(ctx context.Context, source, targetPath string, mountOptions, sensitiveMountOptions []string, volumeID, lockKey string, token lockToken, timeout time.Duration) (keepLockHeld bool, err error) {
+	release, err := d.mountLimiter.Acquire(ctx, source, serverOpMount)
+	if err != nil {
+		return false, err
+	}
 	mountDone := make(chan error, 1)
+	d.pendingMounts.Store(lockKey, pendingMount{
+		VolumeID:  volumeID,
+		Source:    source,
+		Target:    targetPath,
+		StartedAt: time.Now(),
+	})
 	go func() {
-		mountDone <- Mount(d.mounter, source, targetPath, "cifs", mountOptions, sensitiveMountOptions, volumeID)
+		err := Mount(d.mounter, source, targetPath, "cifs", mountOptions, sensitiveMountOptions, volumeID)
+		release()
+		d.pendingMounts.Delete(lockKey)
+		mountDone <- err
 	}()
 
 	timer := time.NewTimer(timeout)
@@ -358,7 +483,7 @@ func (d *Driver) mountWithTimeout(ctx context.Context, source, targetPath string
 			if mountErr != nil {
 				klog.Warningf("volume(%s) mount %q on %q failed after %s with %v", volumeID, source, targetPath, reason, mountErr)
 			}
-			d.volumeLocks.Release(lockKey)
+			d.volumeLocks.Release(lockKey, token)
 			klog.V(2).Infof("volume(%s) mount goroutine finished after %s, released lock", volumeID, reason)
 		}()
 	}
@@ -403,7 +528,7 @@ func (d *Driver) mountWithTimeout(ctx context.Context, source, targetPath string
 }
 
 // NodeUnstageVolume unmount the volume from the staging path
-func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
@@ -414,14 +539,22 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 	}
 
 	lockKey := fmt.Sprintf("%s-%s", volumeID, stagingTargetPath)
-	if acquired := d.volumeLocks.TryAcquire(lockKey); !acquired {
+	token, acquired := d.volumeLocks.Acquire(ctx, lockKey, "NodeUnstageVolume")
+	if !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
 	}
-	defer d.volumeLocks.Release(lockKey)
+	defer d.volumeLocks.Release(lockKey, token)
 
-	klog.V(2).Infof("NodeUnstageVolume: CleanupMountPoint on %s with volume %s", stagingTargetPath, volumeID)
-	if err := CleanupSMBMountPoint(d.mounter, stagingTargetPath, true /*extensiveMountPointCheck*/, volumeID); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
+	if isImageVolumeStaged(stagingTargetPath) {
+		klog.V(2).Infof("NodeUnstageVolume: unstage image volume %s on %s", volumeID, stagingTargetPath)
+		if err := d.unstageImageVolume(volumeID, stagingTargetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unstage image volume: %v", err)
+		}
+	} else {
+		klog.V(2).Infof("NodeUnstageVolume: CleanupMountPoint on %s with volume %s", stagingTargetPath, volumeID)
+		if err := CleanupSMBMountPoint(d.mounter, stagingTargetPath, true /*extensiveMountPointCheck*/, volumeID); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
+		}
 	}
 
 	if err := deleteKerberosCache(d.krb5CacheDirectory, volumeID); err != nil {
@@ -432,6 +565,28 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// getBlockVolumeStats returns the capacity of block volume, usage of block volume is unknown
+func (d *Driver) getBlockVolumeStats(volumeID, devicePath string) (*csi.NodeGetVolumeStatsResponse, error) {
+	volumeMetrics, err := volume.NewMetricsBlock(devicePath).GetMetrics()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get metrics: %v", err)
+	}
+	capacity, ok := volumeMetrics.Capacity.AsInt64()
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "failed to transform volume capacity size(%v)", volumeMetrics.Capacity)
+	}
+	resp := csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:  csi.VolumeUsage_BYTES,
+				Total: capacity,
+			},
+		},
+	}
+	d.volStatsCache.Set(volumeID, &resp)
+	return &resp, nil
+}
+
 // NodeGetCapabilities return the capabilities of the Node plugin
 func (d *Driver) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	return &csi.NodeGetCapabilitiesResponse{
@@ -440,14 +595,23 @@ func (d *Driver) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabiliti
 }
 
 // NodeGetInfo return info of the node on which this plugin is running
-func (d *Driver) NodeGetInfo(_ context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
+func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	resp := &csi.NodeGetInfoResponse{
 		NodeId: d.NodeID,
-	}, nil
+	}
+	if d.topologyEnabled() {
+		segments, err := d.getNodeTopology(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		klog.V(2).Infof("NodeGetInfo: topology of node %s: %v", d.NodeID, segments)
+		resp.AccessibleTopology = &csi.Topology{Segments: segments}
+	}
+	return resp, nil
 }
 
 // NodeGetVolumeStats get volume stats
-func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	if len(req.VolumeId) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume ID was empty")
 	}
@@ -466,12 +630,24 @@ func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeSta
 		return resp, nil
 	}
 
-	if _, err := os.Lstat(req.VolumePath); err != nil {
+	// stats could be collected concurrently, but not while the volume path is being published or unpublished
+	lockKey := fmt.Sprintf("%s-%s", req.VolumeId, req.VolumePath)
+	token, acquired := d.volumeLocks.AcquireShared(ctx, lockKey, "NodeGetVolumeStats")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, req.VolumeId)
+	}
+	defer d.volumeLocks.Release(lockKey, token)
+
+	info, err := os.Lstat(req.VolumePath)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "path %s does not exist", req.VolumePath)
 		}
 		return nil, status.Errorf(codes.Internal, "failed to stat file %s: %v", req.VolumePath, err)
 	}
+	if info.Mode()&os.ModeDevice != 0 {
+		return d.getBlockVolumeStats(req.VolumeId, req.VolumePath)
+	}
 
 	volumeMetrics, err := volume.NewMetricsStatFS(req.VolumePath).GetMetrics()
 	if err != nil {
@@ -527,9 +703,40 @@ func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeSta
 }
 
 // NodeExpandVolume node expand volume
-// N/A for smb
-func (d *Driver) NodeExpandVolume(_ context.Context, _ *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// only image volume needs to be expanded on node, nothing to do for smb share
+func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetVolumePath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+
+	stagingPath := req.GetStagingTargetPath()
+	if stagingPath == "" || !isImageVolumeStaged(stagingPath) {
+		klog.V(2).Infof("NodeExpandVolume(%s) is not an image volume, skip expansion", volumeID)
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: requiredBytes}, nil
+	}
+
+	lockKey := fmt.Sprintf("%s-%s", volumeID, stagingPath)
+	token, acquired := d.volumeLocks.Acquire(ctx, lockKey, "NodeExpandVolume")
+	if !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer d.volumeLocks.Release(lockKey, token)
+
+	size, err := d.expandImageVolume(volumeID, stagingPath, req.GetVolumeCapability())
+	if err != nil {
+		return nil, err
+	}
+	// refresh volume stats after expansion
+	if err := d.volStatsCache.Delete(volumeID); err != nil {
+		klog.Warningf("failed to delete volume stats cache of %s: %v", volumeID, err)
+	}
+	klog.V(2).Infof("NodeExpandVolume(%s) on %s successfully, capacity: %d bytes", volumeID, stagingPath, size)
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: size}, nil
 }
 
 // ensureMountPoint: create mount point if not exists
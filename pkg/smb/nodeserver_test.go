@@ -168,7 +168,21 @@ func TestNodeStageVolume(t *testing.T) {
 				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
 			},
 			cleanup: func(d *Driver) {
-				d.volumeLocks.Release(fmt.Sprintf("%s-%s", "vol_1", sourceTest))
+				d.volumeLocks.ForceRelease(fmt.Sprintf("%s-%s", "vol_1", sourceTest))
+			},
+		},
+		{
+			desc: "[Error] PVC mount options not allowed",
+			req: &csi.NodeStageVolumeRequest{VolumeId: "vol_1##", StagingTargetPath: sourceTest,
+				VolumeCapability: &stdVolCap,
+				VolumeContext: map[string]string{
+					sourceField:                  testSource,
+					pvcMountOptionsField:         "actimeo=7200",
+					pvcMountOptionOverridesField: "actimeo=0-3600",
+				},
+				Secrets: secrets},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "invalid pvcmountoptions in volume context: value \"7200\" of mount option actimeo is not allowed by actimeo=0-3600"),
 			},
 		},
 		{
@@ -337,11 +351,120 @@ func TestNodeGetCapabilities(t *testing.T) {
 
 func TestNodeExpandVolume(t *testing.T) {
 	d := NewFakeDriver()
-	req := csi.NodeExpandVolumeRequest{}
-	resp, err := d.NodeExpandVolume(context.Background(), &req)
-	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
-		t.Errorf("Unexpected error: %v", err)
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	capacityRange := &csi.CapacityRange{RequiredBytes: 2 << 20}
+
+	// image volume staged on stagingPath
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	imagePath := filepath.Join(getImageShareMountPath(stagingPath), imageFileName)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(imagePath), 0750))
+	assert.NoError(t, os.WriteFile(imagePath, nil, 0600))
+	assert.NoError(t, os.Truncate(imagePath, 2<<20))
+
+	runner := &fakeCommandRunner{outputs: map[string]string{}}
+	setFakeCommandRunner(t, runner)
+	var resizedDevice, resizedPath string
+	origResizeFs := resizeFs
+	resizeFs = func(_ exec.Interface, devicePath, deviceMountPath string) (bool, error) {
+		resizedDevice, resizedPath = devicePath, deviceMountPath
+		return true, nil
+	}
+	defer func() { resizeFs = origResizeFs }()
+
+	tests := []struct {
+		desc          string
+		setup         func()
+		req           *csi.NodeExpandVolumeRequest
+		expectedSize  int64
+		expectedErr   error
+		expectedCmds  []string
+		expectResized bool
+	}{
+		{
+			desc:        "volume ID missing",
+			req:         &csi.NodeExpandVolumeRequest{},
+			expectedErr: status.Error(codes.InvalidArgument, "Volume ID missing in request"),
+		},
+		{
+			desc:        "volume path missing",
+			req:         &csi.NodeExpandVolumeRequest{VolumeId: "vol_1"},
+			expectedErr: status.Error(codes.InvalidArgument, "Volume path missing in request"),
+		},
+		{
+			desc: "smb share volume",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:          "vol_1",
+				VolumePath:        "/target",
+				StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+				CapacityRange:     capacityRange,
+			},
+			expectedSize: 2 << 20,
+		},
+		{
+			desc: "image volume is not attached",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:          "vol_1",
+				VolumePath:        "/target",
+				StagingTargetPath: stagingPath,
+				CapacityRange:     capacityRange,
+			},
+			expectedErr:  status.Errorf(codes.FailedPrecondition, "volume vol_1 is not staged on %s", stagingPath),
+			expectedCmds: []string{"losetup --associated " + imagePath},
+		},
+		{
+			desc: "image filesystem volume",
+			setup: func() {
+				runner.outputs["losetup --associated "+imagePath] = "/dev/loop1: [0047]:123 (" + imagePath + ")"
+			},
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:          "vol_1",
+				VolumePath:        "/target",
+				StagingTargetPath: stagingPath,
+				CapacityRange:     capacityRange,
+			},
+			expectedSize:  2 << 20,
+			expectedCmds:  []string{"losetup --associated " + imagePath, "losetup --set-capacity /dev/loop1"},
+			expectResized: true,
+		},
+		{
+			desc: "image block volume",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:          "vol_1",
+				VolumePath:        "/target",
+				StagingTargetPath: stagingPath,
+				CapacityRange:     capacityRange,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				},
+			},
+			expectedSize: 2 << 20,
+			expectedCmds: []string{"losetup --associated " + imagePath, "losetup --set-capacity /dev/loop1"},
+		},
+	}
+
+	for _, test := range tests {
+		if runtime.GOOS != "linux" && len(test.expectedCmds) > 0 {
+			continue
+		}
+		if test.setup != nil {
+			test.setup()
+		}
+		runner.commands = nil
+		resizedDevice, resizedPath = "", ""
+		resp, err := d.NodeExpandVolume(context.Background(), test.req)
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedSize, resp.GetCapacityBytes(), test.desc)
+		assert.Equal(t, test.expectedCmds, runner.commands, test.desc)
+		if test.expectResized {
+			assert.Equal(t, "/dev/loop1", resizedDevice, test.desc)
+			assert.Equal(t, stagingPath, resizedPath, test.desc)
+		} else {
+			assert.Empty(t, resizedDevice, test.desc)
+		}
 	}
 }
 
@@ -392,6 +515,22 @@ func TestNodePublishVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, "Staging target not provided"),
 			},
 		},
+		{
+			desc: "[Error] Volume operation in progress",
+			setup: func(d *Driver) {
+				d.volumeLocks.TryAcquire(fmt.Sprintf("%s-%s", "vol_1", targetTest))
+			},
+			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
+			},
+			cleanup: func(d *Driver) {
+				d.volumeLocks.ForceRelease(fmt.Sprintf("%s-%s", "vol_1", targetTest))
+			},
+		},
 		{
 			desc: "[Error] Not a directory",
 			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
@@ -596,6 +735,19 @@ func TestNodeUnpublishVolume(t *testing.T) {
 				DefaultError: status.Error(codes.InvalidArgument, "Target path missing in request"),
 			},
 		},
+		{
+			desc: "[Error] Volume operation in progress",
+			setup: func(d *Driver) {
+				d.volumeLocks.TryAcquire(fmt.Sprintf("%s-%s", "vol_1", targetFile))
+			},
+			req: &csi.NodeUnpublishVolumeRequest{TargetPath: targetFile, VolumeId: "vol_1"},
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
+			},
+			cleanup: func(d *Driver) {
+				d.volumeLocks.ForceRelease(fmt.Sprintf("%s-%s", "vol_1", targetFile))
+			},
+		},
 		{
 			desc:        "[Success] Valid request",
 			req:         &csi.NodeUnpublishVolumeRequest{TargetPath: targetFile, VolumeId: "vol_1"},
@@ -669,7 +821,7 @@ func TestNodeUnstageVolume(t *testing.T) {
 				DefaultError: status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_1")),
 			},
 			cleanup: func(d *Driver) {
-				d.volumeLocks.Release(fmt.Sprintf("%s-%s", "vol_1", targetFile))
+				d.volumeLocks.ForceRelease(fmt.Sprintf("%s-%s", "vol_1", targetFile))
 			},
 		},
 		{
@@ -830,8 +982,23 @@ func TestNodeGetVolumeStats(t *testing.T) {
 		}
 	}
 
+	// stats are collected with shared lock, but not during publish or unpublish
+	lockKey := fmt.Sprintf("%s-%s", "vol_2", fakePath)
+	token, ok := d.volumeLocks.AcquireShared(context.Background(), lockKey, "NodeGetVolumeStats")
+	assert.True(t, ok)
+	_, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: fakePath, VolumeId: "vol_2"})
+	assert.NoError(t, err)
+	d.volumeLocks.Release(lockKey, token)
+
+	token, ok = d.volumeLocks.TryAcquire(lockKey)
+	assert.True(t, ok)
+	d.volStatsCache.Delete("vol_2")
+	_, err = d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: fakePath, VolumeId: "vol_2"})
+	assert.Equal(t, status.Error(codes.Aborted, fmt.Sprintf(volumeOperationAlreadyExistsFmt, "vol_2")), err)
+	d.volumeLocks.Release(lockKey, token)
+
 	// Clean up
-	err := os.RemoveAll(fakePath)
+	err = os.RemoveAll(fakePath)
 	assert.NoError(t, err)
 }
 
@@ -0,0 +1,211 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// PVC annotations which override storage class settings of a volume
+const (
+	// comma separated mount options, e.g. cache=none,actimeo=30,nobrl
+	pvcMountOptionsAnnotation = "smb.csi.k8s.io/mount-options"
+	// sub directory of the volume, it replaces the last element of subDir in storage class
+	pvcSubDirAnnotation = "smb.csi.k8s.io/subdir"
+)
+
+// mountOptionRule is the allowed values of a mount option which could be overridden by PVC annotation
+type mountOptionRule struct {
+	// allowed values, any value (or no value) is allowed if both values and range are empty
+	values []string
+	// allowed range of integer value
+	isRange  bool
+	min, max int64
+}
+
+func (r *mountOptionRule) allows(value string) bool {
+	if r.isRange {
+		v, err := strconv.ParseInt(value, 10, 64)
+		return err == nil && v >= r.min && v <= r.max
+	}
+	if len(r.values) == 0 {
+		return true
+	}
+	for _, v := range r.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountOptionOverrides parses the mount options which PVC could override, e.g.
+// cache=none|strict|loose,actimeo=0-3600,uid,nobrl: cache could be one of the listed values, actimeo
+// should be an integer in the range, uid could be any value and nobrl could be set without value.
+func parseMountOptionOverrides(overrides string) (map[string]*mountOptionRule, error) {
+	rules := map[string]*mountOptionRule{}
+	for _, entry := range strings.Split(overrides, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(entry, "=")
+		rule := &mountOptionRule{}
+		if hasValue {
+			if lo, hi, isRange := strings.Cut(value, "-"); isRange {
+				var err1, err2 error
+				rule.isRange = true
+				rule.min, err1 = strconv.ParseInt(lo, 10, 64)
+				rule.max, err2 = strconv.ParseInt(hi, 10, 64)
+				if err1 != nil || err2 != nil || rule.min > rule.max {
+					return nil, fmt.Errorf("invalid range %q of mount option %s in %s", value, key, pvcMountOptionOverridesField)
+				}
+			} else {
+				rule.values = strings.Split(value, "|")
+			}
+		}
+		rules[key] = rule
+	}
+	return rules, nil
+}
+
+// validateMountOptionOverrides checks mount options against the allowed overrides and returns the mount options
+func validateMountOptionOverrides(mountOptions, overrides string) ([]string, error) {
+	rules, err := parseMountOptionOverrides(overrides)
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	for _, option := range strings.Split(mountOptions, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		rule, ok := rules[key]
+		if !ok {
+			return nil, fmt.Errorf("mount option %s is not allowed to be overridden, allowed mount options: %s", key, strings.Join(sortedKeys(rules), ","))
+		}
+		if !rule.allows(value) {
+			return nil, fmt.Errorf("value %q of mount option %s is not allowed by %s", value, key, overrides)
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// mergeMountOptions replaces the mount options in mountFlags with the options of the same key in overrides,
+// options in overrides which are not in mountFlags are appended
+func mergeMountOptions(mountFlags, overrides []string) []string {
+	overridden := map[string]bool{}
+	for _, option := range overrides {
+		key, _, _ := strings.Cut(option, "=")
+		overridden[key] = true
+	}
+	merged := make([]string, 0, len(mountFlags)+len(overrides))
+	for _, flag := range mountFlags {
+		key, _, _ := strings.Cut(flag, "=")
+		if !overridden[key] {
+			merged = append(merged, flag)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// applyPVCOverrides sets the mount options and subDir in PVC annotations into params if they are allowed by
+// storage class, the mount options are recorded in volume context and merged into mount flags on node
+func applyPVCOverrides(params map[string]string, pvc *v1.PersistentVolumeClaim) error {
+	if pvc == nil {
+		return nil
+	}
+	var overrides, scSubDir string
+	var allowSubDir bool
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case pvcMountOptionOverridesField:
+			overrides = v
+		case pvcSubDirOverrideField:
+			allowSubDir = strings.EqualFold(v, trueValue)
+		case subDirField:
+			scSubDir = v
+		}
+	}
+
+	if mountOptions, ok := pvc.Annotations[pvcMountOptionsAnnotation]; ok {
+		if overrides == "" {
+			return fmt.Errorf("annotation %s of PVC %s/%s is not allowed, %s is not set in storage class", pvcMountOptionsAnnotation, pvc.Namespace, pvc.Name, pvcMountOptionOverridesField)
+		}
+		options, err := validateMountOptionOverrides(mountOptions, overrides)
+		if err != nil {
+			return fmt.Errorf("invalid annotation %s of PVC %s/%s: %v", pvcMountOptionsAnnotation, pvc.Namespace, pvc.Name, err)
+		}
+		klog.V(2).Infof("PVC %s/%s overrides mount options: %v", pvc.Namespace, pvc.Name, options)
+		setKeyValueInMap(params, pvcMountOptionsField, strings.Join(options, ","))
+	}
+	if subDir, ok := pvc.Annotations[pvcSubDirAnnotation]; ok {
+		if !allowSubDir {
+			return fmt.Errorf("annotation %s of PVC %s/%s is not allowed, %s is not set in storage class", pvcSubDirAnnotation, pvc.Namespace, pvc.Name, pvcSubDirOverrideField)
+		}
+		relative := strings.Trim(strings.ReplaceAll(subDir, `\`, "/"), "/")
+		if relative == "" || validatePath(relative) != nil {
+			return fmt.Errorf("invalid annotation %s %q of PVC %s/%s", pvcSubDirAnnotation, subDir, pvc.Namespace, pvc.Name)
+		}
+		// the override stays under the parent directory of subDir in storage class, so that a PVC could not
+		// take the directory of another tenant
+		subDir = getSubDirOverrideParent(scSubDir) + "/" + relative
+		klog.V(2).Infof("PVC %s/%s overrides subDir: %s", pvc.Namespace, pvc.Name, subDir)
+		setKeyValueInMap(params, subDirField, subDir)
+	}
+	return nil
+}
+
+// getSubDirOverrideParent returns the parent directory of subDir in storage class, which a subDir in PVC annotation
+// is confined to, or ${pvc.metadata.namespace} if subDir has no parent directory. '/' in template variables,
+// e.g. in the key of ${pvc.metadata.annotations.example.com/dir}, is not a separator.
+func getSubDirOverrideParent(subDir string) string {
+	subDir = strings.Trim(strings.ReplaceAll(subDir, `\`, "/"), "/")
+	last, inVariable := -1, false
+	for i := 0; i < len(subDir); i++ {
+		switch {
+		case strings.HasPrefix(subDir[i:], "${"):
+			inVariable = true
+		case subDir[i] == '}':
+			inVariable = false
+		case subDir[i] == '/' && !inVariable:
+			last = i
+		}
+	}
+	if parent := strings.TrimRight(subDir[:max(last, 0)], "/"); parent != "" {
+		return parent
+	}
+	return pvcNamespaceMetadata
+}
+
+func sortedKeys(m map[string]*mountOptionRule) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateMountOptionOverrides(t *testing.T) {
+	overrides := "cache=none|strict|loose, actimeo=0-3600, uid, nobrl"
+	tests := []struct {
+		mountOptions string
+		expected     []string
+		expectErr    bool
+	}{
+		{
+			mountOptions: "cache=none,actimeo=30,uid=1000,nobrl",
+			expected:     []string{"cache=none", "actimeo=30", "uid=1000", "nobrl"},
+		},
+		{
+			mountOptions: " actimeo=0 ,",
+			expected:     []string{"actimeo=0"},
+		},
+		{
+			mountOptions: "cache=fast",
+			expectErr:    true,
+		},
+		{
+			mountOptions: "actimeo=3601",
+			expectErr:    true,
+		},
+		{
+			mountOptions: "actimeo=abc",
+			expectErr:    true,
+		},
+		{
+			mountOptions: "gid=0",
+			expectErr:    true,
+		},
+		{
+			mountOptions: "password=secret",
+			expectErr:    true,
+		},
+	}
+	for _, test := range tests {
+		options, err := validateMountOptionOverrides(test.mountOptions, overrides)
+		assert.Equal(t, test.expectErr, err != nil, test.mountOptions)
+		assert.Equal(t, test.expected, options, test.mountOptions)
+	}
+
+	_, err := parseMountOptionOverrides("actimeo=10-1")
+	assert.Error(t, err)
+	_, err = validateMountOptionOverrides("nobrl", "")
+	assert.Error(t, err)
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	merged := mergeMountOptions([]string{"dir_mode=0777", "cache=strict", "actimeo=1"}, []string{"cache=none", "nobrl"})
+	assert.Equal(t, []string{"dir_mode=0777", "actimeo=1", "cache=none", "nobrl"}, merged)
+	assert.Equal(t, []string{"nobrl"}, mergeMountOptions(nil, []string{"nobrl"}))
+}
+
+func TestApplyPVCOverrides(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pvc-name",
+			Namespace: "pvc-namespace",
+			Annotations: map[string]string{
+				pvcMountOptionsAnnotation: "cache=none,actimeo=30",
+				pvcSubDirAnnotation:       "data/app1",
+			},
+		},
+	}
+	params := map[string]string{
+		sourceField:               "//smb-server/share",
+		"subDir":                  "${pv.metadata.name}",
+		"pvcMountOptionOverrides": "cache=none|strict,actimeo=0-60",
+		"pvcSubDirOverride":       "true",
+	}
+	assert.NoError(t, applyPVCOverrides(params, pvc))
+	assert.Equal(t, "cache=none,actimeo=30", params[pvcMountOptionsField])
+	// subDir without parent directory is confined to the PVC namespace
+	assert.Equal(t, "${pvc.metadata.namespace}/data/app1", params["subDir"])
+
+	// overrides are not allowed by storage class
+	assert.NoError(t, applyPVCOverrides(map[string]string{}, nil))
+	assert.Error(t, applyPVCOverrides(map[string]string{"pvcSubDirOverride": "true"}, pvc))
+	assert.Error(t, applyPVCOverrides(map[string]string{"pvcMountOptionOverrides": "cache=strict"}, pvc))
+
+	for _, subDir := range []string{"../other", "data/../../other", "", "/", `\\`} {
+		pvc.Annotations = map[string]string{pvcSubDirAnnotation: subDir}
+		assert.Error(t, applyPVCOverrides(map[string]string{"pvcSubDirOverride": "true"}, pvc), subDir)
+	}
+
+	pvc.Annotations = map[string]string{pvcSubDirAnnotation: "/data/app1/"}
+	params = map[string]string{"subDir": "teams/${pvc.metadata.namespace}/${pvc.metadata.name}", "pvcSubDirOverride": "true"}
+	assert.NoError(t, applyPVCOverrides(params, pvc))
+	assert.Equal(t, "teams/${pvc.metadata.namespace}/data/app1", params["subDir"])
+}
+
+func TestGetSubDirOverrideParent(t *testing.T) {
+	tests := []struct {
+		subDir   string
+		expected string
+	}{
+		{subDir: "", expected: pvcNamespaceMetadata},
+		{subDir: "${pv.metadata.name}", expected: pvcNamespaceMetadata},
+		{subDir: "/shared/", expected: pvcNamespaceMetadata},
+		{subDir: "teams/${pvc.metadata.namespace}/${pvc.metadata.name}", expected: "teams/${pvc.metadata.namespace}"},
+		{subDir: `teams\${pvc.metadata.name}`, expected: "teams"},
+		{subDir: "${pvc.metadata.annotations.example.com/team}/${pvc.metadata.name}", expected: "${pvc.metadata.annotations.example.com/team}"},
+		{subDir: "${pvc.metadata.annotations.example.com/dir}", expected: pvcNamespaceMetadata},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getSubDirOverrideParent(test.subDir), test.subDir)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// max depth of nested archive names listed by listArchives, archive name is nested if subDir contains '/'
+const maxArchiveListDepth = 4
+
+// archiveEntry is an archive listed from the metadata stored alongside it
+type archiveEntry struct {
+	// archive name relative to the archive directory, it could be used as restoreFromArchive
+	Name string `json:"name"`
+	archiveMetadata
+}
+
+// validateArchiveName checks that name is an archive under the archive directory, e.g. archived-pvc-4729891a
+func validateArchiveName(name string) error {
+	if err := validatePath(name); err != nil {
+		return fmt.Errorf("invalid %s %q: %v", restoreFromArchiveField, name, err)
+	}
+	if !strings.HasPrefix(name, archivePrefix) || strings.HasSuffix(name, archiveMetadataSuffix) {
+		return fmt.Errorf("invalid %s %q, it should be an archive name with prefix %s", restoreFromArchiveField, name, archivePrefix)
+	}
+	return nil
+}
+
+// restoreArchive restores the archive vol.restoreFromArchive in the archive location of params into the
+// directory of vol, which is mounted at its internal mount path. The archive is renamed if it's on the
+// share of vol, otherwise it's copied and kept in the archive share.
+func (d *Driver) restoreArchive(ctx context.Context, vol *smbVolume, params, secrets map[string]string) error {
+	location, err := parseArchiveLocation(params)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if location == nil {
+		location = &archiveLocation{}
+	}
+	volumePath := getInternalVolumePath(d.workingMountDir, vol)
+	var namespace string
+	var allowCrossNamespace bool
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case pvcNamespaceKey:
+			namespace = v
+		case crossNamespaceRestoreField:
+			allowCrossNamespace = strings.EqualFold(v, trueValue)
+		}
+	}
+
+	if location.source == "" {
+		release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpMkdir)
+		if err != nil {
+			return err
+		}
+		defer release()
+		archivePath := filepath.Join(getInternalMountPath(d.workingMountDir, vol), location.dir, vol.restoreFromArchive)
+		if !allowCrossNamespace {
+			if err := checkArchiveNamespace(archivePath, namespace); err != nil {
+				return err
+			}
+		}
+		return restoreByRename(archivePath, volumePath)
+	}
+
+	archiveSecrets := secrets
+	if location.secretName != "" {
+		if archiveSecrets, err = d.getSecretData(ctx, location.secretName, location.secretNamespace); err != nil {
+			return err
+		}
+	}
+	hash := sha256.Sum256([]byte(vol.id))
+	archiveVol := &smbVolume{
+		id:     "restore#" + vol.id,
+		source: location.source,
+		uuid:   "restore-" + hex.EncodeToString(hash[:8]),
+	}
+	if err := d.internalMount(ctx, archiveVol, getInternalMountVolCap(getMountOptions(archiveSecrets)), archiveSecrets); err != nil {
+		return status.Errorf(codes.Internal, "failed to mount archive share %s: %v", location.source, err)
+	}
+	defer func() {
+		if err := d.internalUnmount(ctx, archiveVol); err != nil {
+			klog.Warningf("failed to unmount archive share %s: %v", location.source, err)
+		}
+	}()
+
+	release, err := d.controllerOpLimiter.Acquire(ctx, vol.source, serverOpCopy)
+	if err != nil {
+		return err
+	}
+	defer release()
+	serverSide := runtime.GOOS == "linux" && getServerFromSource(vol.source) == getServerFromSource(location.source)
+	archivePath := filepath.Join(getInternalMountPath(d.workingMountDir, archiveVol), location.dir, vol.restoreFromArchive)
+	if !allowCrossNamespace {
+		if err := checkArchiveNamespace(archivePath, namespace); err != nil {
+			return err
+		}
+	}
+	return restoreByCopy(archivePath, volumePath, serverSide)
+}
+
+// checkArchiveNamespace returns PermissionDenied if the archive at archivePath was not deleted from a PVC in
+// namespace, an archive without metadata could not be checked. It's a no-op if the archive does not exist,
+// e.g. it has been restored by a previous CreateVolume.
+func checkArchiveNamespace(archivePath, namespace string) error {
+	if _, err := os.Lstat(archivePath); os.IsNotExist(err) {
+		return nil
+	}
+	name := filepath.Base(archivePath)
+	if namespace == "" {
+		return status.Errorf(codes.PermissionDenied, "could not restore archive %s: namespace of PVC is unknown, enable --extra-create-metadata in csi-provisioner", name)
+	}
+	data, err := os.ReadFile(archivePath + archiveMetadataSuffix)
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.PermissionDenied, "could not restore archive %s to namespace %s: archive has no metadata, set %s in storage class to restore it", name, namespace, crossNamespaceRestoreField)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to read metadata of archive %s: %v", name, err)
+	}
+	var metadata archiveMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return status.Errorf(codes.Internal, "failed to parse metadata of archive %s: %v", name, err)
+	}
+	if metadata.PVCNamespace != namespace {
+		return status.Errorf(codes.PermissionDenied, "could not restore archive %s to namespace %s: it's archived from namespace %q, set %s in storage class to restore it", name, namespace, metadata.PVCNamespace, crossNamespaceRestoreField)
+	}
+	return nil
+}
+
+// restoreByRename renames archivePath to volumePath on the same share and removes the archive metadata.
+// It succeeds if the archive is gone and volumePath exists, so that a retried CreateVolume does not fail.
+func restoreByRename(archivePath, volumePath string) error {
+	if _, err := os.Lstat(archivePath); os.IsNotExist(err) {
+		if _, err := os.Lstat(volumePath); err == nil {
+			klog.V(2).Infof("archive %s does not exist and %s exists, it may have been restored", archivePath, volumePath)
+			return nil
+		}
+		return status.Errorf(codes.NotFound, "archive %s does not exist", archivePath)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to stat archive %s: %v", archivePath, err)
+	}
+	// volume directory may have been created by a previous CreateVolume, it's replaced only if it's empty
+	if err := os.Remove(volumePath); err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.FailedPrecondition, "could not restore archive to %s: %v", volumePath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(volumePath), 0777); err != nil {
+		return status.Errorf(codes.Internal, "create parent directory of %s failed with %v", volumePath, err)
+	}
+	klog.V(2).Infof("restoring archive %s --> %s", archivePath, volumePath)
+	if err := os.Rename(archivePath, volumePath); err != nil {
+		return status.Errorf(codes.Internal, "restore archive %s failed with %v", archivePath, err)
+	}
+	if err := os.Remove(archivePath + archiveMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("failed to remove metadata of restored archive %s: %v", archivePath, err)
+	}
+	return nil
+}
+
+// restoreByCopy copies archivePath on the archive share to volumePath, the archive is kept
+func restoreByCopy(archivePath, volumePath string, serverSide bool) error {
+	if _, err := os.Lstat(archivePath); os.IsNotExist(err) {
+		return status.Errorf(codes.NotFound, "archive %s does not exist", archivePath)
+	}
+	klog.V(2).Infof("restoring archive %s --> %s", archivePath, volumePath)
+	start := time.Now()
+	stats, err := copyDirectory(archivePath, volumePath, serverSide)
+	if err != nil {
+		return status.Errorf(codes.Internal, "restore archive %s failed with %v", archivePath, err)
+	}
+	klog.V(2).Infof("restored %s --> %s in %v, method: %s", archivePath, volumePath, time.Since(start), stats.method())
+	return nil
+}
+
+// listArchives returns the archives with metadata under archiveRoot sorted by name, archives without
+// metadata, i.e. archived to the share of the volume without archive retention or location, are not listed
+func listArchives(archiveRoot string) ([]archiveEntry, error) {
+	var archives []archiveEntry
+	if err := listArchivesInDir(archiveRoot, "", 0, &archives); err != nil {
+		return nil, err
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Name < archives[j].Name })
+	return archives, nil
+}
+
+func listArchivesInDir(archiveRoot, rel string, depth int, archives *[]archiveEntry) error {
+	entries, err := os.ReadDir(filepath.Join(archiveRoot, rel))
+	if err != nil {
+		if os.IsNotExist(err) && depth == 0 {
+			return nil
+		}
+		return err
+	}
+	metadataFiles := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), archiveMetadataSuffix) {
+			metadataFiles[strings.TrimSuffix(entry.Name(), archiveMetadataSuffix)] = true
+		}
+	}
+	for _, entry := range entries {
+		name := path.Join(rel, entry.Name())
+		if depth == 0 && !strings.HasPrefix(name, archivePrefix) {
+			continue
+		}
+		if entry.IsDir() {
+			// archive directories are not walked, only the parent directories of nested archive names
+			if !metadataFiles[entry.Name()] && depth+1 < maxArchiveListDepth {
+				if err := listArchivesInDir(archiveRoot, name, depth+1, archives); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, archiveMetadataSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(archiveRoot, name))
+		if err != nil {
+			return err
+		}
+		a := archiveEntry{Name: strings.TrimSuffix(name, archiveMetadataSuffix)}
+		if err := json.Unmarshal(data, &a.archiveMetadata); err != nil {
+			klog.Warningf("skip archive %s with invalid metadata: %v", a.Name, err)
+			continue
+		}
+		*archives = append(*archives, a)
+	}
+	return nil
+}
+
+// listStorageClassArchives mounts the archive shares of storage class and lists their archives
+func (d *Driver) listStorageClassArchives(ctx context.Context, scName string) ([]archiveEntry, error) {
+	if d.kubeClient == nil {
+		return nil, fmt.Errorf("KubeClient is nil")
+	}
+	sc, err := d.kubeClient.StorageV1().StorageClasses().Get(ctx, scName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if sc.Provisioner != d.Name {
+		return nil, fmt.Errorf("storage class %s is not provisioned by %s", scName, d.Name)
+	}
+	targets, err := getArchiveShares(sc.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("could not list archives of storage class %s: %v", scName, err)
+	}
+	var archives []archiveEntry
+	for _, target := range targets {
+		entries, err := d.listShareArchives(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, entries...)
+	}
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].Name < archives[j].Name })
+	return archives, nil
+}
+
+// listShareArchives mounts the archive share of target and lists its archives
+func (d *Driver) listShareArchives(ctx context.Context, target *archiveGCTarget) ([]archiveEntry, error) {
+	archiveRoot, unmount, err := d.mountArchiveShare(ctx, target, "list")
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+	return listArchives(archiveRoot)
+}
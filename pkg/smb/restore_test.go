@@ -0,0 +1,166 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateArchiveName(t *testing.T) {
+	for _, name := range []string{"archived-pvc-1", "archived-a/pvc-1", "archived-pvc-1_ns1_data_20261018T102030Z"} {
+		assert.NoError(t, validateArchiveName(name), name)
+	}
+	for _, name := range []string{"pvc-1", "archived-a/../../pvc-1", "archived-pvc-1.json", ""} {
+		assert.Error(t, validateArchiveName(name), name)
+	}
+}
+
+func TestRestoreByRename(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "trash", "archived-pvc-1")
+	volumePath := filepath.Join(root, "a", "pvc-2")
+	assert.NoError(t, os.MkdirAll(archivePath, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "file"), []byte("data"), 0644))
+	assert.NoError(t, os.WriteFile(archivePath+archiveMetadataSuffix, []byte("{}"), 0644))
+
+	assert.NoError(t, restoreByRename(archivePath, volumePath))
+	data, err := os.ReadFile(filepath.Join(volumePath, "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	_, err = os.Stat(archivePath + archiveMetadataSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	// retry after the archive is restored
+	assert.NoError(t, restoreByRename(archivePath, volumePath))
+
+	err = restoreByRename(archivePath, filepath.Join(root, "pvc-3"))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// volume directory with data is not replaced
+	assert.NoError(t, os.MkdirAll(archivePath, 0755))
+	err = restoreByRename(archivePath, volumePath)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestRestoreByCopy(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archived-pvc-1")
+	volumePath := filepath.Join(t.TempDir(), "pvc-2")
+	assert.NoError(t, os.MkdirAll(filepath.Join(archivePath, "dir"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(archivePath, "dir", "file"), []byte("data"), 0644))
+
+	assert.NoError(t, restoreByCopy(archivePath, volumePath, false))
+	data, err := os.ReadFile(filepath.Join(volumePath, "dir", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	// archive is kept
+	_, err = os.Stat(filepath.Join(archivePath, "dir", "file"))
+	assert.NoError(t, err)
+
+	err = restoreByCopy(filepath.Join(t.TempDir(), "archived-pvc-3"), volumePath, false)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestCheckArchiveNamespace(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "archived-pvc-1")
+	assert.NoError(t, os.MkdirAll(archivePath, 0755))
+
+	err := checkArchiveNamespace(archivePath, "ns1")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "archive without metadata")
+
+	assert.NoError(t, writeArchiveMetadata(archivePath, &archiveMetadata{VolumeID: "vol-1", PVCNamespace: "ns1"}))
+	assert.NoError(t, checkArchiveNamespace(archivePath, "ns1"))
+	err = checkArchiveNamespace(archivePath, "ns2")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = checkArchiveNamespace(archivePath, "")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// archive restored by a previous CreateVolume
+	assert.NoError(t, checkArchiveNamespace(filepath.Join(root, "archived-pvc-2"), "ns2"))
+}
+
+func TestListArchives(t *testing.T) {
+	root := t.TempDir()
+	archives, err := listArchives(filepath.Join(root, "not-exist"))
+	assert.NoError(t, err)
+	assert.Empty(t, archives)
+
+	deletedAt := time.Date(2026, 10, 18, 10, 20, 30, 0, time.UTC)
+	writeArchive := func(name string, metadata *archiveMetadata) {
+		archivePath := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Join(archivePath, "data"), 0755))
+		assert.NoError(t, writeArchiveMetadata(archivePath, metadata))
+	}
+	writeArchive("archived-pvc-1_ns1_data_20261018T102030Z", &archiveMetadata{VolumeID: "vol-1", PVCNamespace: "ns1", PVCName: "data", DeletedAt: deletedAt})
+	writeArchive("archived-a/pvc-2", &archiveMetadata{VolumeID: "vol-2", SubDir: "a/pvc-2", DeletedAt: deletedAt})
+	// archive without metadata, partial copy and other directories are not listed
+	writeArchive("archived-pvc-3", nil)
+	writeArchive(".archiving-0123456789abcdef", nil)
+	writeArchive("pvc-4", nil)
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "archived-invalid.json"), []byte("invalid"), 0644))
+
+	archives, err = listArchives(root)
+	assert.NoError(t, err)
+	assert.Equal(t, []archiveEntry{
+		{Name: "archived-a/pvc-2", archiveMetadata: archiveMetadata{VolumeID: "vol-2", SubDir: "a/pvc-2", DeletedAt: deletedAt}},
+		{Name: "archived-pvc-1_ns1_data_20261018T102030Z", archiveMetadata: archiveMetadata{VolumeID: "vol-1", PVCNamespace: "ns1", PVCName: "data", DeletedAt: deletedAt}},
+	}, archives)
+
+	data, err := json.Marshal(archives[1])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"archived-pvc-1_ns1_data_20261018T102030Z","volumeID":"vol-1","source":"","subDir":"",
+		"pvcNamespace":"ns1","pvcName":"data","deletedAt":"2026-10-18T10:20:30Z"}`, string(data))
+}
+
+func TestDebugArchives(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "no-secret"}, Provisioner: d.Name, Parameters: map[string]string{"source": "//smb-server/share"}},
+	)
+	tests := []struct {
+		desc         string
+		method       string
+		url          string
+		disableAdmin bool
+		expectedCode int
+	}{
+		{desc: "admin actions are disabled", method: http.MethodGet, url: "/debug/archives?storageclass=no-secret", disableAdmin: true, expectedCode: http.StatusForbidden},
+		{desc: "method not allowed", method: http.MethodPost, url: "/debug/archives?storageclass=no-secret", expectedCode: http.StatusMethodNotAllowed},
+		{desc: "storage class is required", method: http.MethodGet, url: "/debug/archives", expectedCode: http.StatusBadRequest},
+		{desc: "storage class not found", method: http.MethodGet, url: "/debug/archives?storageclass=not-found", expectedCode: http.StatusInternalServerError},
+		{desc: "no secret to mount archive share", method: http.MethodGet, url: "/debug/archives?storageclass=no-secret", expectedCode: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		d.DebugHandler(!test.disableAdmin).ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil).WithContext(context.Background()))
+		assert.Equal(t, test.expectedCode, w.Code, test.desc)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// security descriptor control flags
+const (
+	seDaclPresent       = 0x0004
+	seDaclAutoInherited = 0x0400
+	seDaclProtected     = 0x1000
+	seSelfRelative      = 0x8000
+)
+
+var (
+	// well-known SID aliases in SDDL
+	sddlSIDAliases = map[string]string{
+		"WD": "S-1-1-0",
+		"CO": "S-1-3-0",
+		"CG": "S-1-3-1",
+		"OW": "S-1-3-4",
+		"NU": "S-1-5-2",
+		"AN": "S-1-5-7",
+		"AU": "S-1-5-11",
+		"SY": "S-1-5-18",
+		"BA": "S-1-5-32-544",
+		"BU": "S-1-5-32-545",
+		"BG": "S-1-5-32-546",
+	}
+	sddlACETypes = map[string]byte{
+		"A": 0x00,
+		"D": 0x01,
+	}
+	sddlACEFlags = map[string]byte{
+		"OI": 0x01,
+		"CI": 0x02,
+		"NP": 0x04,
+		"IO": 0x08,
+		"ID": 0x10,
+	}
+	sddlAccessRights = map[string]uint32{
+		"GA": 0x10000000,
+		"GX": 0x20000000,
+		"GW": 0x40000000,
+		"GR": 0x80000000,
+		"FA": 0x001F01FF,
+		"FR": 0x00120089,
+		"FW": 0x00120116,
+		"FX": 0x001200A0,
+		"SD": 0x00010000,
+		"RC": 0x00020000,
+		"WD": 0x00040000,
+		"WO": 0x00080000,
+	}
+	sddlComponentRegex = regexp.MustCompile(`([OGDS]):`)
+	sidRegex           = regexp.MustCompile(`^S-1-\d+(-\d+)*$`)
+)
+
+// securityDescriptor is a NT security descriptor in self-relative binary format,
+// which could be set on a file of cifs mount with system.cifs_acl or system.cifs_ntsd xattr
+type securityDescriptor struct {
+	data []byte
+	// whether owner or group is set, otherwise only DACL is set
+	hasOwner bool
+}
+
+// parseSecurityDescriptor parses a security descriptor in SDDL, e.g. O:BAG:BAD:P(A;OICI;FA;;;SY),
+// or an ACE list which only sets DACL, e.g. (A;OICI;FA;;;S-1-5-21-1-2-3-1001)(A;OICI;FR;;;WD)
+func parseSecurityDescriptor(sddl string) (*securityDescriptor, error) {
+	sddl = strings.Join(strings.Fields(sddl), "")
+	if strings.HasPrefix(sddl, "(") {
+		sddl = "D:" + sddl
+	}
+	matches := sddlComponentRegex.FindAllStringSubmatchIndex(sddl, -1)
+	if len(matches) == 0 || matches[0][0] != 0 {
+		return nil, fmt.Errorf("invalid SDDL %q", sddl)
+	}
+
+	var owner, group, dacl []byte
+	var control uint16 = seSelfRelative
+	for i, m := range matches {
+		end := len(sddl)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		value := sddl[m[1]:end]
+		var err error
+		switch component := sddl[m[2]:m[3]]; component {
+		case "O":
+			owner, err = encodeSID(value)
+		case "G":
+			group, err = encodeSID(value)
+		case "D":
+			var flags uint16
+			dacl, flags, err = encodeDACL(value)
+			control |= seDaclPresent | flags
+		default:
+			err = fmt.Errorf("%s: is not supported", component)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SDDL %q: %v", sddl, err)
+		}
+	}
+	if dacl == nil {
+		return nil, fmt.Errorf("invalid SDDL %q: DACL is required", sddl)
+	}
+
+	// header: revision, sbz1, control, offsets of owner, group, SACL and DACL
+	data := make([]byte, 20)
+	data[0] = 1
+	binary.LittleEndian.PutUint16(data[2:], control)
+	if owner != nil {
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+		data = append(data, owner...)
+	}
+	if group != nil {
+		binary.LittleEndian.PutUint32(data[8:], uint32(len(data)))
+		data = append(data, group...)
+	}
+	binary.LittleEndian.PutUint32(data[16:], uint32(len(data)))
+	data = append(data, dacl...)
+	return &securityDescriptor{data: data, hasOwner: owner != nil || group != nil}, nil
+}
+
+// encodeSID encodes SID string or alias, e.g. S-1-5-32-544 or BA
+func encodeSID(sid string) ([]byte, error) {
+	if alias, ok := sddlSIDAliases[sid]; ok {
+		sid = alias
+	}
+	if !sidRegex.MatchString(sid) {
+		return nil, fmt.Errorf("invalid SID %q", sid)
+	}
+	parts := strings.Split(sid, "-")[2:]
+	authority, err := strconv.ParseUint(parts[0], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identifier authority of SID %q: %v", sid, err)
+	}
+	subAuthorities := parts[1:]
+	if len(subAuthorities) > 15 {
+		return nil, fmt.Errorf("too many sub authorities in SID %q", sid)
+	}
+	data := make([]byte, 8, 8+4*len(subAuthorities))
+	data[0] = 1
+	data[1] = byte(len(subAuthorities))
+	for i := 0; i < 6; i++ {
+		data[2+i] = byte(authority >> (8 * (5 - i)))
+	}
+	for _, s := range subAuthorities {
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sub authority of SID %q: %v", sid, err)
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(v))
+	}
+	return data, nil
+}
+
+// encodeDACL encodes DACL flags and ACEs, e.g. P(A;OICI;FA;;;SY), and returns the control flags of DACL
+func encodeDACL(value string) ([]byte, uint16, error) {
+	var control uint16
+	flags := value
+	if i := strings.Index(value, "("); i >= 0 {
+		flags = value[:i]
+		value = value[i:]
+	} else {
+		value = ""
+	}
+	for len(flags) > 0 {
+		switch {
+		case strings.HasPrefix(flags, "P"):
+			control |= seDaclProtected
+			flags = flags[1:]
+		case strings.HasPrefix(flags, "AI"), strings.HasPrefix(flags, "AR"):
+			if flags[:2] == "AI" {
+				control |= seDaclAutoInherited
+			}
+			flags = flags[2:]
+		default:
+			return nil, 0, fmt.Errorf("invalid DACL flags %q", flags)
+		}
+	}
+
+	var aces []byte
+	count := 0
+	for value != "" {
+		end := strings.Index(value, ")")
+		if !strings.HasPrefix(value, "(") || end < 0 {
+			return nil, 0, fmt.Errorf("invalid ACE list %q", value)
+		}
+		ace, err := encodeACE(value[1:end])
+		if err != nil {
+			return nil, 0, err
+		}
+		aces = append(aces, ace...)
+		count++
+		value = value[end+1:]
+	}
+
+	// ACL header: revision, sbz1, size, ACE count, sbz2
+	data := make([]byte, 8, 8+len(aces))
+	data[0] = 2
+	binary.LittleEndian.PutUint16(data[2:], uint16(8+len(aces)))
+	binary.LittleEndian.PutUint16(data[4:], uint16(count))
+	return append(data, aces...), control, nil
+}
+
+// encodeACE encodes ACE string type;flags;rights;object_guid;inherit_object_guid;sid
+func encodeACE(ace string) ([]byte, error) {
+	fields := strings.Split(ace, ";")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid ACE %q, expected format: type;flags;rights;;;sid", ace)
+	}
+	aceType, ok := sddlACETypes[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("ACE type %q is not supported in %q", fields[0], ace)
+	}
+	if fields[3] != "" || fields[4] != "" {
+		return nil, fmt.Errorf("object ACE is not supported in %q", ace)
+	}
+	var aceFlags byte
+	for f := fields[1]; f != ""; f = f[2:] {
+		flag, ok := sddlACEFlags[f[:min(2, len(f))]]
+		if !ok {
+			return nil, fmt.Errorf("ACE flags %q is not supported in %q", fields[1], ace)
+		}
+		aceFlags |= flag
+	}
+	mask, err := parseAccessRights(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("%v in %q", err, ace)
+	}
+	sid, err := encodeSID(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 8, 8+len(sid))
+	data[0] = aceType
+	data[1] = aceFlags
+	binary.LittleEndian.PutUint16(data[2:], uint16(8+len(sid)))
+	binary.LittleEndian.PutUint32(data[4:], mask)
+	return append(data, sid...), nil
+}
+
+// parseAccessRights parses access rights in hex, e.g. 0x1f01ff, or as a list of rights, e.g. FRFW
+func parseAccessRights(rights string) (uint32, error) {
+	if strings.HasPrefix(strings.ToLower(rights), "0x") {
+		v, err := strconv.ParseUint(rights[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid access rights %q", rights)
+		}
+		return uint32(v), nil
+	}
+	if rights == "" || len(rights)%2 != 0 {
+		return 0, fmt.Errorf("invalid access rights %q", rights)
+	}
+	var mask uint32
+	for r := rights; r != ""; r = r[2:] {
+		right, ok := sddlAccessRights[r[:2]]
+		if !ok {
+			return 0, fmt.Errorf("access right %q is not supported", r[:2])
+		}
+		mask |= right
+	}
+	return mask, nil
+}
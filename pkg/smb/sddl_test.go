@@ -0,0 +1,127 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecurityDescriptor(t *testing.T) {
+	// SID S-1-1-0 (WD)
+	everyone := []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	// SID S-1-5-32-544 (BA)
+	administrators := []byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 0x20, 0x02, 0, 0}
+
+	tests := []struct {
+		desc      string
+		sddl      string
+		expected  *securityDescriptor
+		expectErr bool
+	}{
+		{
+			desc: "DACL only",
+			sddl: "D:(A;;FA;;;WD)",
+			expected: &securityDescriptor{data: concatBytes(
+				// header: revision 1, control SE_SELF_RELATIVE|SE_DACL_PRESENT, DACL at offset 20
+				[]byte{1, 0, 0x04, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0},
+				// ACL: revision 2, size 28, 1 ACE
+				[]byte{2, 0, 28, 0, 1, 0, 0, 0},
+				// ACE: allowed, no flags, size 20, FILE_ALL_ACCESS
+				[]byte{0, 0, 20, 0, 0xff, 0x01, 0x1f, 0},
+				everyone,
+			)},
+		},
+		{
+			desc: "ACE list",
+			sddl: "(A;;FA;;;S-1-1-0)",
+			expected: &securityDescriptor{data: concatBytes(
+				[]byte{1, 0, 0x04, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0},
+				[]byte{2, 0, 28, 0, 1, 0, 0, 0},
+				[]byte{0, 0, 20, 0, 0xff, 0x01, 0x1f, 0},
+				everyone,
+			)},
+		},
+		{
+			desc: "owner, group and protected DACL",
+			sddl: "O:BAG:BAD:P(D;OICI;0x10000;;;WD)(A;OICIID;FRFW;;;BA)",
+			expected: &securityDescriptor{hasOwner: true, data: concatBytes(
+				// control SE_SELF_RELATIVE|SE_DACL_PROTECTED|SE_DACL_PRESENT, owner at 20, group at 36, DACL at 52
+				[]byte{1, 0, 0x04, 0x90, 20, 0, 0, 0, 36, 0, 0, 0, 0, 0, 0, 0, 52, 0, 0, 0},
+				administrators,
+				administrators,
+				// ACL: size 8+20+24, 2 ACEs
+				[]byte{2, 0, 52, 0, 2, 0, 0, 0},
+				// denied, OI|CI, DELETE
+				[]byte{1, 0x03, 20, 0, 0, 0, 1, 0},
+				everyone,
+				// allowed, OI|CI|ID, FILE_GENERIC_READ|FILE_GENERIC_WRITE
+				[]byte{0, 0x13, 24, 0, 0x9f, 0x01, 0x12, 0},
+				administrators,
+			)},
+		},
+		{
+			desc:      "SACL is not supported",
+			sddl:      "D:(A;;FA;;;WD)S:(AU;SA;FA;;;WD)",
+			expectErr: true,
+		},
+		{
+			desc:      "missing DACL",
+			sddl:      "O:BA",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid SID",
+			sddl:      "D:(A;;FA;;;XX)",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid access rights",
+			sddl:      "D:(A;;FZ;;;WD)",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid ACE flags",
+			sddl:      "D:(A;O;FA;;;WD)",
+			expectErr: true,
+		},
+		{
+			desc:      "object ACE",
+			sddl:      "D:(A;;FA;bf967aba-0de6-11d0-a285-00aa003049e2;;WD)",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid SDDL",
+			sddl:      "A;;FA;;;WD",
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		sd, err := parseSecurityDescriptor(test.sddl)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, test.expected, sd, test.desc)
+	}
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return data
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultSMBPort = "445"
+	// timeout of checking whether a server accepts connections before it's mounted
+	serverProbeTimeout = 5 * time.Second
+
+	serverMountedReason  = "SMBServerMounted"
+	serverFailoverReason = "SMBServerFailover"
+)
+
+// probeServer returns an error if address does not accept TCP connections, it's a variable to be replaced in tests
+var probeServer = func(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, serverProbeTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// parseAlternateServers parses comma separated servers which serve the same share as the server in source
+func parseAlternateServers(value string) ([]string, error) {
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		server = strings.TrimLeft(strings.TrimSpace(server), `/\`)
+		if server == "" {
+			continue
+		}
+		if strings.ContainsAny(server, `/\`) {
+			return nil, fmt.Errorf("alternate server %q should be a host name or IP address without share", server)
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// splitSource splits //server/share into server and /share
+func splitSource(source string) (string, string) {
+	trimmed := strings.TrimLeft(source, `/\`)
+	if i := strings.IndexAny(trimmed, `/\`); i >= 0 {
+		return trimmed[:i], trimmed[i:]
+	}
+	return trimmed, ""
+}
+
+// replaceServer returns source with its server replaced
+func replaceServer(source, server string) string {
+	prefix := "//"
+	if strings.HasPrefix(source, `\\`) {
+		prefix = `\\`
+	}
+	_, share := splitSource(source)
+	return prefix + server + share
+}
+
+// getSMBPort returns the port in mount options, or the default SMB port
+func getSMBPort(mountOptions []string) string {
+	for _, option := range mountOptions {
+		for _, o := range strings.Split(option, ",") {
+			if k, v, found := strings.Cut(strings.TrimSpace(o), "="); found && strings.EqualFold(k, "port") && v != "" {
+				return v
+			}
+		}
+	}
+	return defaultSMBPort
+}
+
+// serverSelector remembers the last server which was mounted successfully for a source with alternate servers
+type serverSelector struct {
+	mu sync.Mutex
+	// last working server <source, server>
+	lastServers map[string]string
+}
+
+func newServerSelector() *serverSelector {
+	return &serverSelector{lastServers: map[string]string{}}
+}
+
+// order returns the server of source and alternate servers in the order to try, the last working server is tried first
+func (s *serverSelector) order(source string, alternateServers []string) []string {
+	server, _ := splitSource(source)
+	servers := append([]string{server}, alternateServers...)
+	s.mu.Lock()
+	last, ok := s.lastServers[strings.ToLower(source)]
+	s.mu.Unlock()
+	if !ok {
+		return servers
+	}
+	ordered := []string{last}
+	for _, server := range servers {
+		if !strings.EqualFold(server, last) {
+			ordered = append(ordered, server)
+		}
+	}
+	if len(ordered) > len(servers) {
+		// the last working server is removed from alternate servers
+		return servers
+	}
+	return ordered
+}
+
+func (s *serverSelector) remember(source, server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastServers[strings.ToLower(source)] = server
+}
+
+// list returns a copy of the last working servers
+func (s *serverSelector) list() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	servers := make(map[string]string, len(s.lastServers))
+	for k, v := range s.lastServers {
+		servers[k] = v
+	}
+	return servers
+}
+
+// getVolumeAlternateServers returns alternateServers in the volume attributes of the PV of volumeID, so that the
+// internal mounts of an existing volume in controller are failed over like NodeStageVolume
+func (d *Driver) getVolumeAlternateServers(ctx context.Context, volumeID string) []string {
+	if d.kubeClient == nil {
+		return nil
+	}
+	pv, err := d.getPVByVolumeID(ctx, volumeID)
+	if err != nil {
+		klog.Warningf("failed to get PV of volume %s, mount without %s: %v", volumeID, alternateServersField, err)
+		return nil
+	}
+	if pv == nil {
+		return nil
+	}
+	for k, v := range pv.Spec.CSI.VolumeAttributes {
+		if strings.EqualFold(k, alternateServersField) {
+			servers, err := parseAlternateServers(v)
+			if err != nil {
+				klog.Warningf("ignore %s of volume %s: %v", alternateServersField, volumeID, err)
+				return nil
+			}
+			return servers
+		}
+	}
+	return nil
+}
+
+// recordServerEvent records which server is mounted for a volume, on the PV if pvName is known or on the node.
+// The event is only logged in controller if the PV is not found.
+func (d *Driver) recordServerEvent(ctx context.Context, pvName, eventType, reason, message string) {
+	if d.eventRecorder == nil {
+		return
+	}
+	var object runtime.Object
+	if d.NodeID != "" {
+		object = &v1.ObjectReference{Kind: "Node", Name: d.NodeID, UID: types.UID(d.NodeID)}
+	}
+	if pvName != "" && d.kubeClient != nil {
+		if pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{}); err == nil {
+			object = pv
+		} else {
+			klog.V(4).Infof("record event %s on node %s: failed to get PV %s: %v", reason, d.NodeID, pvName, err)
+		}
+	}
+	if object == nil {
+		klog.V(2).Infof("%s: %s", reason, message)
+		return
+	}
+	d.eventRecorder.Event(object, eventType, reason, message)
+}
+
+// mountWithFailover mounts source on targetPath from the server of source or one of alternate servers. Servers are
+// tried in order, a server which does not accept connections or fails to mount is skipped. A mount which is still
+// running after timeout is not failed over, since it's not safe to mount another server on the same target.
+func (d *Driver) mountWithFailover(ctx context.Context, source, subDir string, subDirReplaceMap map[string]string, alternateServers []string,
+	targetPath string, mountOptions, sensitiveMountOptions []string, volumeID, lockKey string, token lockToken, pvName string) (keepLockHeld bool, err error) {
+	primary, _ := splitSource(source)
+	port := getSMBPort(mountOptions)
+	var errs []string
+	for _, server := range d.serverSelector.order(source, alternateServers) {
+		mountSource, err := getSourceWithSubDir(replaceServer(source, server), subDir, subDirReplaceMap)
+		if err != nil {
+			return false, err
+		}
+		if err := probeServer(ctx, net.JoinHostPort(strings.Trim(server, "[]"), port)); err != nil {
+			klog.Warningf("volume(%s) skip server %s: %v", volumeID, server, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", server, err))
+			continue
+		}
+		keepLockHeld, err := d.mountWithTimeout(ctx, mountSource, targetPath, mountOptions, sensitiveMountOptions, volumeID, lockKey, token, time.Duration(mountTimeoutInSec)*time.Second)
+		if err != nil {
+			if keepLockHeld || status.Code(err) != codes.Internal {
+				return keepLockHeld, err
+			}
+			klog.Warningf("volume(%s) mount from server %s failed, try next server: %v", volumeID, server, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", server, err))
+			continue
+		}
+		d.serverSelector.remember(source, server)
+		klog.V(2).Infof("volume(%s) mount %q on %q succeeded", volumeID, mountSource, targetPath)
+		eventType, reason := v1.EventTypeNormal, serverMountedReason
+		message := fmt.Sprintf("volume %s is mounted from server %s on node %s", volumeID, server, d.NodeID)
+		if !strings.EqualFold(server, primary) {
+			eventType, reason = v1.EventTypeWarning, serverFailoverReason
+			message = fmt.Sprintf("%s instead of %s", message, primary)
+		}
+		if len(errs) > 0 {
+			message = fmt.Sprintf("%s, failed servers: %s", message, strings.Join(errs, "; "))
+		}
+		d.recordServerEvent(ctx, pvName, eventType, reason, message)
+		return false, nil
+	}
+	return false, status.Errorf(codes.Unavailable, "volume(%s) could not be mounted from any server of %s: %s", volumeID, source, strings.Join(errs, "; "))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseAlternateServers(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    []string
+		expectedErr bool
+	}{
+		{value: "", expected: nil},
+		{value: "nas-2, //nas-3,10.0.0.4", expected: []string{"nas-2", "nas-3", "10.0.0.4"}},
+		{value: "nas-2/share", expectedErr: true},
+	}
+	for _, test := range tests {
+		servers, err := parseAlternateServers(test.value)
+		if test.expectedErr {
+			assert.Error(t, err, test.value)
+			continue
+		}
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expected, servers, test.value)
+	}
+}
+
+func TestReplaceServer(t *testing.T) {
+	assert.Equal(t, "//nas-2/share/dir", replaceServer("//nas-1/share/dir", "nas-2"))
+	assert.Equal(t, `\\nas-2\share`, replaceServer(`\\nas-1\share`, "nas-2"))
+	assert.Equal(t, "445", getSMBPort([]string{"vers=3.0"}))
+	assert.Equal(t, "1445", getSMBPort([]string{"vers=3.0,port=1445"}))
+}
+
+func TestServerSelectorOrder(t *testing.T) {
+	s := newServerSelector()
+	source := "//nas-1/share"
+	assert.Equal(t, []string{"nas-1", "nas-2", "nas-3"}, s.order(source, []string{"nas-2", "nas-3"}))
+
+	s.remember(source, "nas-3")
+	assert.Equal(t, []string{"nas-3", "NAS-1", "nas-2"}, s.order("//NAS-1/share", []string{"nas-2", "nas-3"}))
+	// the last working server is no longer in the list
+	assert.Equal(t, []string{"nas-1", "nas-2"}, s.order(source, []string{"nas-2"}))
+	assert.Equal(t, map[string]string{source: "nas-3"}, s.list())
+}
+
+func TestNodeStageVolumeWithAlternateServers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip on windows")
+	}
+	d := NewFakeDriver()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	d.kubeClient = fake.NewSimpleClientset(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}})
+
+	probeServerOrig := probeServer
+	defer func() { probeServer = probeServerOrig }()
+	var probed []string
+	probeServer = func(_ context.Context, address string) error {
+		probed = append(probed, address)
+		if strings.HasPrefix(address, "nas-down") {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+
+	volCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	secrets := map[string]string{usernameField: "test", passwordField: "test"}
+	stage := func(volumeContext map[string]string) error {
+		_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "vol-1",
+			StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+			VolumeCapability:  volCap,
+			VolumeContext:     volumeContext,
+			Secrets:           secrets,
+		})
+		return err
+	}
+
+	// primary server fails to mount, nas-down does not accept connections
+	err = stage(map[string]string{sourceField: "//error_mount_sens/share", "alternateServers": "nas-down,nas-2", pvNameKey: "pv-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"error_mount_sens:445", "nas-down:445", "nas-2:445"}, probed)
+	event := <-recorder.Events
+	assert.Contains(t, event, v1.EventTypeWarning+" "+serverFailoverReason)
+	assert.Contains(t, event, "mounted from server nas-2")
+	assert.Contains(t, event, "nas-down: connection refused")
+
+	// the last working server is tried first
+	probed = nil
+	err = stage(map[string]string{sourceField: "//error_mount_sens/share", alternateServersField: "nas-down,nas-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nas-2:445"}, probed)
+	assert.Contains(t, <-recorder.Events, "mounted from server nas-2")
+
+	err = stage(map[string]string{sourceField: "//nas-1/share", alternateServersField: "nas-down"})
+	assert.NoError(t, err)
+	assert.Contains(t, <-recorder.Events, v1.EventTypeNormal+" "+serverMountedReason)
+
+	err = stage(map[string]string{sourceField: "//error_mount_sens/share-2", alternateServersField: "nas-down"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	err = stage(map[string]string{sourceField: "//nas-1/share", alternateServersField: "nas-2/share"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = stage(map[string]string{sourceField: "//nas-1/share", alternateServersField: "nas-2", volumeTypeField: volumeTypeImage})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInternalMountWithAlternateServers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip on windows")
+	}
+	d := NewFakeDriver()
+	d.NodeID = ""
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	d.eventRecorder = record.NewFakeRecorder(10)
+
+	volumeID := "error_mount_sens/share#pv-1##"
+	d.kubeClient = fake.NewSimpleClientset(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:           d.Name,
+					VolumeHandle:     volumeID,
+					VolumeAttributes: map[string]string{"alternateServers": "nas-down, nas-2"},
+				},
+			},
+		},
+	})
+
+	probeServerOrig := probeServer
+	defer func() { probeServer = probeServerOrig }()
+	var probed []string
+	probeServer = func(_ context.Context, address string) error {
+		probed = append(probed, address)
+		if strings.HasPrefix(address, "nas-down") {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+
+	vol, err := getSmbVolFromID(volumeID)
+	assert.NoError(t, err)
+	vol.alternateServers = d.getVolumeAlternateServers(context.Background(), volumeID)
+	assert.Equal(t, []string{"nas-down", "nas-2"}, vol.alternateServers)
+
+	// internal mount of the volume in controller fails over to the alternate server
+	secrets := map[string]string{usernameField: "test", passwordField: "test"}
+	assert.NoError(t, d.internalMount(context.Background(), vol, nil, secrets))
+	assert.Equal(t, []string{"error_mount_sens:445", "nas-down:445", "nas-2:445"}, probed)
+	assert.NoError(t, d.internalUnmount(context.Background(), vol))
+
+	assert.Nil(t, d.getVolumeAlternateServers(context.Background(), "smb-server/share#pv-2##"))
+
+	_, err = newSMBVolume("pv-3", 100, map[string]string{sourceField: "//nas-1/share", alternateServersField: "nas-2", volumeTypeField: volumeTypeImage}, "", nil)
+	assert.Error(t, err)
+}
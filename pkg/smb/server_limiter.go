@@ -0,0 +1,159 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// operations limited by serverLimiter
+const (
+	serverOpMount         = "mount"
+	serverOpInternalMount = "internalMount"
+	serverOpMkdir         = "mkdir"
+	serverOpDelete        = "delete"
+	serverOpCopy          = "copy"
+	serverOpShare         = "share"
+)
+
+// ServerLimitOptions defines the limits of operations against a single SMB server
+type ServerLimitOptions struct {
+	// max number of concurrent operations per server, 0 means unlimited
+	MaxConcurrency int
+	// max number of operations started per second per server, 0 means unlimited
+	QPS float64
+	// max burst of operations started per server, defaults to 1 if QPS is set
+	Burst int
+}
+
+// serverLimiter limits the concurrency and rate of operations per SMB server,
+// e.g. node mounts or controller internal mount, mkdir, delete and copy operations.
+// Waiting operations are released in FIFO order and stop waiting once ctx is done.
+type serverLimiter struct {
+	name string
+	opts ServerLimitOptions
+
+	mu      sync.Mutex
+	servers map[string]*serverLimit
+}
+
+type serverLimit struct {
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+func newServerLimiter(name string, opts ServerLimitOptions) *serverLimiter {
+	if opts.QPS > 0 && opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	return &serverLimiter{
+		name:    name,
+		opts:    opts,
+		servers: map[string]*serverLimit{},
+	}
+}
+
+func (l *serverLimiter) enabled() bool {
+	return l != nil && (l.opts.MaxConcurrency > 0 || l.opts.QPS > 0)
+}
+
+func (l *serverLimiter) get(server string) *serverLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.servers[server]
+	if !ok {
+		s = &serverLimit{}
+		if l.opts.MaxConcurrency > 0 {
+			s.sem = make(chan struct{}, l.opts.MaxConcurrency)
+		}
+		if l.opts.QPS > 0 {
+			s.limiter = rate.NewLimiter(rate.Limit(l.opts.QPS), l.opts.Burst)
+		}
+		l.servers[server] = s
+	}
+	return s
+}
+
+// Acquire waits until op against the server of source is allowed to run,
+// the returned release func must be called once the op is done.
+// Returns Aborted error if ctx is done before the op is allowed.
+func (l *serverLimiter) Acquire(ctx context.Context, source, op string) (func(), error) {
+	if !l.enabled() {
+		return func() {}, nil
+	}
+	server := getServerFromSource(source)
+	s := l.get(server)
+	start := time.Now()
+
+	serverOpQueueDepth.WithLabelValues(l.name, server).Inc()
+	err := s.wait(ctx)
+	serverOpQueueDepth.WithLabelValues(l.name, server).Dec()
+	waited := time.Since(start)
+	if err != nil {
+		recordServerOpWait(l.name, op, lockResultAborted, waited)
+		return nil, status.Errorf(codes.Aborted, "too many concurrent %s operations on server %s, waited %v: %v", op, server, waited.Round(time.Millisecond), err)
+	}
+	recordServerOpWait(l.name, op, lockResultAcquired, waited)
+	if waited > time.Second {
+		klog.V(2).Infof("%s operation on server %s waited %v for %s limit", op, server, waited.Round(time.Millisecond), l.name)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if s.sem != nil {
+				<-s.sem
+			}
+		})
+	}, nil
+}
+
+func (s *serverLimit) wait(ctx context.Context) error {
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.limiter != nil {
+		if err := s.limiter.Wait(ctx); err != nil {
+			if s.sem != nil {
+				<-s.sem
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// getServerFromSource returns the lower case server name of source, e.g. //server/share or \\server\share
+func getServerFromSource(source string) string {
+	source = strings.TrimLeft(source, `/\`)
+	if i := strings.IndexAny(source, `/\`); i >= 0 {
+		source = source[:i]
+	}
+	return strings.ToLower(source)
+}
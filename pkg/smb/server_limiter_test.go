@@ -0,0 +1,135 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetServerFromSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{source: "//Server/share", expected: "server"},
+		{source: "//server.example.com/share/sub", expected: "server.example.com"},
+		{source: `\\server\share`, expected: "server"},
+		{source: "server", expected: "server"},
+		{source: "", expected: ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getServerFromSource(test.source), test.source)
+	}
+}
+
+func TestServerLimiterUnlimited(t *testing.T) {
+	l := newServerLimiter("test", ServerLimitOptions{})
+	for i := 0; i < 10; i++ {
+		release, err := l.Acquire(context.Background(), "//server/share", serverOpMount)
+		assert.NoError(t, err)
+		defer release()
+	}
+
+	var nilLimiter *serverLimiter
+	release, err := nilLimiter.Acquire(context.Background(), "//server/share", serverOpMount)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestServerLimiterConcurrency(t *testing.T) {
+	l := newServerLimiter("test", ServerLimitOptions{MaxConcurrency: 2})
+	ctx := context.Background()
+
+	release1, err := l.Acquire(ctx, "//server/share1", serverOpMount)
+	assert.NoError(t, err)
+	release2, err := l.Acquire(ctx, "//SERVER/share2", serverOpMount)
+	assert.NoError(t, err)
+
+	// limit is per server
+	releaseOther, err := l.Acquire(ctx, "//other/share", serverOpMount)
+	assert.NoError(t, err)
+	releaseOther()
+
+	// third operation on the same server waits until ctx is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(timeoutCtx, "//server/share3", serverOpMount)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// third operation proceeds once a slot is released
+	acquired := make(chan error)
+	go func() {
+		release, err := l.Acquire(ctx, "//server/share3", serverOpMount)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected operation to wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release1()
+	// release is idempotent
+	release1()
+	assert.NoError(t, <-acquired)
+	release2()
+}
+
+func TestServerLimiterRate(t *testing.T) {
+	l := newServerLimiter("test", ServerLimitOptions{QPS: 1})
+	assert.Equal(t, 1, l.opts.Burst)
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "//server/share", serverOpInternalMount)
+	assert.NoError(t, err)
+	release()
+
+	// next token is available after 1s, which is longer than ctx deadline
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(timeoutCtx, "//server/share", serverOpInternalMount)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// other servers are not affected
+	release, err = l.Acquire(timeoutCtx, "//other/share", serverOpInternalMount)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestMountWithTimeoutServerLimit(t *testing.T) {
+	d := NewFakeDriver()
+	d.mountLimiter = newServerLimiter("mount", ServerLimitOptions{MaxConcurrency: 1})
+	release, err := d.mountLimiter.Acquire(context.Background(), "//server/share", serverOpMount)
+	assert.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	keepLock, err := d.mountWithTimeout(ctx, "//server/share", "/target", nil, nil, "vol-1", "vol-1-/target", 0, time.Second)
+	assert.False(t, keepLock)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	_, pending := d.pendingMounts.Load("vol-1-/target")
+	assert.False(t, pending)
+}
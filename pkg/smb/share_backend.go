@@ -0,0 +1,259 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// supported share and user backends
+const (
+	// ShareBackendSambaNetConf manages shares in Samba registry configuration with `net conf`
+	ShareBackendSambaNetConf = "samba-netconf"
+	// UserBackendSambaSmbpasswd manages Samba users with `smbpasswd` and `pdbedit`
+	UserBackendSambaSmbpasswd = "samba-smbpasswd"
+)
+
+var shareNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,79}$`)
+
+// ServerBackendOptions defines the backends which manage shares and users on SMB server
+type ServerBackendOptions struct {
+	// share backend type, empty means creating a share per volume is disabled
+	ShareBackend string
+	// user backend type, empty means creating a user per volume is disabled
+	UserBackend string
+	// ssh target (user@host) to run the backend commands on, commands are run locally if empty
+	SSHTarget string
+	// ssh private key file, default ssh identity is used if empty
+	SSHKeyFile string
+}
+
+// smbShare is a share created by share backend for a volume
+type smbShare struct {
+	// share name
+	name string
+	// directory on SMB server exported by the share
+	path string
+	// value of "valid users" of the share, any authenticated user could access the share if empty
+	validUsers string
+}
+
+// shareBackend creates and deletes shares on SMB server
+type shareBackend interface {
+	// CreateShare creates the share and its directory, it's a no-op if the share already exists
+	CreateShare(ctx context.Context, share *smbShare) error
+	// DeleteShare deletes the share and removes its directory, the directory is renamed to
+	// archived-{name} instead if archive is true. It's a no-op if the share does not exist.
+	DeleteShare(ctx context.Context, name string, archive bool) error
+}
+
+// newShareBackend returns the share backend of opts, nil if share backend is disabled
+func newShareBackend(opts ServerBackendOptions, removeArchivedPath bool) (shareBackend, error) {
+	switch opts.ShareBackend {
+	case "":
+		return nil, nil
+	case ShareBackendSambaNetConf:
+		return &sambaNetConfBackend{
+			serverCommand:      serverCommand{sshTarget: opts.SSHTarget, sshKeyFile: opts.SSHKeyFile},
+			removeArchivedPath: removeArchivedPath,
+		}, nil
+	default:
+		return nil, fmt.Errorf("share backend %q is not supported, supported backends: %s", opts.ShareBackend, ShareBackendSambaNetConf)
+	}
+}
+
+// validateShareName checks whether name could be used as a share name
+func validateShareName(name string) error {
+	if !shareNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid share name %q, it should match %s", name, shareNameRegex.String())
+	}
+	return nil
+}
+
+// validateSharePath checks whether p is an absolute directory on SMB server which could be exported by a share
+func validateSharePath(p string) error {
+	if !path.IsAbs(p) || path.Clean(p) == "/" {
+		return fmt.Errorf("share path %q should be an absolute path other than /", p)
+	}
+	return validatePath(p)
+}
+
+// sambaNetConfBackend manages shares in Samba registry configuration (`include = registry` or
+// `registry shares = yes` in smb.conf) with `net conf`, locally or on a remote host over ssh.
+type sambaNetConfBackend struct {
+	serverCommand
+	removeArchivedPath bool
+}
+
+func (b *sambaNetConfBackend) CreateShare(_ context.Context, share *smbShare) error {
+	if err := validateShareName(share.name); err != nil {
+		return err
+	}
+	if err := validateSharePath(share.path); err != nil {
+		return err
+	}
+	exists, err := b.shareExists(share.name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		klog.V(2).Infof("share %s already exists", share.name)
+		return nil
+	}
+
+	// net conf addshare requires the directory to exist
+	if _, err := b.run("mkdir", "-p", share.path); err != nil {
+		return err
+	}
+	if _, err := b.run("net", "conf", "addshare", share.name, share.path, "writeable=y", "guest_ok=n"); err != nil {
+		return err
+	}
+	if share.validUsers != "" {
+		if _, err := b.run("net", "conf", "setparm", share.name, "valid users", share.validUsers); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("created share %s on %s with path %s", share.name, b.host(), share.path)
+	return nil
+}
+
+func (b *sambaNetConfBackend) DeleteShare(_ context.Context, name string, archive bool) error {
+	if err := validateShareName(name); err != nil {
+		return err
+	}
+	exists, err := b.shareExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		klog.V(2).Infof("share %s does not exist", name)
+		return nil
+	}
+	out, err := b.run("net", "conf", "getparm", name, "path")
+	if err != nil {
+		return err
+	}
+	sharePath := strings.TrimSpace(out)
+	if err := validateSharePath(sharePath); err != nil {
+		return fmt.Errorf("refuse to delete share %s: %v", name, err)
+	}
+
+	// remove the directory before the share, so that a failed deletion could be retried
+	if archive {
+		archivedPath := path.Join(path.Dir(sharePath), "archived-"+path.Base(sharePath))
+		if b.removeArchivedPath {
+			if _, err := b.run("rm", "-rf", "--", archivedPath); err != nil {
+				return err
+			}
+		}
+		klog.V(2).Infof("archiving share directory %s --> %s", sharePath, archivedPath)
+		if _, err := b.run("mv", "-T", "--", sharePath, archivedPath); err != nil {
+			return err
+		}
+	} else {
+		klog.V(2).Infof("removing share directory %s", sharePath)
+		if _, err := b.run("rm", "-rf", "--", sharePath); err != nil {
+			return err
+		}
+	}
+	if _, err := b.run("net", "conf", "delshare", name); err != nil {
+		return err
+	}
+	klog.V(2).Infof("deleted share %s on %s", name, b.host())
+	return nil
+}
+
+// shareExists returns true if share name is in registry configuration
+func (b *sambaNetConfBackend) shareExists(name string) (bool, error) {
+	out, err := b.run("net", "conf", "listshares")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.EqualFold(strings.TrimSpace(line), name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// serverCommand runs commands on SMB server, locally or over ssh
+type serverCommand struct {
+	sshTarget  string
+	sshKeyFile string
+}
+
+// run runs the command locally or on sshTarget and returns its output
+func (c *serverCommand) run(args ...string) (string, error) {
+	return c.runWithInput("", args...)
+}
+
+// runWithInput runs the command with input as stdin, input is not logged
+func (c *serverCommand) runWithInput(input string, args ...string) (string, error) {
+	name, cmdArgs := args[0], args[1:]
+	if c.sshTarget != "" {
+		name = "ssh"
+		cmdArgs = []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+		if c.sshKeyFile != "" {
+			cmdArgs = append(cmdArgs, "-i", c.sshKeyFile)
+		}
+		// remote command is interpreted by the shell on sshTarget
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		cmdArgs = append(cmdArgs, c.sshTarget, "--", strings.Join(quoted, " "))
+	}
+	var out []byte
+	var err error
+	if input == "" {
+		out, err = runCommand(name, cmdArgs...)
+	} else {
+		out, err = runCommandWithInput(input, name, cmdArgs...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s on %s failed: %v, output: %s", strings.Join(args, " "), c.host(), err, string(out))
+	}
+	return string(out), nil
+}
+
+func (c *serverCommand) host() string {
+	if c.sshTarget == "" {
+		return "localhost"
+	}
+	return c.sshTarget
+}
+
+// shellQuote quotes s as a single argument of POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// getShareSource returns the source of share on the server of source, e.g. //server/share
+func getShareSource(source, share string) string {
+	server := strings.TrimLeft(source, `/\`)
+	if i := strings.IndexAny(server, `/\`); i >= 0 {
+		server = server[:i]
+	}
+	return "//" + server + "/" + share
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeShareBackend records the created and deleted shares
+type fakeShareBackend struct {
+	created  []*smbShare
+	deleted  []string
+	archived []string
+	err      error
+}
+
+func (f *fakeShareBackend) CreateShare(_ context.Context, share *smbShare) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.created = append(f.created, share)
+	return nil
+}
+
+func (f *fakeShareBackend) DeleteShare(_ context.Context, name string, archive bool) error {
+	if archive {
+		f.archived = append(f.archived, name)
+	} else {
+		f.deleted = append(f.deleted, name)
+	}
+	return nil
+}
+
+func TestNewShareBackend(t *testing.T) {
+	backend, err := newShareBackend(ServerBackendOptions{}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, backend)
+
+	backend, err = newShareBackend(ServerBackendOptions{ShareBackend: ShareBackendSambaNetConf, SSHTarget: "root@samba"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &sambaNetConfBackend{serverCommand: serverCommand{sshTarget: "root@samba"}, removeArchivedPath: true}, backend)
+
+	_, err = newShareBackend(ServerBackendOptions{ShareBackend: "truenas"}, true)
+	assert.Error(t, err)
+}
+
+func TestSambaNetConfCreateShare(t *testing.T) {
+	share := &smbShare{name: "pv-name", path: "/srv/samba/pv-name", validUsers: "@tenant1, user1"}
+	tests := []struct {
+		desc             string
+		backend          *sambaNetConfBackend
+		outputs          map[string]string
+		errors           map[string]error
+		expectedCommands []string
+		expectErr        bool
+	}{
+		{
+			desc:    "create share locally",
+			backend: &sambaNetConfBackend{},
+			outputs: map[string]string{"net conf listshares": "share1\n"},
+			expectedCommands: []string{
+				"net conf listshares",
+				"mkdir -p /srv/samba/pv-name",
+				"net conf addshare pv-name /srv/samba/pv-name writeable=y guest_ok=n",
+				"net conf setparm pv-name valid users @tenant1, user1",
+			},
+		},
+		{
+			desc:             "share already exists",
+			backend:          &sambaNetConfBackend{},
+			outputs:          map[string]string{"net conf listshares": "share1\npv-name\n"},
+			expectedCommands: []string{"net conf listshares"},
+		},
+		{
+			desc:    "create share over ssh",
+			backend: &sambaNetConfBackend{serverCommand: serverCommand{sshTarget: "root@samba", sshKeyFile: "/etc/ssh-key/id_rsa"}},
+			expectedCommands: []string{
+				"ssh -o BatchMode=yes -o ConnectTimeout=10 -i /etc/ssh-key/id_rsa root@samba -- 'net' 'conf' 'listshares'",
+				"ssh -o BatchMode=yes -o ConnectTimeout=10 -i /etc/ssh-key/id_rsa root@samba -- 'mkdir' '-p' '/srv/samba/pv-name'",
+				"ssh -o BatchMode=yes -o ConnectTimeout=10 -i /etc/ssh-key/id_rsa root@samba -- 'net' 'conf' 'addshare' 'pv-name' '/srv/samba/pv-name' 'writeable=y' 'guest_ok=n'",
+				"ssh -o BatchMode=yes -o ConnectTimeout=10 -i /etc/ssh-key/id_rsa root@samba -- 'net' 'conf' 'setparm' 'pv-name' 'valid users' '@tenant1, user1'",
+			},
+		},
+		{
+			desc:    "addshare failed",
+			backend: &sambaNetConfBackend{},
+			errors:  map[string]error{"net conf addshare pv-name /srv/samba/pv-name writeable=y guest_ok=n": fmt.Errorf("exit status 255")},
+			expectedCommands: []string{
+				"net conf listshares",
+				"mkdir -p /srv/samba/pv-name",
+				"net conf addshare pv-name /srv/samba/pv-name writeable=y guest_ok=n",
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		runner := &fakeCommandRunner{outputs: test.outputs, errors: test.errors}
+		setFakeCommandRunner(t, runner)
+		err := test.backend.CreateShare(context.Background(), share)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, test.expectedCommands, runner.commands, test.desc)
+	}
+
+	err := (&sambaNetConfBackend{}).CreateShare(context.Background(), &smbShare{name: "pv-name", path: "/"})
+	assert.Error(t, err)
+}
+
+func TestSambaNetConfDeleteShare(t *testing.T) {
+	tests := []struct {
+		desc             string
+		backend          *sambaNetConfBackend
+		archive          bool
+		outputs          map[string]string
+		expectedCommands []string
+		expectErr        bool
+	}{
+		{
+			desc:             "share does not exist",
+			backend:          &sambaNetConfBackend{},
+			expectedCommands: []string{"net conf listshares"},
+		},
+		{
+			desc:    "delete share",
+			backend: &sambaNetConfBackend{},
+			outputs: map[string]string{
+				"net conf listshares":           "pv-name\n",
+				"net conf getparm pv-name path": "/srv/samba/pv-name\n",
+			},
+			expectedCommands: []string{
+				"net conf listshares",
+				"net conf getparm pv-name path",
+				"rm -rf -- /srv/samba/pv-name",
+				"net conf delshare pv-name",
+			},
+		},
+		{
+			desc:    "archive share",
+			backend: &sambaNetConfBackend{removeArchivedPath: true},
+			archive: true,
+			outputs: map[string]string{
+				"net conf listshares":           "pv-name\n",
+				"net conf getparm pv-name path": "/srv/samba/pv-name\n",
+			},
+			expectedCommands: []string{
+				"net conf listshares",
+				"net conf getparm pv-name path",
+				"rm -rf -- /srv/samba/archived-pv-name",
+				"mv -T -- /srv/samba/pv-name /srv/samba/archived-pv-name",
+				"net conf delshare pv-name",
+			},
+		},
+		{
+			desc:    "refuse to delete root directory",
+			backend: &sambaNetConfBackend{},
+			outputs: map[string]string{
+				"net conf listshares":           "pv-name\n",
+				"net conf getparm pv-name path": "/\n",
+			},
+			expectedCommands: []string{
+				"net conf listshares",
+				"net conf getparm pv-name path",
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		runner := &fakeCommandRunner{outputs: test.outputs}
+		setFakeCommandRunner(t, runner)
+		err := test.backend.DeleteShare(context.Background(), "pv-name", test.archive)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, test.expectedCommands, runner.commands, test.desc)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'valid users'`, shellQuote("valid users"))
+	assert.Equal(t, `'a'\''b'`, shellQuote("a'b"))
+	assert.Equal(t, `''`, shellQuote(""))
+}
+
+func TestCreateDeleteShareVolume(t *testing.T) {
+	d := NewFakeDriver()
+	backend := &fakeShareBackend{}
+	req := &csi.CreateVolumeRequest{
+		Name: "pv-name",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		},
+		Parameters: map[string]string{
+			sourceField:       "//smb-server/share",
+			"sharePath":       "/srv/samba",
+			"shareValidUsers": "user1",
+			paramOnDelete:     archive,
+		},
+	}
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	d.shareBackend = backend
+	resp, err := d.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, []*smbShare{{name: "pv-name", path: "/srv/samba/pv-name", validUsers: "user1"}}, backend.created)
+	assert.Equal(t, "v2:ondelete=archive&share=pv-name&source=smb-server%2Fpv-name&uuid=pv-name", resp.GetVolume().GetVolumeId())
+	assert.Equal(t, "//smb-server/pv-name", resp.GetVolume().GetVolumeContext()[sourceField])
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pv-name"}, backend.archived)
+	assert.Empty(t, backend.deleted)
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "smb-server/pv-retain##pv-retain#retain#pv-retain"})
+	assert.NoError(t, err)
+	assert.Empty(t, backend.deleted)
+
+	d.shareBackend = nil
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "smb-server/pv-other##pv-other##pv-other"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -24,14 +24,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	certutil "k8s.io/client-go/util/cert"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
@@ -43,34 +48,64 @@ import (
 )
 
 const (
-	DefaultDriverName         = "smb.csi.k8s.io"
-	usernameField             = "username"
-	passwordField             = "password"
-	sourceField               = "source"
-	subDirField               = "subdir"
-	domainField               = "domain"
-	mountOptionsField         = "mountoptions"
-	secretNameField           = "secretname"
-	secretNamespaceField      = "secretnamespace"
-	paramOnDelete             = "ondelete"
-	defaultDomainName         = "AZURE"
-	ephemeralField            = "csi.storage.k8s.io/ephemeral"
-	podNamespaceField         = "csi.storage.k8s.io/pod.namespace"
-	pvcNameKey                = "csi.storage.k8s.io/pvc/name"
-	pvcNamespaceKey           = "csi.storage.k8s.io/pvc/namespace"
-	pvNameKey                 = "csi.storage.k8s.io/pv/name"
-	pvcNameMetadata           = "${pvc.metadata.name}"
-	pvcNamespaceMetadata      = "${pvc.metadata.namespace}"
-	pvNameMetadata            = "${pv.metadata.name}"
-	DefaultKrb5CCName         = "krb5cc_"
-	DefaultKrb5CacheDirectory = "/var/lib/kubelet/kerberos/"
-	retain                    = "retain"
-	archive                   = "archive"
-	fileMode                  = "file_mode"
-	dirMode                   = "dir_mode"
-	defaultFileMode           = "0777"
-	defaultDirMode            = "0777"
-	trueValue                 = "true"
+	DefaultDriverName            = "smb.csi.k8s.io"
+	usernameField                = "username"
+	passwordField                = "password"
+	sourceField                  = "source"
+	subDirField                  = "subdir"
+	domainField                  = "domain"
+	mountOptionsField            = "mountoptions"
+	secretNameField              = "secretname"
+	secretNamespaceField         = "secretnamespace"
+	paramOnDelete                = "ondelete"
+	volumeTypeField              = "volumetype"
+	volumeTypeImage              = "image"
+	fsTypeField                  = "fstype"
+	srcSecretNameField           = "clonesourcesecretname"
+	srcSecretNamespaceField      = "clonesourcesecretnamespace"
+	snapshotField                = "snapshot"
+	sharePathField               = "sharepath"
+	shareValidUsersField         = "sharevalidusers"
+	perVolumeUserField           = "pervolumeuser"
+	subDirUIDField               = "subdiruid"
+	subDirGIDField               = "subdirgid"
+	subDirModeField              = "subdirmode"
+	parentDirModeField           = "parentdirmode"
+	subDirSDDLField              = "subdirsddl"
+	pvcMountOptionOverridesField = "pvcmountoptionoverrides"
+	pvcSubDirOverrideField       = "pvcsubdiroverride"
+	pvcMountOptionsField         = "pvcmountoptions"
+	archiveMaxAgeField           = "archivemaxage"
+	archiveMaxCountField         = "archivemaxcount"
+	archiveSourceField           = "archivesource"
+	archiveDirField              = "archivedir"
+	archiveSecretNameField       = "archivesecretname"
+	archiveSecretNamespaceField  = "archivesecretnamespace"
+	restoreFromArchiveField      = "restorefromarchive"
+	crossNamespaceRestoreField   = "allowcrossnamespacerestore"
+	internalMountOptionsField    = "internalmountoptions"
+	credentialModeField          = "credentialmode"
+	sourcesField                 = "sources"
+	placementPolicyField         = "placementpolicy"
+	alternateServersField        = "alternateservers"
+	defaultDomainName            = "AZURE"
+	ephemeralField               = "csi.storage.k8s.io/ephemeral"
+	podNamespaceField            = "csi.storage.k8s.io/pod.namespace"
+	pvcNameKey                   = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey              = "csi.storage.k8s.io/pvc/namespace"
+	pvNameKey                    = "csi.storage.k8s.io/pv/name"
+	pvcNameMetadata              = "${pvc.metadata.name}"
+	pvcNamespaceMetadata         = "${pvc.metadata.namespace}"
+	pvNameMetadata               = "${pv.metadata.name}"
+	DefaultKrb5CCName            = "krb5cc_"
+	DefaultKrb5CacheDirectory    = "/var/lib/kubelet/kerberos/"
+	retain                       = "retain"
+	archive                      = "archive"
+	fileMode                     = "file_mode"
+	dirMode                      = "dir_mode"
+	defaultFileMode              = "0777"
+	defaultDirMode               = "0777"
+	trueValue                    = "true"
 )
 
 var supportedOnDeleteValues = []string{"", "delete", retain, archive}
@@ -90,6 +125,32 @@ type DriverOptions struct {
 	RemoveArchivedVolumePath      bool
 	EnableWindowsHostProcess      bool
 	Kubeconfig                    string
+	// check whether Krb5CacheDirectory exists in readiness check
+	CheckKrb5CacheDirectory bool
+	// TLS certificate files for tcp:// endpoint
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// max time to wait for a volume lock held by another operation, 0 means returning Aborted immediately
+	VolumeLockWaitTimeout time.Duration
+	// limits of mounts per SMB server on node
+	MountLimits ServerLimitOptions
+	// limits of internal mount, mkdir, delete and copy operations per SMB server in controller
+	ControllerOpLimits ServerLimitOptions
+	// backends to create a share and a user per volume on SMB server
+	ServerBackend ServerBackendOptions
+	// interval of pruning expired archives in controller, 0 means disabled
+	ArchiveGCInterval time.Duration
+	// number of workers deleting volumes moved to trash in background, 0 means volumes are deleted in DeleteVolume
+	AsyncDeleteWorkers int
+	// path patterns of subDir which DeleteVolume never deletes or archives
+	ProtectedPaths []string
+	// delete or archive directories without volume marker, e.g. volumes created before volume marker is introduced
+	AllowDeleteWithoutVolumeMarker bool
+	// node labels reported as topology segments of node
+	TopologyKeys []string
+	// topology segments of node, they take precedence over node labels
+	TopologySegments map[string]string
 }
 
 // Driver implements all interfaces of CSI drivers
@@ -111,15 +172,46 @@ type Driver struct {
 	volStatsCache azcache.Resource
 	// a timed cache storing volume deletion records <volumeID, "">
 	volDeletionCache azcache.Resource
+	// a timed cache storing sources chosen from source pools <volume name, source>
+	placementCache azcache.Resource
+	sourcePlacer   *sourcePlacer
 	// this only applies to Windows node
 	removeSMBMappingDuringUnmount bool
 	krb5CacheDirectory            string
 	krb5Prefix                    string
+	checkKrb5CacheDirectory       bool
 	defaultOnDeletePolicy         string
 	removeArchivedVolumePath      bool
 	enableWindowsHostProcess      bool
 	kubeconfig                    string
 	kubeClient                    kubernetes.Interface
+	tlsOptions                    csicommon.TLSOptions
+	// mount goroutines started by mountWithTimeout which are not finished yet <lockKey, pendingMount>
+	pendingMounts sync.Map
+	// limits mounts per SMB server
+	mountLimiter *serverLimiter
+	// limits controller operations per SMB server
+	controllerOpLimiter *serverLimiter
+	// creates and deletes a share per volume, nil if it's disabled
+	shareBackend shareBackend
+	// creates and deletes a user per volume, nil if it's disabled
+	userBackend userBackend
+	// interval of pruning expired archives, 0 means disabled
+	archiveGCInterval time.Duration
+	// number of workers emptying trash, 0 means disabled
+	asyncDeleteWorkers int
+	// empties trash in background, nil if asynchronous deletion is disabled
+	trashDeleter *trashDeleter
+	// path patterns of subDir which are never deleted or archived
+	protectedPaths                 []string
+	allowDeleteWithoutVolumeMarker bool
+	// records events on PV, nil if KubeClient is not available
+	eventRecorder record.EventRecorder
+	// topology of node, topology is disabled if both are empty
+	topologyKeys     []string
+	topologySegments map[string]string
+	// remembers the last working server of sources with alternate servers on node
+	serverSelector *serverSelector
 }
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -135,7 +227,26 @@ func NewDriver(options *DriverOptions) *Driver {
 	driver.workingMountDir = options.WorkingMountDir
 	driver.enableWindowsHostProcess = options.EnableWindowsHostProcess
 	driver.kubeconfig = options.Kubeconfig
-	driver.volumeLocks = newVolumeLocks()
+	driver.volumeLocks = newVolumeLocks(options.VolumeLockWaitTimeout)
+	driver.mountLimiter = newServerLimiter("mount", options.MountLimits)
+	driver.controllerOpLimiter = newServerLimiter("controller", options.ControllerOpLimits)
+	driver.archiveGCInterval = options.ArchiveGCInterval
+	driver.asyncDeleteWorkers = options.AsyncDeleteWorkers
+	if driver.asyncDeleteWorkers > 0 {
+		driver.trashDeleter = newTrashDeleter(&driver)
+	}
+	if err := validateProtectedPaths(options.ProtectedPaths); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	driver.protectedPaths = options.ProtectedPaths
+	driver.allowDeleteWithoutVolumeMarker = options.AllowDeleteWithoutVolumeMarker
+	driver.topologyKeys = options.TopologyKeys
+	driver.topologySegments = options.TopologySegments
+	driver.tlsOptions = csicommon.TLSOptions{
+		CertFile:     options.TLSCertFile,
+		KeyFile:      options.TLSKeyFile,
+		ClientCAFile: options.TLSClientCAFile,
+	}
 
 	driver.krb5CacheDirectory = options.Krb5CacheDirectory
 	if driver.krb5CacheDirectory == "" {
@@ -145,11 +256,18 @@ func NewDriver(options *DriverOptions) *Driver {
 	if driver.krb5Prefix == "" {
 		driver.krb5Prefix = DefaultKrb5CCName
 	}
+	driver.checkKrb5CacheDirectory = options.CheckKrb5CacheDirectory
 
 	if options.VolStatsCacheExpireInMinutes <= 0 {
 		options.VolStatsCacheExpireInMinutes = 10 // default expire in 10 minutes
 	}
 	var err error
+	if driver.shareBackend, err = newShareBackend(options.ServerBackend, options.RemoveArchivedVolumePath); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	if driver.userBackend, err = newUserBackend(options.ServerBackend); err != nil {
+		klog.Fatalf("%v", err)
+	}
 	getter := func(_ string) (interface{}, error) { return nil, nil }
 	if driver.volStatsCache, err = azcache.NewTimedCache(time.Duration(options.VolStatsCacheExpireInMinutes)*time.Minute, getter, false); err != nil {
 		klog.Fatalf("%v", err)
@@ -157,11 +275,20 @@ func NewDriver(options *DriverOptions) *Driver {
 	if driver.volDeletionCache, err = azcache.NewTimedCache(time.Minute, getter, false); err != nil {
 		klog.Fatalf("%v", err)
 	}
+	if driver.placementCache, err = azcache.NewTimedCache(10*time.Minute, getter, false); err != nil {
+		klog.Fatalf("%v", err)
+	}
+	driver.sourcePlacer = newSourcePlacer()
+	driver.serverSelector = newServerSelector()
 
 	kubeCfg, err := getKubeConfig(driver.kubeconfig, driver.enableWindowsHostProcess)
 	if err == nil && kubeCfg != nil {
 		if driver.kubeClient, err = kubernetes.NewForConfig(kubeCfg); err != nil {
 			klog.Warningf("NewForConfig failed with error: %v", err)
+		} else {
+			broadcaster := record.NewBroadcaster()
+			broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: driver.kubeClient.CoreV1().Events("")})
+			driver.eventRecorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: driver.Name})
 		}
 	} else {
 		klog.Warningf("get kubeconfig(%s) failed with error: %v", driver.kubeconfig, err)
@@ -189,6 +316,8 @@ func (d *Driver) Run(endpoint, _ string, testMode bool) {
 			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
 		})
 
 	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
@@ -205,13 +334,21 @@ func (d *Driver) Run(endpoint, _ string, testMode bool) {
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 	}
 	if d.enableGetVolumeStats {
 		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
 	}
 	d.AddNodeServiceCapabilities(nodeCap)
 
-	s := csicommon.NewNonBlockingGRPCServer()
+	if d.archiveGCInterval > 0 {
+		go d.runArchiveGC(context.Background(), d.archiveGCInterval)
+	}
+	if d.trashDeleter != nil {
+		go d.trashDeleter.run(context.Background(), d.asyncDeleteWorkers)
+	}
+
+	s := csicommon.NewNonBlockingGRPCServerWithTLS(&d.tlsOptions)
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	s.Start(endpoint, d, d, d, testMode)
 	s.Wait()
@@ -20,6 +20,8 @@ limitations under the License.
 package smb
 
 import (
+	"fmt"
+	"io/fs"
 	"os"
 
 	mount "k8s.io/mount-utils"
@@ -48,3 +50,40 @@ func prepareStagePath(path string, m *mount.SafeFormatAndMount) error {
 func Mkdir(m *mount.SafeFormatAndMount, name string, perm os.FileMode) error {
 	return os.Mkdir(name, perm)
 }
+
+// checkCIFSAvailable returns nil since SMB client is built into macOS
+func checkCIFSAvailable() error {
+	return nil
+}
+
+func attachLoopDevice(_ string, _ bool) (string, error) {
+	return "", fmt.Errorf("loop device is not supported on darwin")
+}
+
+func findLoopDevice(_ string) (string, error) {
+	return "", fmt.Errorf("loop device is not supported on darwin")
+}
+
+func detachLoopDevice(_ string) error {
+	return fmt.Errorf("loop device is not supported on darwin")
+}
+
+func resizeLoopDevice(_ string) error {
+	return fmt.Errorf("loop device is not supported on darwin")
+}
+
+func serverSideCopyFile(_, _ *os.File, _ int64) (string, error) {
+	return "", fmt.Errorf("server side copy is not supported on darwin")
+}
+
+func listShadowCopies(_ string) ([]string, error) {
+	return nil, fmt.Errorf("listing snapshots is not supported on darwin")
+}
+
+func copyOwnership(_, _ string, _ fs.FileInfo) error {
+	return nil
+}
+
+func setSecurityDescriptor(_ string, _ *securityDescriptor) error {
+	return fmt.Errorf("setting security descriptor is not supported on darwin")
+}
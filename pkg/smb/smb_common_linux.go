@@ -21,12 +21,27 @@ package smb
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"os/exec"
 	"strings"
+	"syscall"
 
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 )
 
+// setSecurityDescriptor sets the NT security descriptor on path of cifs mount, only DACL is set unless
+// owner or group is in the security descriptor
+func setSecurityDescriptor(path string, sd *securityDescriptor) error {
+	attr := "system.cifs_acl"
+	if sd.hasOwner {
+		attr = "system.cifs_ntsd"
+	}
+	return unix.Setxattr(path, attr, sd.data, 0)
+}
+
 // Returns true if the `options` contains password with a special characters, and so "credentials=" needed.
 // (see comments for ContainsSpecialCharacter() in pkg/smb/nodeserver.go).
 // NB: implementation relies on the format:
@@ -76,3 +91,138 @@ func prepareStagePath(_ string, _ *mount.SafeFormatAndMount) error {
 func Mkdir(_ *mount.SafeFormatAndMount, name string, perm os.FileMode) error {
 	return os.Mkdir(name, perm)
 }
+
+// checkCIFSAvailable returns nil if mount.cifs is installed or cifs kernel module is loaded
+func checkCIFSAvailable() error {
+	if _, err := exec.LookPath(cifsMountHelper); err == nil {
+		return nil
+	}
+	content, err := os.ReadFile(procFilesystems)
+	if err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 0 && fields[len(fields)-1] == "cifs" {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("neither %s nor cifs kernel module is available, cifs-utils must be installed", cifsMountHelper)
+}
+
+// attachLoopDevice attaches imagePath to a free loop device and returns the device, the loop device is
+// read-only if readOnly is true. The existing loop device is returned if imagePath is already attached.
+func attachLoopDevice(imagePath string, readOnly bool) (string, error) {
+	device, err := findLoopDevice(imagePath)
+	if err != nil || device != "" {
+		return device, err
+	}
+	args := []string{"--find", "--show"}
+	if readOnly {
+		args = append(args, "--read-only")
+	}
+	out, err := runCommand("losetup", append(args, imagePath)...)
+	if err != nil {
+		return "", fmt.Errorf("losetup %s %s failed with %v, output: %s", strings.Join(args, " "), imagePath, err, string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// findLoopDevice returns the loop device imagePath is attached to, empty if not attached
+func findLoopDevice(imagePath string) (string, error) {
+	out, err := runCommand("losetup", "--associated", imagePath)
+	if err != nil {
+		return "", fmt.Errorf("losetup --associated %s failed with %v, output: %s", imagePath, err, string(out))
+	}
+	// output format: /dev/loop0: [0047]:123 (/path/to/disk.img)
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			return strings.TrimSpace(line[:i]), nil
+		}
+	}
+	return "", nil
+}
+
+// detachLoopDevice detaches the loop device imagePath is attached to
+func detachLoopDevice(imagePath string) error {
+	device, err := findLoopDevice(imagePath)
+	if err != nil || device == "" {
+		return err
+	}
+	if out, err := runCommand("losetup", "--detach", device); err != nil {
+		return fmt.Errorf("losetup --detach %s failed with %v, output: %s", device, err, string(out))
+	}
+	klog.V(2).Infof("detached loop device %s of %s", device, imagePath)
+	return nil
+}
+
+// resizeLoopDevice reloads the size of the backing file of loop device
+func resizeLoopDevice(device string) error {
+	if out, err := runCommand("losetup", "--set-capacity", device); err != nil {
+		return fmt.Errorf("losetup --set-capacity %s failed with %v, output: %s", device, err, string(out))
+	}
+	return nil
+}
+
+// serverSideCopyFile copies data on server with copy_file_range, which CIFS client turns into FSCTL_SRV_COPYCHUNK
+// requests. Reflink (FICLONE) is not tried, it fails with EXDEV between the internal mounts of two volumes.
+func serverSideCopyFile(dst, src *os.File, size int64) (string, error) {
+	for remaining := size; remaining > 0; {
+		chunk := remaining
+		if chunk > maxCopyFileRangeChunk {
+			chunk = maxCopyFileRangeChunk
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(chunk), 0)
+		if err != nil {
+			return "", fmt.Errorf("copy_file_range failed: %v", err)
+		}
+		if n == 0 {
+			// source file is truncated during copy
+			break
+		}
+		remaining -= int64(n)
+	}
+	return cloneMethodServerSideCopy, nil
+}
+
+// copyOwnership sets owner, group and extended attributes of src on dst like `cp -a`, symlinks are not followed
+func copyOwnership(src, dst string, info fs.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size == 0 {
+		if err == unix.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(src, names); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		n, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("get xattr %s failed: %v", name, err)
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(src, name, value); err != nil {
+			return fmt.Errorf("get xattr %s failed: %v", name, err)
+		}
+		if err := unix.Lsetxattr(dst, name, value[:n], 0); err != nil {
+			return fmt.Errorf("set xattr %s failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// listShadowCopies returns the @GMT tokens of snapshots (previous versions) of path on SMB server
+func listShadowCopies(path string) ([]string, error) {
+	out, err := runCommand("smbinfo", "list-snapshots", path)
+	if err != nil {
+		return nil, fmt.Errorf("smbinfo list-snapshots %s failed with %v, output: %s", path, err, string(out))
+	}
+	return parseGMTTokens(string(out)), nil
+}
@@ -17,8 +17,14 @@ limitations under the License.
 package smb
 
 import (
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func TestNeedsCredentialsOption(t *testing.T) {
@@ -52,3 +58,83 @@ func TestNeedsCredentialsOption(t *testing.T) {
 		assert.Equal(t, test.expectedResult, NeedsCredentialsOption(test.options))
 	}
 }
+
+func TestLoopDevice(t *testing.T) {
+	imagePath := "/var/lib/kubelet/globalmount.smbshare/disk.img"
+	runner := &fakeCommandRunner{
+		outputs: map[string]string{
+			"losetup --find --show " + imagePath: "/dev/loop3\n",
+		},
+		errors: map[string]error{},
+	}
+	setFakeCommandRunner(t, runner)
+
+	// not attached yet
+	device, err := findLoopDevice(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "", device)
+	assert.NoError(t, detachLoopDevice(imagePath))
+
+	device, err = attachLoopDevice(imagePath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/loop3", device)
+	assert.Equal(t, []string{
+		"losetup --associated " + imagePath,
+		"losetup --associated " + imagePath,
+		"losetup --associated " + imagePath,
+		"losetup --find --show " + imagePath,
+	}, runner.commands)
+
+	// already attached
+	runner.commands = nil
+	runner.outputs["losetup --associated "+imagePath] = "/dev/loop3: [0047]:123 (" + imagePath + ")\n"
+	device, err = attachLoopDevice(imagePath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/loop3", device)
+	assert.NoError(t, detachLoopDevice(imagePath))
+	assert.Equal(t, []string{
+		"losetup --associated " + imagePath,
+		"losetup --associated " + imagePath,
+		"losetup --detach /dev/loop3",
+	}, runner.commands)
+
+	runner.errors["losetup --detach /dev/loop3"] = fmt.Errorf("device busy")
+	assert.Error(t, detachLoopDevice(imagePath))
+
+	// read-only loop device
+	runner.commands = nil
+	runner.outputs["losetup --associated "+imagePath] = ""
+	runner.outputs["losetup --find --show --read-only "+imagePath] = "/dev/loop4\n"
+	device, err = attachLoopDevice(imagePath, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/loop4", device)
+	assert.Equal(t, "losetup --find --show --read-only "+imagePath, runner.commands[len(runner.commands)-1])
+}
+
+func TestCopyOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owner requires root")
+	}
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	assert.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+	assert.NoError(t, os.WriteFile(dst, []byte("data"), 0644))
+	assert.NoError(t, os.Lchown(src, 1234, 5678))
+	hasXattr := unix.Lsetxattr(src, "user.team", []byte("payments"), 0) == nil
+
+	info, err := os.Lstat(src)
+	assert.NoError(t, err)
+	assert.NoError(t, copyOwnership(src, dst, info))
+
+	dstInfo, err := os.Lstat(dst)
+	assert.NoError(t, err)
+	st := dstInfo.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(1234), st.Uid)
+	assert.Equal(t, uint32(5678), st.Gid)
+	if hasXattr {
+		value := make([]byte, 64)
+		n, err := unix.Lgetxattr(dst, "user.team", value)
+		assert.NoError(t, err)
+		assert.Equal(t, "payments", string(value[:n]))
+	}
+}
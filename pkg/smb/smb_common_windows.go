@@ -21,6 +21,7 @@ package smb
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 
 	"github.com/kubernetes-csi/csi-driver-smb/pkg/mounter"
@@ -87,3 +88,40 @@ func Mkdir(m *mount.SafeFormatAndMount, name string, perm os.FileMode) error {
 	}
 	return fmt.Errorf("could not cast to csi proxy class")
 }
+
+// checkCIFSAvailable returns nil since SMB client is built into Windows
+func checkCIFSAvailable() error {
+	return nil
+}
+
+func attachLoopDevice(_ string, _ bool) (string, error) {
+	return "", fmt.Errorf("loop device is not supported on windows")
+}
+
+func findLoopDevice(_ string) (string, error) {
+	return "", fmt.Errorf("loop device is not supported on windows")
+}
+
+func detachLoopDevice(_ string) error {
+	return fmt.Errorf("loop device is not supported on windows")
+}
+
+func resizeLoopDevice(_ string) error {
+	return fmt.Errorf("loop device is not supported on windows")
+}
+
+func serverSideCopyFile(_, _ *os.File, _ int64) (string, error) {
+	return "", fmt.Errorf("server side copy is not supported on windows")
+}
+
+func listShadowCopies(_ string) ([]string, error) {
+	return nil, fmt.Errorf("listing snapshots is not supported on windows")
+}
+
+func copyOwnership(_, _ string, _ fs.FileInfo) error {
+	return nil
+}
+
+func setSecurityDescriptor(_ string, _ *securityDescriptor) error {
+	return fmt.Errorf("setting security descriptor is not supported on windows")
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+)
+
+// Snapshots are the "previous versions" taken on SMB server (e.g. Samba vfs_shadow_copy2 or Windows VSS),
+// identified by @GMT-YYYY.MM.DD-HH.MM.SS tokens. The driver does not create or delete them, they are
+// discovered by ListSnapshots and could be imported by VolumeSnapshotContent.
+// Snapshot ID format: {volume-id}#{@GMT token}
+const (
+	gmtTokenLayout = "@GMT-2006.01.02-15.04.05"
+	// seconds between 1601-01-01 (NT time epoch) and 1970-01-01
+	ntTimeEpochOffset = 11644473600
+)
+
+var gmtTokenRegex = regexp.MustCompile(`@GMT-\d{4}\.\d{2}\.\d{2}-\d{2}\.\d{2}\.\d{2}`)
+
+// getSnapshotID returns snapshot ID of the @GMT token of volume
+func getSnapshotID(volumeID, token string) string {
+	return volumeID + separator + token
+}
+
+// splitSnapshotID returns volume ID and @GMT token of snapshot ID
+func splitSnapshotID(snapshotID string) (string, string, error) {
+	i := strings.LastIndex(snapshotID, separator)
+	if i <= 0 {
+		return "", "", fmt.Errorf("could not split %q into volume id and snapshot", snapshotID)
+	}
+	token := snapshotID[i+1:]
+	if _, err := parseGMTToken(token); err != nil {
+		return "", "", err
+	}
+	return snapshotID[:i], token, nil
+}
+
+// parseGMTToken returns the time of @GMT-YYYY.MM.DD-HH.MM.SS token
+func parseGMTToken(token string) (time.Time, error) {
+	if !gmtTokenRegex.MatchString(token) || len(token) != len(gmtTokenLayout) {
+		return time.Time{}, fmt.Errorf("invalid snapshot token %q, expected format: %s", token, gmtTokenLayout)
+	}
+	return time.Parse(gmtTokenLayout, token)
+}
+
+// parseGMTTokens returns the sorted and deduplicated @GMT tokens in output of `smbinfo list-snapshots`
+func parseGMTTokens(output string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, token := range gmtTokenRegex.FindAllString(output, -1) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// getSnapshotMountOptions returns the cifs mount options to mount the snapshot of @GMT token read-only
+func getSnapshotMountOptions(token string) ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("snapshot volume is not supported on %s node", runtime.GOOS)
+	}
+	t, err := parseGMTToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// snapshot time in 100-nanosecond units since 1601-01-01
+	ntTime := (t.Unix() + ntTimeEpochOffset) * 10000000
+	return []string{"ro", fmt.Sprintf("snapshot=%d", ntTime)}, nil
+}
+
+// newSnapshot returns the csi snapshot of @GMT token of volume
+func newSnapshot(volumeID, token string) *csi.Snapshot {
+	snapshot := &csi.Snapshot{
+		SnapshotId:     getSnapshotID(volumeID, token),
+		SourceVolumeId: volumeID,
+		ReadyToUse:     true,
+	}
+	if t, err := parseGMTToken(token); err == nil {
+		snapshot.CreationTime = timestamppb.New(t)
+	}
+	return snapshot
+}
+
+// paginateSnapshots returns snapshots from startingToken (index) with at most maxEntries
+func paginateSnapshots(snapshots []*csi.ListSnapshotsResponse_Entry, startingToken string, maxEntries int32) (*csi.ListSnapshotsResponse, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		if start, err = strconv.Atoi(startingToken); err != nil || start < 0 || start > len(snapshots) {
+			return nil, status.Errorf(codes.Aborted, "invalid starting token %s", startingToken)
+		}
+	}
+	end := len(snapshots)
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+	resp := &csi.ListSnapshotsResponse{Entries: snapshots[start:end]}
+	if end < len(snapshots) {
+		resp.NextToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+// createVolumeFromSnapshot returns a volume which mounts the snapshot of source volume read-only,
+// the volume is retained on deletion since it shares the subdirectory with source volume.
+func (d *Driver) createVolumeFromSnapshot(req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	snapshotID := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()
+	srcVolumeID, token, err := splitSnapshotID(snapshotID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	srcVol, err := getSmbVolFromID(srcVolumeID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	vol := &smbVolume{
+		source:   srcVol.source,
+		subDir:   srcVol.subDir,
+		uuid:     req.GetName(),
+		onDelete: retain,
+	}
+	volumeContext := map[string]string{
+		sourceField:   srcVol.source,
+		subDirField:   srcVol.subDir,
+		snapshotField: token,
+	}
+	// node stage secret and pv/pvc metadata in storage class parameters are kept, so the snapshot is mounted
+	// with the same credentials as a volume provisioned by the storage class
+	for k, v := range req.GetParameters() {
+		switch strings.ToLower(k) {
+		case secretNameField, secretNamespaceField, pvcNamespaceKey, pvcNameKey, pvNameKey:
+			volumeContext[k] = v
+		}
+	}
+	// mount options and credential mode are recorded in volume context for internal mounts of the volume
+	mountFlags := req.GetVolumeCapabilities()[0].GetMount().GetMountFlags()
+	setKeyValueInMap(volumeContext, internalMountOptionsField, strings.Join(mountFlags, ","))
+	setKeyValueInMap(volumeContext, credentialModeField, getCredentialMode(mountFlags))
+	klog.V(2).Infof("CreateVolume(%s) from snapshot %s of volume %s", req.GetName(), token, srcVolumeID)
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      getVolumeIDFromSmbVol(vol),
+			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			VolumeContext: volumeContext,
+			ContentSource: req.GetVolumeContentSource(),
+		},
+	}, nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSplitSnapshotID(t *testing.T) {
+	tests := []struct {
+		snapshotID       string
+		expectedVolumeID string
+		expectedToken    string
+		expectErr        bool
+	}{
+		{
+			snapshotID:       "smb-server/share#subdir#pv-name##@GMT-2024.01.02-03.04.05",
+			expectedVolumeID: "smb-server/share#subdir#pv-name#",
+			expectedToken:    "@GMT-2024.01.02-03.04.05",
+		},
+		{
+			snapshotID:       getSnapshotID("smb-server/share#subdir", "@GMT-2024.01.02-03.04.05"),
+			expectedVolumeID: "smb-server/share#subdir",
+			expectedToken:    "@GMT-2024.01.02-03.04.05",
+		},
+		{
+			snapshotID: "smb-server/share#subdir#pv-name#",
+			expectErr:  true,
+		},
+		{
+			snapshotID: "smb-server/share#subdir#@GMT-2024.13.02-03.04.05",
+			expectErr:  true,
+		},
+		{
+			snapshotID: "#@GMT-2024.01.02-03.04.05",
+			expectErr:  true,
+		},
+		{
+			snapshotID: "smb-server/share#subdir#x@GMT-2024.01.02-03.04.05",
+			expectErr:  true,
+		},
+	}
+	for _, test := range tests {
+		volumeID, token, err := splitSnapshotID(test.snapshotID)
+		assert.Equal(t, test.expectErr, err != nil, test.snapshotID)
+		assert.Equal(t, test.expectedVolumeID, volumeID, test.snapshotID)
+		assert.Equal(t, test.expectedToken, token, test.snapshotID)
+	}
+}
+
+func TestParseGMTTokens(t *testing.T) {
+	output := `Number of snapshots: 3 Number of snapshots returned: 3
+Snapshot list in GMT format
+@GMT-2024.01.02-03.04.05
+@GMT-2023.12.31-23.00.00
+@GMT-2024.01.02-03.04.05
+`
+	assert.Equal(t, []string{"@GMT-2023.12.31-23.00.00", "@GMT-2024.01.02-03.04.05"}, parseGMTTokens(output))
+	assert.Nil(t, parseGMTTokens("Number of snapshots: 0 Number of snapshots returned: 0"))
+}
+
+func TestGetSnapshotMountOptions(t *testing.T) {
+	options, err := getSnapshotMountOptions("@GMT-2024.01.02-03.04.05")
+	if runtime.GOOS != "linux" {
+		assert.Error(t, err)
+		return
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ro", "snapshot=133486382450000000"}, options)
+
+	_, err = getSnapshotMountOptions("2024.01.02-03.04.05")
+	assert.Error(t, err)
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	d := NewFakeDriver()
+	volCaps := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"vers=3.0", "dir_mode=0777"}}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+		},
+	}
+	newRequest := func(snapshotID string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:               "pv-restore",
+			VolumeCapabilities: volCaps,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 100},
+			Parameters: map[string]string{
+				sourceField:          "//other-server/share",
+				"secretName":         "smbcreds",
+				"secretNamespace":    "default",
+				pvNameKey:            "pv-restore",
+				subDirUIDField:       "1000",
+				archiveMaxCountField: "3",
+			},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			},
+		}
+	}
+
+	resp, err := d.CreateVolume(context.Background(), newRequest("smb-server/share#subdir#pv-src##@GMT-2024.01.02-03.04.05"))
+	assert.NoError(t, err)
+	vol := resp.GetVolume()
+	assert.Equal(t, "smb-server/share#subdir#pv-restore#retain", vol.GetVolumeId())
+	assert.Equal(t, int64(100), vol.GetCapacityBytes())
+	assert.Equal(t, map[string]string{
+		sourceField:               "//smb-server/share",
+		subDirField:               "subdir",
+		snapshotField:             "@GMT-2024.01.02-03.04.05",
+		"secretName":              "smbcreds",
+		"secretNamespace":         "default",
+		pvNameKey:                 "pv-restore",
+		internalMountOptionsField: "vers=3.0,dir_mode=0777",
+		credentialModeField:       credentialModePassword,
+	}, vol.GetVolumeContext())
+
+	_, err = d.CreateVolume(context.Background(), newRequest("smb-server/share#subdir#pv-src#"))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestNodeStageSnapshotVolume(t *testing.T) {
+	d := NewFakeDriver()
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "vol_1",
+		StagingTargetPath: t.TempDir(),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+		VolumeContext: map[string]string{
+			sourceField:     "//smb-server/share",
+			snapshotField:   "@GMT-2024.01.02-03.04.05",
+			volumeTypeField: volumeTypeImage,
+		},
+	}
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, status.Error(codes.InvalidArgument, "snapshot of image volume is not supported"), err)
+
+	delete(req.VolumeContext, volumeTypeField)
+	req.VolumeContext[snapshotField] = "invalid"
+	_, err = d.NodeStageVolume(context.Background(), req)
+	expectedErr := fmt.Errorf("snapshot volume is not supported on %s node", runtime.GOOS)
+	if runtime.GOOS == "linux" {
+		expectedErr = fmt.Errorf("invalid snapshot token %q, expected format: %s", "invalid", gmtTokenLayout)
+	}
+	assert.Equal(t, status.Error(codes.InvalidArgument, expectedErr.Error()), err)
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/volume"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+// placement policies of a source pool
+const (
+	placementRoundRobin    = "roundrobin"
+	placementNamespaceHash = "namespacehash"
+	placementMostFreeSpace = "mostfreespace"
+
+	sourceWeightKey = "weight"
+
+	placementResultSelected    = "selected"
+	placementResultUnreachable = "unreachable"
+)
+
+// poolSource is an entry of the sources parameter: //server/share[;weight=N][;key=value]...
+type poolSource struct {
+	source string
+	weight int
+	labels map[string]string
+}
+
+// parseSources parses comma separated pool sources, every source has weight 1 unless it's set
+func parseSources(value string) ([]poolSource, error) {
+	var sources []poolSource
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ";")
+		s := poolSource{source: strings.TrimRight(strings.TrimSpace(fields[0]), `/\`), weight: 1}
+		if !strings.HasPrefix(s.source, "//") && !strings.HasPrefix(s.source, `\\`) {
+			return nil, fmt.Errorf("source %q in %s should be in //server/share format", s.source, sourcesField)
+		}
+		if seen[s.source] {
+			return nil, fmt.Errorf("source %s is duplicated in %s", s.source, sourcesField)
+		}
+		seen[s.source] = true
+		for _, field := range fields[1:] {
+			k, v, found := strings.Cut(strings.TrimSpace(field), "=")
+			if !found || k == "" {
+				return nil, fmt.Errorf("option %q of source %s should be in key=value format", field, s.source)
+			}
+			if strings.EqualFold(k, sourceWeightKey) {
+				weight, err := strconv.Atoi(v)
+				if err != nil || weight <= 0 {
+					return nil, fmt.Errorf("weight %q of source %s should be a positive integer", v, s.source)
+				}
+				s.weight = weight
+				continue
+			}
+			if s.labels == nil {
+				s.labels = map[string]string{}
+			}
+			s.labels[k] = v
+		}
+		sources = append(sources, s)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s is empty", sourcesField)
+	}
+	return sources, nil
+}
+
+// getPoolSources returns the sources of the source pool in storage class parameters
+func getPoolSources(params map[string]string) []string {
+	var sources []string
+	for k, v := range params {
+		if !strings.EqualFold(k, sourcesField) {
+			continue
+		}
+		poolSources, err := parseSources(v)
+		if err != nil {
+			klog.Warningf("invalid %s %q: %v", sourcesField, v, err)
+			return nil
+		}
+		for _, s := range poolSources {
+			sources = append(sources, s.source)
+		}
+	}
+	return sources
+}
+
+// sourcePlacer keeps the state of round robin placement of source pools
+type sourcePlacer struct {
+	mu sync.Mutex
+	// next position of round robin <pool, position>
+	positions map[string]int
+}
+
+func newSourcePlacer() *sourcePlacer {
+	return &sourcePlacer{positions: map[string]int{}}
+}
+
+// order returns sources in the order of preference of policy, placement falls back to the next source
+// if a source is unreachable
+func (p *sourcePlacer) order(policy string, sources []poolSource, namespace string) []poolSource {
+	ordered := append([]poolSource(nil), sources...)
+	switch policy {
+	case placementRoundRobin:
+		// a source is picked weight times in a round
+		keys := make([]string, 0, len(sources))
+		total := 0
+		for _, s := range sources {
+			keys = append(keys, s.source)
+			total += s.weight
+		}
+		pool := strings.Join(keys, ",")
+		p.mu.Lock()
+		position := p.positions[pool] % total
+		p.positions[pool] = position + 1
+		p.mu.Unlock()
+		start := 0
+		for i, s := range sources {
+			if position < s.weight {
+				start = i
+				break
+			}
+			position -= s.weight
+		}
+		ordered = append(ordered[start:], ordered[:start]...)
+	case placementNamespaceHash:
+		// weighted rendezvous hashing, most namespaces stay on their source when the pool changes
+		scores := map[string]float64{}
+		for _, s := range sources {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(namespace + separator + s.source))
+			u := (float64(h.Sum64()>>11) + 0.5) / float64(1<<53)
+			scores[s.source] = float64(s.weight) / -math.Log(u)
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return scores[ordered[i].source] > scores[ordered[j].source]
+		})
+	}
+	return ordered
+}
+
+// placeVolume chooses a source of the pool in sources parameter by placement policy among the sources accessible
+// from the topology in requirement, sets it as source parameter and returns its accessible topology. It's a no-op
+// if sources is not set. A source which could not be mounted is skipped if secrets are provided, the chosen
+// source of a volume name is reused when CreateVolume is retried.
+func (d *Driver) placeVolume(ctx context.Context, name string, params map[string]string, secrets map[string]string,
+	volCap *csi.VolumeCapability, requirement *csi.TopologyRequirement) ([]*csi.Topology, error) {
+	var sourcesValue, policy, source, namespace string
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case sourcesField:
+			sourcesValue = v
+		case placementPolicyField:
+			policy = strings.ToLower(v)
+		case sourceField:
+			source = v
+		case pvcNamespaceKey:
+			namespace = v
+		}
+	}
+	if sourcesValue == "" {
+		if policy != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is set without %s", placementPolicyField, sourcesField)
+		}
+		return nil, nil
+	}
+	if source != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s and %s could not be set at the same time", sourceField, sourcesField)
+	}
+	sources, err := parseSources(sourcesValue)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	switch policy {
+	case "":
+		policy = placementRoundRobin
+	case placementRoundRobin, placementNamespaceHash:
+	case placementMostFreeSpace:
+		if len(secrets) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "provisioner secret is required by %s %s", placementPolicyField, policy)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %s, supported values: roundRobin, namespaceHash, mostFreeSpace", placementPolicyField, policy)
+	}
+
+	if sources, err = filterSourcesByTopology(sources, requirement); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if cached, err := d.placementCache.Get(name, azcache.CacheReadTypeDefault); err == nil && cached != nil {
+		for _, s := range sources {
+			if s.source == cached.(string) {
+				klog.V(2).Infof("CreateVolume(%s): reuse source %s placed before", name, s.source)
+				setKeyValueInMap(params, sourceField, s.source)
+				return getAccessibleTopology(s, requirement), nil
+			}
+		}
+	}
+
+	chosen, err := d.chooseSource(ctx, name, policy, d.sourcePlacer.order(policy, sources, namespace), secrets, volCap)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("CreateVolume(%s): place volume on source %s by %s %s", name, chosen.source, placementPolicyField, policy)
+	d.placementCache.Set(name, chosen.source)
+	setKeyValueInMap(params, sourceField, chosen.source)
+	return getAccessibleTopology(chosen, requirement), nil
+}
+
+// chooseSource returns the first reachable source in ordered sources, or the reachable source with the most
+// weighted free space if policy is mostFreeSpace. Sources are not probed without secrets.
+func (d *Driver) chooseSource(ctx context.Context, name, policy string, sources []poolSource, secrets map[string]string, volCap *csi.VolumeCapability) (poolSource, error) {
+	if len(secrets) == 0 {
+		recordPlacement(policy, sources[0].source, placementResultSelected)
+		return sources[0], nil
+	}
+	internalMountCap := getInternalMountVolCap(strings.Join(volCap.GetMount().GetMountFlags(), ","))
+	var chosen *poolSource
+	var maxFreeSpace float64
+	var errs []string
+	for _, s := range sources {
+		available, err := d.probeSource(ctx, name, s.source, internalMountCap, secrets)
+		if err != nil {
+			klog.Warningf("CreateVolume(%s): skip unreachable source %s: %v", name, s.source, err)
+			recordPlacement(policy, s.source, placementResultUnreachable)
+			errs = append(errs, fmt.Sprintf("%s: %v", s.source, err))
+			continue
+		}
+		if policy != placementMostFreeSpace {
+			chosen = &s
+			break
+		}
+		klog.V(4).Infof("CreateVolume(%s): source %s has %d bytes available, weight %d", name, s.source, available, s.weight)
+		if freeSpace := float64(available) * float64(s.weight); chosen == nil || freeSpace > maxFreeSpace {
+			chosen, maxFreeSpace = &s, freeSpace
+		}
+	}
+	if chosen == nil {
+		return poolSource{}, status.Errorf(codes.Unavailable, "no reachable source in %s: %s", sourcesField, strings.Join(errs, "; "))
+	}
+	recordPlacement(policy, chosen.source, placementResultSelected)
+	return *chosen, nil
+}
+
+// probeSource mounts source and returns its available bytes
+func (d *Driver) probeSource(ctx context.Context, name, source string, volCap *csi.VolumeCapability, secrets map[string]string) (int64, error) {
+	hash := sha256.Sum256([]byte(name + separator + source))
+	vol := &smbVolume{
+		id:     "placement#" + source,
+		source: source,
+		uuid:   "placement-" + hex.EncodeToString(hash[:4]),
+	}
+	if err := d.internalMount(ctx, vol, volCap, secrets); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := d.internalUnmount(ctx, vol); err != nil {
+			klog.Warningf("failed to unmount smb server: %v", err)
+		}
+	}()
+	metrics, err := volume.NewMetricsStatFS(getInternalMountPath(d.workingMountDir, vol)).GetMetrics()
+	if err != nil {
+		return 0, err
+	}
+	available, ok := metrics.Available.AsInt64()
+	if !ok {
+		return 0, fmt.Errorf("failed to get available bytes of %s", source)
+	}
+	return available, nil
+}
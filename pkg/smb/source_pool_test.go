@@ -0,0 +1,210 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		desc        string
+		value       string
+		expected    []poolSource
+		expectedErr bool
+	}{
+		{
+			desc:  "sources with weight and labels",
+			value: "//nas-1/share/;weight=2, //nas-2/share;tier=fast;zone=a",
+			expected: []poolSource{
+				{source: "//nas-1/share", weight: 2},
+				{source: "//nas-2/share", weight: 1, labels: map[string]string{"tier": "fast", "zone": "a"}},
+			},
+		},
+		{desc: "empty", value: " , ", expectedErr: true},
+		{desc: "invalid source", value: "nas-1/share", expectedErr: true},
+		{desc: "duplicated source", value: "//nas-1/share,//nas-1/share/", expectedErr: true},
+		{desc: "invalid weight", value: "//nas-1/share;weight=0", expectedErr: true},
+		{desc: "invalid option", value: "//nas-1/share;fast", expectedErr: true},
+	}
+	for _, test := range tests {
+		sources, err := parseSources(test.value)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, sources, test.desc)
+	}
+}
+
+func TestSourcePlacerOrder(t *testing.T) {
+	sources := []poolSource{{source: "//nas-1/share", weight: 2}, {source: "//nas-2/share", weight: 1}, {source: "//nas-3/share", weight: 1}}
+	p := newSourcePlacer()
+
+	var firsts []string
+	for i := 0; i < 8; i++ {
+		ordered := p.order(placementRoundRobin, sources, "")
+		assert.Len(t, ordered, len(sources))
+		firsts = append(firsts, ordered[0].source)
+	}
+	assert.Equal(t, []string{"//nas-1/share", "//nas-1/share", "//nas-2/share", "//nas-3/share",
+		"//nas-1/share", "//nas-1/share", "//nas-2/share", "//nas-3/share"}, firsts)
+	// sources after the chosen one are the fallbacks
+	p = newSourcePlacer()
+	p.order(placementRoundRobin, sources, "")
+	p.order(placementRoundRobin, sources, "")
+	assert.Equal(t, []poolSource{sources[1], sources[2], sources[0]}, p.order(placementRoundRobin, sources, ""))
+
+	// a namespace always gets the same order, namespaces are spread over sources
+	counts := map[string]int{}
+	for i := 0; i < 400; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		ordered := p.order(placementNamespaceHash, sources, namespace)
+		assert.Equal(t, ordered, p.order(placementNamespaceHash, sources, namespace))
+		counts[ordered[0].source]++
+	}
+	assert.Len(t, counts, 3)
+	assert.Greater(t, counts["//nas-1/share"], counts["//nas-2/share"])
+	assert.Greater(t, counts["//nas-1/share"], counts["//nas-3/share"])
+
+	// removing a source only moves the namespaces on it
+	for i := 0; i < 100; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		before := p.order(placementNamespaceHash, sources, namespace)[0]
+		after := p.order(placementNamespaceHash, sources[:2], namespace)[0]
+		if before.source != "//nas-3/share" {
+			assert.Equal(t, before, after, namespace)
+		}
+	}
+}
+
+func TestPlaceVolume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip on windows")
+	}
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	volCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	secrets := map[string]string{usernameField: "test", passwordField: "test"}
+
+	tests := []struct {
+		desc         string
+		name         string
+		params       map[string]string
+		secrets      map[string]string
+		expected     string
+		expectedCode codes.Code
+	}{
+		{
+			desc:     "no source pool",
+			name:     "pv-1",
+			params:   map[string]string{sourceField: "//nas-1/share"},
+			expected: "//nas-1/share",
+		},
+		{
+			desc:     "unreachable source is skipped",
+			name:     "pv-2",
+			params:   map[string]string{"Sources": "//error_mount_sens/share,//nas-2/share", "placementPolicy": "roundRobin"},
+			secrets:  secrets,
+			expected: "//nas-2/share",
+		},
+		{
+			desc:     "most free space is weighted",
+			name:     "pv-3",
+			params:   map[string]string{sourcesField: "//nas-1/share,//nas-2/share;weight=3", placementPolicyField: "mostFreeSpace"},
+			secrets:  secrets,
+			expected: "//nas-2/share",
+		},
+		{
+			desc:     "sources are not probed without secrets",
+			name:     "pv-4",
+			params:   map[string]string{sourcesField: "//error_mount_sens/share", pvcNamespaceKey: "default", placementPolicyField: "namespaceHash"},
+			expected: "//error_mount_sens/share",
+		},
+		{
+			desc:         "no reachable source",
+			name:         "pv-5",
+			params:       map[string]string{sourcesField: "//error_mount_sens/share-1,//error_mount_sens/share-2"},
+			secrets:      secrets,
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:         "most free space requires secrets",
+			name:         "pv-6",
+			params:       map[string]string{sourcesField: "//nas-1/share", placementPolicyField: "mostFreeSpace"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "source and sources",
+			name:         "pv-7",
+			params:       map[string]string{sourceField: "//nas-1/share", sourcesField: "//nas-1/share"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid placement policy",
+			name:         "pv-8",
+			params:       map[string]string{sourcesField: "//nas-1/share", placementPolicyField: "random"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "placement policy without sources",
+			name:         "pv-9",
+			params:       map[string]string{sourceField: "//nas-1/share", placementPolicyField: "roundRobin"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		_, err := d.placeVolume(context.Background(), test.name, test.params, test.secrets, volCap, nil)
+		if test.expectedCode != codes.OK {
+			assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		var source string
+		for k, v := range test.params {
+			if strings.EqualFold(k, sourceField) {
+				source = v
+			}
+		}
+		assert.Equal(t, test.expected, source, test.desc)
+	}
+
+	// retried CreateVolume reuses the source placed before
+	params := map[string]string{sourcesField: "//nas-1/share,//nas-2/share"}
+	_, err = d.placeVolume(context.Background(), "pv-3", params, nil, volCap, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "//nas-2/share", params[sourceField])
+	params = map[string]string{sourcesField: "//nas-1/share,//nas-2/share"}
+	_, err = d.placeVolume(context.Background(), "pv-10", params, nil, volCap, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "//nas-1/share", params[sourceField])
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// template variables in subDir, sharePath, shareValidUsers and restoreFromArchive which are rendered in CreateVolume,
+// in addition to pv/pvc name namespace metadata
+const (
+	pvcLabelsMetadataPrefix      = "pvc.metadata.labels."
+	pvcAnnotationsMetadataPrefix = "pvc.metadata.annotations."
+	storageClassNameMetadata     = "storageclass.metadata.name"
+	dateTemplatePrefix           = "date:"
+	hashTemplatePrefix           = "hash:"
+	// max number of hex characters of ${hash:N}
+	maxHashTemplateLength = 16
+)
+
+var templateVariableRegex = regexp.MustCompile(`\$\{([^{}]*)\}`)
+
+// timeNow returns the current time, it is replaced in tests
+var timeNow = time.Now
+
+// isPVCTemplate returns true if the variable requires the PVC object
+func isPVCTemplate(variable string) bool {
+	return strings.HasPrefix(variable, pvcLabelsMetadataPrefix) ||
+		strings.HasPrefix(variable, pvcAnnotationsMetadataPrefix) ||
+		strings.HasPrefix(variable, dateTemplatePrefix) ||
+		variable == storageClassNameMetadata
+}
+
+// hasExtendedTemplate returns true if str has a variable other than pv/pvc name namespace metadata,
+// such variables could only be rendered in CreateVolume
+func hasExtendedTemplate(str string) bool {
+	for _, m := range templateVariableRegex.FindAllStringSubmatch(str, -1) {
+		v := m[1]
+		if isPVCTemplate(v) || strings.HasPrefix(v, hashTemplatePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTemplate replaces pv/pvc name namespace metadata in str with replaceMap, and renders:
+//   - ${pvc.metadata.labels.<key>} and ${pvc.metadata.annotations.<key>} with the label or annotation of pvc
+//   - ${storageclass.metadata.name} with the storage class name of pvc
+//   - ${date:<layout>} with the creation time of pvc in Go time layout, e.g. ${date:2006/01}
+//   - ${hash:<N>} with the first N hex characters of sha256 hash of pvName, e.g. ${hash:2}
+//
+// Unknown variables are kept as is. Every rendered value is validated with validatePath.
+func renderTemplate(str string, replaceMap map[string]string, pvName string, pvc *v1.PersistentVolumeClaim) (string, error) {
+	var renderErr error
+	rendered := templateVariableRegex.ReplaceAllStringFunc(str, func(match string) string {
+		if renderErr != nil {
+			return match
+		}
+		value, err := renderTemplateVariable(match, replaceMap, pvName, pvc)
+		if err == nil {
+			err = validatePath(value)
+		}
+		if err != nil {
+			renderErr = fmt.Errorf("failed to render %s in %q: %v", match, str, err)
+			return match
+		}
+		return value
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return rendered, nil
+}
+
+func renderTemplateVariable(match string, replaceMap map[string]string, pvName string, pvc *v1.PersistentVolumeClaim) (string, error) {
+	if value, ok := replaceMap[match]; ok {
+		return value, nil
+	}
+	variable := strings.TrimSuffix(strings.TrimPrefix(match, "${"), "}")
+	if isPVCTemplate(variable) && pvc == nil {
+		return "", fmt.Errorf("PVC is not available, enable --extra-create-metadata in csi-provisioner")
+	}
+	switch {
+	case strings.HasPrefix(variable, pvcLabelsMetadataPrefix):
+		key := strings.TrimPrefix(variable, pvcLabelsMetadataPrefix)
+		value := pvc.Labels[key]
+		if value == "" {
+			return "", fmt.Errorf("label %s is not set on PVC %s/%s", key, pvc.Namespace, pvc.Name)
+		}
+		return value, nil
+	case strings.HasPrefix(variable, pvcAnnotationsMetadataPrefix):
+		key := strings.TrimPrefix(variable, pvcAnnotationsMetadataPrefix)
+		value := pvc.Annotations[key]
+		if value == "" {
+			return "", fmt.Errorf("annotation %s is not set on PVC %s/%s", key, pvc.Namespace, pvc.Name)
+		}
+		return value, nil
+	case variable == storageClassNameMetadata:
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+			return "", fmt.Errorf("storage class name is not set on PVC %s/%s", pvc.Namespace, pvc.Name)
+		}
+		return *pvc.Spec.StorageClassName, nil
+	case strings.HasPrefix(variable, dateTemplatePrefix):
+		layout := strings.TrimPrefix(variable, dateTemplatePrefix)
+		if layout == "" {
+			return "", fmt.Errorf("date layout is empty")
+		}
+		// use PVC creation time so that a retried CreateVolume gets the same directory
+		if pvc.CreationTimestamp.IsZero() {
+			return "", fmt.Errorf("creation time is not set on PVC %s/%s", pvc.Namespace, pvc.Name)
+		}
+		return pvc.CreationTimestamp.UTC().Format(layout), nil
+	case strings.HasPrefix(variable, hashTemplatePrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(variable, hashTemplatePrefix))
+		if err != nil || n < 1 || n > maxHashTemplateLength {
+			return "", fmt.Errorf("hash length should be an integer between 1 and %d", maxHashTemplateLength)
+		}
+		hash := sha256.Sum256([]byte(pvName))
+		return hex.EncodeToString(hash[:])[:n], nil
+	}
+	return match, nil
+}
+
+// getPVC returns the PVC referenced by params if a template or PVC override in params requires it, and nil otherwise
+func (d *Driver) getPVC(ctx context.Context, params map[string]string) (*v1.PersistentVolumeClaim, error) {
+	var pvcName, pvcNamespace string
+	var requirePVC bool
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case pvcNameKey:
+			pvcName = v
+		case pvcNamespaceKey:
+			pvcNamespace = v
+		case subDirField, sharePathField, shareValidUsersField, restoreFromArchiveField:
+			for _, m := range templateVariableRegex.FindAllStringSubmatch(v, -1) {
+				requirePVC = requirePVC || isPVCTemplate(m[1])
+			}
+		case pvcMountOptionOverridesField:
+			requirePVC = requirePVC || v != ""
+		case pvcSubDirOverrideField:
+			requirePVC = requirePVC || strings.EqualFold(v, trueValue)
+		}
+	}
+	if !requirePVC {
+		return nil, nil
+	}
+	if pvcName == "" || pvcNamespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s and %s are required in parameters to get PVC, enable --extra-create-metadata in csi-provisioner", pvcNameKey, pvcNamespaceKey)
+	}
+	if d.kubeClient == nil {
+		return nil, status.Errorf(codes.Internal, "could not get PVC %s/%s: KubeClient is nil", pvcNamespace, pvcName)
+	}
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get PVC %s/%s: %v", pvcNamespace, pvcName, err)
+	}
+	klog.V(4).Infof("got PVC %s/%s to render templates and overrides", pvcNamespace, pvcName)
+	return pvc, nil
+}
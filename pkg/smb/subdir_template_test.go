@@ -0,0 +1,190 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTemplatePVC() *v1.PersistentVolumeClaim {
+	storageClassName := "smb"
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pvc-name",
+			Namespace:         "pvc-namespace",
+			CreationTimestamp: metav1.NewTime(time.Date(2026, 3, 5, 10, 0, 0, 0, time.UTC)),
+			Labels: map[string]string{
+				"team":                   "payments",
+				"app.kubernetes.io/name": "billing",
+				"env":                    "prod",
+				"bad":                    "..",
+			},
+			Annotations: map[string]string{"example.com/cost-center": "cc-42"},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName},
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	replaceMap := map[string]string{
+		pvcNameMetadata:      "pvc-name",
+		pvcNamespaceMetadata: "pvc-namespace",
+		pvNameMetadata:       "pv-name",
+	}
+	pvc := newTemplatePVC()
+	tests := []struct {
+		desc      string
+		str       string
+		pvc       *v1.PersistentVolumeClaim
+		expected  string
+		expectErr bool
+	}{
+		{
+			desc:     "pv/pvc name namespace metadata",
+			str:      "${pvc.metadata.namespace}/${pvc.metadata.name}/${pv.metadata.name}",
+			expected: "pvc-namespace/pvc-name/pv-name",
+		},
+		{
+			desc:     "labels",
+			str:      "${pvc.metadata.labels.team}/${pvc.metadata.labels.app.kubernetes.io/name}/${pvc.metadata.labels.env}",
+			pvc:      pvc,
+			expected: "payments/billing/prod",
+		},
+		{
+			desc:     "annotation and storage class",
+			str:      "${storageclass.metadata.name}/${pvc.metadata.annotations.example.com/cost-center}",
+			pvc:      pvc,
+			expected: "smb/cc-42",
+		},
+		{
+			desc:     "date of PVC creation",
+			str:      "${date:2006/01}/${pv.metadata.name}",
+			pvc:      pvc,
+			expected: "2026/03/pv-name",
+		},
+		{
+			desc:     "hash",
+			str:      "${hash:2}/${pv.metadata.name}",
+			expected: "a0/pv-name",
+		},
+		{
+			desc:     "unknown variable is kept",
+			str:      "${unknown}/${pv.metadata.name}",
+			expected: "${unknown}/pv-name",
+		},
+		{
+			desc:      "missing label",
+			str:       "${pvc.metadata.labels.owner}",
+			pvc:       pvc,
+			expectErr: true,
+		},
+		{
+			desc:      "label with directory traversal",
+			str:       "${pvc.metadata.labels.bad}/pv-name",
+			pvc:       pvc,
+			expectErr: true,
+		},
+		{
+			desc:      "PVC is not available",
+			str:       "${pvc.metadata.labels.team}",
+			expectErr: true,
+		},
+		{
+			desc:      "invalid hash length",
+			str:       "${hash:0}",
+			expectErr: true,
+		},
+		{
+			desc:      "date without PVC",
+			str:       "${date:2006-01-02}",
+			expectErr: true,
+		},
+		{
+			desc:      "date without PVC creation time",
+			str:       "${date:2006-01-02}",
+			pvc:       &v1.PersistentVolumeClaim{},
+			expectErr: true,
+		},
+		{
+			desc:      "empty date layout",
+			str:       "${date:}",
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		result, err := renderTemplate(test.str, replaceMap, "pv-name", test.pvc)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestHasExtendedTemplate(t *testing.T) {
+	assert.False(t, hasExtendedTemplate("subdir"))
+	assert.False(t, hasExtendedTemplate("${pvc.metadata.namespace}/${pv.metadata.name}"))
+	assert.True(t, hasExtendedTemplate("${pvc.metadata.labels.team}/${pv.metadata.name}"))
+	assert.True(t, hasExtendedTemplate("${date:2006}"))
+	assert.True(t, hasExtendedTemplate("${hash:2}"))
+	assert.True(t, hasExtendedTemplate("${storageclass.metadata.name}"))
+}
+
+func TestGetPVC(t *testing.T) {
+	d := NewFakeDriver()
+	params := map[string]string{
+		sourceField: "//smb-server/share",
+		"subDir":    "${pvc.metadata.labels.team}/${pv.metadata.name}",
+	}
+	_, err := d.getPVC(context.Background(), params)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	params[pvcNameKey] = "pvc-name"
+	params[pvcNamespaceKey] = "pvc-namespace"
+	_, err = d.getPVC(context.Background(), params)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	d.kubeClient = fake.NewSimpleClientset(newTemplatePVC())
+	pvc, err := d.getPVC(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", pvc.Labels["team"])
+
+	params[pvNameKey] = "pv-name"
+	vol, err := newSMBVolume("pv-name", 100, params, "", pvc)
+	assert.NoError(t, err)
+	assert.Equal(t, "payments/pv-name", vol.subDir)
+	assert.Equal(t, "smb-server/share#payments/pv-name#pv-name#", vol.id)
+
+	// date requires PVC creation time
+	params["subDir"] = "${date:2006}/${pv.metadata.name}"
+	d.kubeClient = fake.NewSimpleClientset()
+	_, err = d.getPVC(context.Background(), params)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// PVC is not required without PVC templates
+	params["subDir"] = "${hash:2}/${pv.metadata.name}"
+	d.kubeClient = nil
+	pvc, err = d.getPVC(context.Background(), params)
+	assert.NoError(t, err)
+	assert.Nil(t, pvc)
+}
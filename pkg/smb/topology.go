@@ -0,0 +1,121 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// topologyEnabled returns true if the driver reports topology of nodes and provisions volumes by topology
+func (d *Driver) topologyEnabled() bool {
+	return len(d.topologyKeys) > 0 || len(d.topologySegments) > 0
+}
+
+// getNodeTopology returns the topology segments of the node: segments set by flag, and values of
+// topology keys in node labels
+func (d *Driver) getNodeTopology(ctx context.Context) (map[string]string, error) {
+	segments := map[string]string{}
+	for k, v := range d.topologySegments {
+		segments[k] = v
+	}
+	if len(d.topologyKeys) == 0 {
+		return segments, nil
+	}
+	if d.kubeClient == nil {
+		return nil, fmt.Errorf("could not get labels of node %s: KubeClient is nil", d.NodeID)
+	}
+	node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %v", d.NodeID, err)
+	}
+	for _, key := range d.topologyKeys {
+		if _, ok := segments[key]; ok {
+			continue
+		}
+		value, ok := node.Labels[key]
+		if !ok {
+			klog.Warningf("topology key %s is not found in labels of node %s", key, d.NodeID)
+			continue
+		}
+		segments[key] = value
+	}
+	return segments, nil
+}
+
+// sourceMatchesTopology returns true if the labels of source do not conflict with topology segments,
+// a source without topology labels is accessible from any topology
+func sourceMatchesTopology(source poolSource, topology *csi.Topology) bool {
+	for k, v := range topology.GetSegments() {
+		if label, ok := source.labels[k]; ok && label != v {
+			return false
+		}
+	}
+	return true
+}
+
+// filterSourcesByTopology returns the sources matching the first topology of accessibility requirement
+// which has matching sources, preferred topologies are tried before requisite ones. Sources are not
+// filtered if requirement is empty.
+func filterSourcesByTopology(sources []poolSource, requirement *csi.TopologyRequirement) ([]poolSource, error) {
+	topologies := append(append([]*csi.Topology{}, requirement.GetPreferred()...), requirement.GetRequisite()...)
+	if len(topologies) == 0 {
+		return sources, nil
+	}
+	for _, topology := range topologies {
+		var matched []poolSource
+		for _, s := range sources {
+			if sourceMatchesTopology(s, topology) {
+				matched = append(matched, s)
+			}
+		}
+		if len(matched) > 0 {
+			return matched, nil
+		}
+	}
+	return nil, fmt.Errorf("no source in %s is accessible from topology %s", sourcesField, formatTopologies(topologies))
+}
+
+// getAccessibleTopology returns the topology of source with the topology keys in accessibility requirement,
+// nil means the source is accessible from any topology
+func getAccessibleTopology(source poolSource, requirement *csi.TopologyRequirement) []*csi.Topology {
+	segments := map[string]string{}
+	for _, topology := range append(append([]*csi.Topology{}, requirement.GetPreferred()...), requirement.GetRequisite()...) {
+		for k := range topology.GetSegments() {
+			if label, ok := source.labels[k]; ok {
+				segments[k] = label
+			}
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	return []*csi.Topology{{Segments: segments}}
+}
+
+func formatTopologies(topologies []*csi.Topology) string {
+	formatted := make([]string, 0, len(topologies))
+	for _, topology := range topologies {
+		formatted = append(formatted, fmt.Sprintf("%v", topology.GetSegments()))
+	}
+	return strings.Join(formatted, ", ")
+}
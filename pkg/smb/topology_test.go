@@ -0,0 +1,160 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const zoneKey = "topology.kubernetes.io/zone"
+
+func TestGetNodeTopology(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID, Labels: map[string]string{zoneKey: "zone-a", "site": "dc1"}},
+	})
+
+	d.topologyKeys = []string{zoneKey, "rack"}
+	segments, err := d.getNodeTopology(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{zoneKey: "zone-a"}, segments)
+
+	// segments set by flag take precedence over node labels
+	d.topologySegments = map[string]string{zoneKey: "zone-b", "site": "dc2"}
+	segments, err = d.getNodeTopology(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{zoneKey: "zone-b", "site": "dc2"}, segments)
+
+	d.NodeID = "unknown-node"
+	_, err = d.getNodeTopology(context.Background())
+	assert.Error(t, err)
+
+	d.topologyKeys = nil
+	segments, err = d.getNodeTopology(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{zoneKey: "zone-b", "site": "dc2"}, segments)
+}
+
+func TestFilterSourcesByTopology(t *testing.T) {
+	zoneA := poolSource{source: "//nas-a/share", weight: 1, labels: map[string]string{zoneKey: "zone-a", "tier": "fast"}}
+	zoneB := poolSource{source: "//nas-b/share", weight: 1, labels: map[string]string{zoneKey: "zone-b"}}
+	anyZone := poolSource{source: "//nas-global/share", weight: 1}
+	topology := func(zone string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{zoneKey: zone}}
+	}
+
+	tests := []struct {
+		desc             string
+		sources          []poolSource
+		requirement      *csi.TopologyRequirement
+		expected         []poolSource
+		expectedTopology []*csi.Topology
+		expectedErr      bool
+	}{
+		{
+			desc:     "no requirement",
+			sources:  []poolSource{zoneA, zoneB},
+			expected: []poolSource{zoneA, zoneB},
+		},
+		{
+			desc:             "preferred topology",
+			sources:          []poolSource{zoneA, zoneB},
+			requirement:      &csi.TopologyRequirement{Preferred: []*csi.Topology{topology("zone-b")}, Requisite: []*csi.Topology{topology("zone-a"), topology("zone-b")}},
+			expected:         []poolSource{zoneB},
+			expectedTopology: []*csi.Topology{topology("zone-b")},
+		},
+		{
+			desc:             "requisite topology when preferred topology has no source",
+			sources:          []poolSource{zoneA, zoneB},
+			requirement:      &csi.TopologyRequirement{Preferred: []*csi.Topology{topology("zone-c")}, Requisite: []*csi.Topology{topology("zone-c"), topology("zone-a")}},
+			expected:         []poolSource{zoneA},
+			expectedTopology: []*csi.Topology{topology("zone-a")},
+		},
+		{
+			desc:        "source without topology labels is accessible from any topology",
+			sources:     []poolSource{zoneA, anyZone},
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{topology("zone-c")}},
+			expected:    []poolSource{anyZone},
+		},
+		{
+			desc:        "no accessible source",
+			sources:     []poolSource{zoneA, zoneB},
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{topology("zone-c")}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		sources, err := filterSourcesByTopology(test.sources, test.requirement)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, sources, test.desc)
+		assert.Equal(t, test.expectedTopology, getAccessibleTopology(sources[0], test.requirement), test.desc)
+	}
+}
+
+func TestPlaceVolumeByTopology(t *testing.T) {
+	d := NewFakeDriver()
+	volCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	sources := "//nas-a/share;topology.kubernetes.io/zone=zone-a,//nas-b/share;topology.kubernetes.io/zone=zone-b"
+	requirement := &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{zoneKey: "zone-b"}}},
+		Requisite: []*csi.Topology{{Segments: map[string]string{zoneKey: "zone-a"}}, {Segments: map[string]string{zoneKey: "zone-b"}}},
+	}
+
+	params := map[string]string{sourcesField: sources}
+	topology, err := d.placeVolume(context.Background(), "pv-1", params, nil, volCap, requirement)
+	assert.NoError(t, err)
+	assert.Equal(t, "//nas-b/share", params[sourceField])
+	assert.Equal(t, []*csi.Topology{{Segments: map[string]string{zoneKey: "zone-b"}}}, topology)
+
+	params = map[string]string{sourcesField: sources}
+	_, err = d.placeVolume(context.Background(), "pv-2", params, nil, volCap, &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{{Segments: map[string]string{zoneKey: "zone-c"}}},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestTopologyCapabilities(t *testing.T) {
+	d := NewFakeDriver()
+	d.topologySegments = map[string]string{zoneKey: "zone-a"}
+
+	resp, err := d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Capabilities, 2)
+	assert.Equal(t, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS, resp.Capabilities[1].GetService().GetType())
+
+	info, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.Topology{Segments: map[string]string{zoneKey: "zone-a"}}, info.GetAccessibleTopology())
+
+	d.topologyKeys = []string{zoneKey}
+	d.kubeClient = fake.NewSimpleClientset()
+	_, err = d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// hidden directory at the root of a share where deleted volumes are moved to, it's emptied in background
+	trashDir = ".csi-smb-trash"
+	// interval of scanning storage classes for shares with trash left by a previous controller
+	trashScanInterval = time.Hour
+)
+
+// trashDeleter empties the trash of shares in background, the queue is keyed by share source so that
+// a share is only emptied by one worker at a time
+type trashDeleter struct {
+	d     *Driver
+	queue workqueue.TypedRateLimitingInterface[string]
+	mu    sync.Mutex
+	// secrets to mount a share <source, secrets>
+	secrets map[string]map[string]string
+	// mount options of a share <source, mount options>
+	mountOptions map[string]string
+}
+
+func newTrashDeleter(d *Driver) *trashDeleter {
+	return &trashDeleter{
+		d: d,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Second, 5*time.Minute),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "trash"},
+		),
+		secrets:      map[string]map[string]string{},
+		mountOptions: map[string]string{},
+	}
+}
+
+// add queues the share source to be emptied with mount options and secrets
+func (t *trashDeleter) add(source, mountOptions string, secrets map[string]string) {
+	t.mu.Lock()
+	t.secrets[source] = secrets
+	t.mountOptions[source] = mountOptions
+	t.mu.Unlock()
+	t.queue.Add(source)
+}
+
+func (t *trashDeleter) getSecrets(source string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.secrets[source]
+}
+
+func (t *trashDeleter) getMountOptions(source string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mountOptions[source]
+}
+
+// run starts workers to empty trash and scans storage classes for trash periodically until ctx is done
+func (t *trashDeleter) run(ctx context.Context, workers int) {
+	klog.V(2).Infof("asynchronous volume deletion is enabled, workers: %d", workers)
+	defer t.queue.ShutDown()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for t.processNext(ctx) {
+			}
+		}()
+	}
+	ticker := time.NewTicker(trashScanInterval)
+	defer ticker.Stop()
+	for {
+		t.scanStorageClasses(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanStorageClasses queues the shares of the storage classes of this driver, so that trash left by
+// a previous controller is emptied
+func (t *trashDeleter) scanStorageClasses(ctx context.Context) {
+	if t.d.kubeClient == nil {
+		klog.Warningf("could not scan storage classes for trash: KubeClient is nil")
+		return
+	}
+	scs, err := t.d.kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("failed to list storage classes for trash: %v", err)
+		return
+	}
+	for _, sc := range scs.Items {
+		if sc.Provisioner != t.d.Name {
+			continue
+		}
+		source, secretName, secretNamespace := getProvisionerShare(sc.Parameters)
+		sources := []string{source}
+		if source == "" {
+			sources = getPoolSources(sc.Parameters)
+		}
+		if len(sources) == 0 || secretName == "" || secretNamespace == "" || strings.Contains(secretName+secretNamespace, "${") {
+			continue
+		}
+		secrets, err := t.d.getSecretData(ctx, secretName, secretNamespace)
+		if err != nil {
+			klog.Warningf("skip trash of storage class %s: %v", sc.Name, err)
+			continue
+		}
+		mountOptions := sc.MountOptions
+		if secretOptions := getMountOptions(secrets); secretOptions != "" {
+			mountOptions = mergeMountOptions(mountOptions, splitMountOptions(secretOptions))
+		}
+		for _, source := range sources {
+			t.add(source, strings.Join(mountOptions, ","), secrets)
+		}
+	}
+}
+
+func (t *trashDeleter) processNext(ctx context.Context) bool {
+	source, shutdown := t.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer t.queue.Done(source)
+	if err := t.d.emptyShareTrash(ctx, source, t.getMountOptions(source), t.getSecrets(source)); err != nil {
+		klog.Errorf("failed to empty trash on %s, retries: %d: %v", source, t.queue.NumRequeues(source), err)
+		t.queue.AddRateLimited(source)
+		return true
+	}
+	t.queue.Forget(source)
+	return true
+}
+
+// getProvisionerShare returns source and provisioner secret in storage class parameters
+func getProvisionerShare(params map[string]string) (source, secretName, secretNamespace string) {
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case sourceField:
+			source = strings.TrimRight(v, `/\`)
+		case provisionerSecretNameKey:
+			secretName = v
+		case provisionerSecretNamespaceKey:
+			secretNamespace = v
+		}
+	}
+	return source, secretName, secretNamespace
+}
+
+// moveToTrash renames volumePath to the trash under mountPath, the name in trash is unique so that volumes
+// with the same subDir could be deleted before the trash is emptied
+func moveToTrash(volumePath, mountPath, volumeID string) error {
+	trashPath := filepath.Join(mountPath, trashDir)
+	if err := os.MkdirAll(trashPath, 0777); err != nil {
+		return fmt.Errorf("create trash directory %s failed: %v", trashPath, err)
+	}
+	hash := sha256.Sum256([]byte(volumeID))
+	name := hex.EncodeToString(hash[:8]) + "-" + strconv.FormatInt(timeNow().UnixNano(), 10)
+	klog.V(2).Infof("moving subdirectory %s to trash %s", volumePath, name)
+	return os.Rename(volumePath, filepath.Join(trashPath, name))
+}
+
+// emptyShareTrash mounts the share with mountOptions and removes everything in its trash
+func (d *Driver) emptyShareTrash(ctx context.Context, source, mountOptions string, secrets map[string]string) error {
+	hash := sha256.Sum256([]byte(source))
+	vol := &smbVolume{
+		id:     "trash#" + source,
+		source: source,
+		uuid:   "trash-" + hex.EncodeToString(hash[:4]),
+	}
+	if err := d.internalMount(ctx, vol, getInternalMountVolCap(mountOptions), secrets); err != nil {
+		return fmt.Errorf("failed to mount smb server: %v", err)
+	}
+	defer func() {
+		if err := d.internalUnmount(ctx, vol); err != nil {
+			klog.Warningf("failed to unmount smb server: %v", err)
+		}
+	}()
+
+	// a delete slot of the server is held per trash entry, so that DeleteVolume is not blocked by a long emptying
+	return emptyTrash(filepath.Join(getInternalMountPath(d.workingMountDir, vol), trashDir), func() (func(), error) {
+		return d.controllerOpLimiter.Acquire(ctx, source, serverOpDelete)
+	})
+}
+
+// emptyTrash removes the directories in trashPath one by one, acquire is called before removing each directory
+// and the returned release func is called after it's removed. It's a no-op if trashPath does not exist.
+func emptyTrash(trashPath string, acquire func() (func(), error)) error {
+	entries, err := os.ReadDir(trashPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		release, err := acquire()
+		if err != nil {
+			return err
+		}
+		err = removeTrashEntry(filepath.Join(trashPath, entry.Name()))
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTrashEntry removes path in trash including read-only files and directories
+func removeTrashEntry(path string) error {
+	start := time.Now()
+	klog.V(2).Infof("removing %s in trash", path)
+	if err := filepath.WalkDir(path, func(p string, _ fs.DirEntry, _ error) error {
+		return os.Chmod(p, 0777)
+	}); err != nil {
+		klog.Errorf("failed to chmod %s: %v", path, err)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	klog.V(2).Infof("removed %s in trash in %v", path, time.Since(start))
+	return nil
+}
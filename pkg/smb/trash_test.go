@@ -0,0 +1,138 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMoveToTrashAndEmptyTrash(t *testing.T) {
+	mountPath := t.TempDir()
+	trashPath := filepath.Join(mountPath, trashDir)
+	var acquired, held int
+	acquire := func() (func(), error) {
+		assert.Zero(t, held, "slot is released before next trash entry")
+		acquired++
+		held++
+		return func() { held-- }, nil
+	}
+	assert.NoError(t, emptyTrash(trashPath, acquire))
+
+	for i, subDir := range []string{"a/pvc-1", "pvc-2"} {
+		volumePath := filepath.Join(mountPath, subDir)
+		assert.NoError(t, os.MkdirAll(filepath.Join(volumePath, "dir"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(volumePath, "dir", "file"), []byte("data"), 0444))
+		assert.NoError(t, os.Chmod(filepath.Join(volumePath, "dir"), 0555))
+
+		ts := time.Date(2026, 10, 18, 0, 0, i, 0, time.UTC)
+		timeNow = func() time.Time { return ts }
+		assert.NoError(t, moveToTrash(volumePath, mountPath, "smb-server/share#"+subDir+"##"))
+		_, err := os.Stat(volumePath)
+		assert.True(t, os.IsNotExist(err))
+	}
+	timeNow = time.Now
+	entries, err := os.ReadDir(trashPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.NoError(t, emptyTrash(trashPath, acquire))
+	entries, err = os.ReadDir(trashPath)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	// a slot is acquired per trash entry
+	assert.Equal(t, 2, acquired)
+	assert.Zero(t, held)
+}
+
+func TestGetProvisionerShare(t *testing.T) {
+	source, secretName, secretNamespace := getProvisionerShare(map[string]string{
+		"Source":                      "//smb-server/share/",
+		provisionerSecretNameKey:      "smbcreds",
+		provisionerSecretNamespaceKey: "default",
+		"subDir":                      "pvc",
+	})
+	assert.Equal(t, "//smb-server/share", source)
+	assert.Equal(t, "smbcreds", secretName)
+	assert.Equal(t, "default", secretNamespace)
+}
+
+func TestTrashDeleterScanStorageClasses(t *testing.T) {
+	d := NewFakeDriver()
+	trash := newTrashDeleter(d)
+	defer trash.queue.ShutDown()
+
+	secretParams := map[string]string{"source": "//smb-server/share", provisionerSecretNameKey: "smbcreds", provisionerSecretNamespaceKey: "default"}
+	mountOptions := []string{"vers=3.0", "sec=ntlmssp"}
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "smbcreds", Namespace: "default"}, Data: map[string][]byte{usernameField: []byte("user")}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-1"}, Provisioner: d.Name, Parameters: secretParams, MountOptions: mountOptions},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-2"}, Provisioner: d.Name, Parameters: secretParams, MountOptions: mountOptions},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-3"}, Provisioner: d.Name, Parameters: map[string]string{"source": "//smb-server/nosecret"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-pool"}, Provisioner: d.Name, Parameters: map[string]string{"sources": "//nas-1/share,//nas-2/share;weight=2",
+			provisionerSecretNameKey: "smbcreds", provisionerSecretNamespaceKey: "default"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-4"}, Provisioner: "other.csi.k8s.io", Parameters: map[string]string{"source": "//smb-server/foreign",
+			provisionerSecretNameKey: "smbcreds", provisionerSecretNamespaceKey: "default"}},
+	)
+	trash.scanStorageClasses(context.Background())
+	assert.Equal(t, 3, trash.queue.Len())
+	assert.Equal(t, map[string]string{usernameField: "user"}, trash.getSecrets("//nas-2/share"))
+	assert.Equal(t, map[string]string{usernameField: "user"}, trash.getSecrets("//smb-server/share"))
+	assert.Equal(t, "vers=3.0,sec=ntlmssp", trash.getMountOptions("//smb-server/share"))
+}
+
+func TestDeleteVolumeMovesToTrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip on windows")
+	}
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	d.trashDeleter = newTrashDeleter(d)
+	defer d.trashDeleter.queue.ShutDown()
+
+	volumeID := "smb-server/share#pvc-1#pv-name#"
+	volumePath := filepath.Join(d.workingMountDir, "pv-name", "pvc-1")
+	assert.NoError(t, os.MkdirAll(volumePath, 0755))
+	assert.NoError(t, writeVolumeMarker(volumePath, volumeID, "pv-name", false))
+	secrets := map[string]string{usernameField: "test", passwordField: "test"}
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+	assert.NoError(t, err)
+
+	_, err = os.Stat(volumePath)
+	assert.True(t, os.IsNotExist(err))
+	entries, err := os.ReadDir(filepath.Join(d.workingMountDir, "pv-name", trashDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, d.trashDeleter.queue.Len())
+	assert.Equal(t, secrets, d.trashDeleter.getSecrets("//smb-server/share"))
+}
@@ -17,42 +17,264 @@ limitations under the License.
 package smb
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 
-	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
 )
 
 const (
 	volumeOperationAlreadyExistsFmt = "An operation with the given Volume ID %s already exists"
 )
 
+// lockToken identifies a holder of a volume lock, it's returned when the lock is acquired and
+// passed to Release, so that a holder never releases the lock granted to another operation
+type lockToken uint64
+
+// lockInfo records who holds a volume lock
+type lockInfo struct {
+	token      lockToken
+	Key        string    `json:"key"`
+	Owner      string    `json:"owner"`
+	Shared     bool      `json:"shared,omitempty"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	// number of operations waiting for this key
+	Waiters int `json:"waiters,omitempty"`
+}
+
+// lockWaiter is an operation queued for a key
+type lockWaiter struct {
+	owner   string
+	shared  bool
+	ready   chan struct{}
+	granted bool
+	token   lockToken
+}
+
+// lockEntry is the state of a single key, holders are either one exclusive
+// holder or any number of shared holders.
+type lockEntry struct {
+	holders []lockInfo
+	waiters []*lockWaiter
+}
+
+func (e *lockEntry) exclusive() bool {
+	return len(e.holders) == 1 && !e.holders[0].Shared
+}
+
 // VolumeLocks implements a map with atomic operations. It stores a set of all volume IDs
 // with an ongoing operation.
+// Operations on the same key are granted in FIFO order, an operation waits up to
+// waitTimeout (bounded by the request context deadline) before giving up.
 type volumeLocks struct {
-	locks sets.String
+	locks map[string]*lockEntry
 	mux   sync.Mutex
+	// max time to wait for a lock, 0 means not waiting
+	waitTimeout time.Duration
+	// token of the last granted holder
+	lastToken lockToken
 }
 
-func newVolumeLocks() *volumeLocks {
+func newVolumeLocks(waitTimeout time.Duration) *volumeLocks {
 	return &volumeLocks{
-		locks: sets.NewString(),
+		locks:       map[string]*lockEntry{},
+		waitTimeout: waitTimeout,
 	}
 }
 
 // TryAcquire tries to acquire the lock for operating on volumeID and returns true if successful.
 // If another operation is already using volumeID, returns false.
-func (vl *volumeLocks) TryAcquire(volumeID string) bool {
+func (vl *volumeLocks) TryAcquire(volumeID string) (lockToken, bool) {
+	return vl.TryAcquireWithOwner(volumeID, "")
+}
+
+// TryAcquireWithOwner is same as TryAcquire, owner (e.g. RPC name) is recorded for debugging.
+func (vl *volumeLocks) TryAcquireWithOwner(volumeID, owner string) (lockToken, bool) {
 	vl.mux.Lock()
 	defer vl.mux.Unlock()
-	if vl.locks.Has(volumeID) {
-		return false
+	return vl.tryGrantLocked(volumeID, owner, false)
+}
+
+// Acquire acquires the exclusive lock for volumeID, it waits if the lock is held by another operation.
+// Returns false if the lock is not acquired before the wait timeout or ctx is done.
+func (vl *volumeLocks) Acquire(ctx context.Context, volumeID, owner string) (lockToken, bool) {
+	return vl.acquire(ctx, volumeID, owner, false)
+}
+
+// AcquireShared acquires the shared lock for volumeID, shared holders (e.g. read only operations)
+// can run concurrently but not with an exclusive holder.
+// Returns false if the lock is not acquired before the wait timeout or ctx is done.
+func (vl *volumeLocks) AcquireShared(ctx context.Context, volumeID, owner string) (lockToken, bool) {
+	return vl.acquire(ctx, volumeID, owner, true)
+}
+
+func (vl *volumeLocks) acquire(ctx context.Context, volumeID, owner string, shared bool) (lockToken, bool) {
+	start := time.Now()
+	vl.mux.Lock()
+	if token, ok := vl.tryGrantLocked(volumeID, owner, shared); ok {
+		vl.mux.Unlock()
+		return token, true
 	}
-	vl.locks.Insert(volumeID)
-	return true
+	wait := vl.getWaitTimeout(ctx)
+	if wait <= 0 {
+		vl.mux.Unlock()
+		recordLockWait(owner, lockResultAborted, time.Since(start))
+		return 0, false
+	}
+	waiter := &lockWaiter{owner: owner, shared: shared, ready: make(chan struct{})}
+	entry := vl.locks[volumeID]
+	entry.waiters = append(entry.waiters, waiter)
+	vl.mux.Unlock()
+
+	lockWaiters.WithLabelValues(owner).Inc()
+	defer lockWaiters.WithLabelValues(owner).Dec()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		recordLockWait(owner, lockResultAcquired, time.Since(start))
+		return waiter.token, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	if waiter.granted {
+		// lock is granted at the same time as wait expires
+		recordLockWait(owner, lockResultAcquired, time.Since(start))
+		return waiter.token, true
+	}
+	if entry, ok := vl.locks[volumeID]; ok {
+		for i, w := range entry.waiters {
+			if w == waiter {
+				entry.waiters = append(entry.waiters[:i], entry.waiters[i+1:]...)
+				break
+			}
+		}
+		// waiters behind this one may be granted now
+		vl.grantWaitersLocked(volumeID, entry)
+	}
+	recordLockWait(owner, lockResultAborted, time.Since(start))
+	return 0, false
 }
 
-func (vl *volumeLocks) Release(volumeID string) {
+// getWaitTimeout returns the max time to wait for a lock, it's at most half of
+// the time left before ctx deadline so that the operation still has time to run.
+func (vl *volumeLocks) getWaitTimeout(ctx context.Context) time.Duration {
+	wait := vl.waitTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline) / 2; left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// tryGrantLocked grants the lock if it's free and no one is waiting for it, vl.mux must be held
+func (vl *volumeLocks) tryGrantLocked(volumeID, owner string, shared bool) (lockToken, bool) {
+	entry, exists := vl.locks[volumeID]
+	if !exists {
+		entry = &lockEntry{}
+		vl.locks[volumeID] = entry
+	}
+	if len(entry.waiters) > 0 || !canGrant(entry, shared) {
+		return 0, false
+	}
+	return vl.addHolderLocked(volumeID, entry, owner, shared), true
+}
+
+// addHolderLocked adds a holder of volumeID and returns its token, vl.mux must be held
+func (vl *volumeLocks) addHolderLocked(volumeID string, entry *lockEntry, owner string, shared bool) lockToken {
+	vl.lastToken++
+	entry.holders = append(entry.holders, lockInfo{
+		token:      vl.lastToken,
+		Key:        volumeID,
+		Owner:      owner,
+		Shared:     shared,
+		AcquiredAt: time.Now(),
+	})
+	return vl.lastToken
+}
+
+func canGrant(entry *lockEntry, shared bool) bool {
+	if shared {
+		return !entry.exclusive()
+	}
+	return len(entry.holders) == 0
+}
+
+// grantWaitersLocked grants the lock to waiters in FIFO order, vl.mux must be held
+func (vl *volumeLocks) grantWaitersLocked(volumeID string, entry *lockEntry) {
+	for len(entry.waiters) > 0 {
+		w := entry.waiters[0]
+		if !canGrant(entry, w.shared) {
+			break
+		}
+		entry.waiters = entry.waiters[1:]
+		w.token = vl.addHolderLocked(volumeID, entry, w.owner, w.shared)
+		w.granted = true
+		close(w.ready)
+	}
+	if len(entry.holders) == 0 && len(entry.waiters) == 0 {
+		delete(vl.locks, volumeID)
+	}
+}
+
+// Release releases the exclusive or shared lock of volumeID held with token. It's a no-op if the
+// holder is already released by ForceRelease, the lock may be granted to another operation then.
+func (vl *volumeLocks) Release(volumeID string, token lockToken) {
 	vl.mux.Lock()
 	defer vl.mux.Unlock()
-	vl.locks.Delete(volumeID)
+	entry, exists := vl.locks[volumeID]
+	if !exists {
+		klog.V(2).Infof("ignore release of lock %s: the lock is force released", volumeID)
+		return
+	}
+	for i, h := range entry.holders {
+		if h.token == token {
+			entry.holders = append(entry.holders[:i], entry.holders[i+1:]...)
+			vl.grantWaitersLocked(volumeID, entry)
+			return
+		}
+	}
+	klog.V(2).Infof("ignore release of lock %s: the lock is force released", volumeID)
+}
+
+// List returns all held locks sorted by key
+func (vl *volumeLocks) List() []lockInfo {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	result := []lockInfo{}
+	for _, entry := range vl.locks {
+		for _, info := range entry.holders {
+			info.Waiters = len(entry.waiters)
+			result = append(result, info)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].AcquiredAt.Before(result[j].AcquiredAt)
+	})
+	return result
+}
+
+// ForceRelease releases all holders of the lock regardless of their owners, the waiters are
+// granted in order, later Release of the released holders is ignored. Returns false if the
+// lock is not held.
+func (vl *volumeLocks) ForceRelease(volumeID string) bool {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	entry, exists := vl.locks[volumeID]
+	if !exists || len(entry.holders) == 0 {
+		return false
+	}
+	entry.holders = nil
+	vl.grantWaitersLocked(volumeID, entry)
+	return true
 }
@@ -0,0 +1,222 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryAcquire(t *testing.T) {
+	vl := newVolumeLocks(0)
+	token1, ok := vl.TryAcquireWithOwner("vol-1", "CreateVolume")
+	assert.True(t, ok)
+	_, ok = vl.TryAcquire("vol-1")
+	assert.False(t, ok)
+	token2, ok := vl.TryAcquire("vol-2")
+	assert.True(t, ok)
+
+	locks := vl.List()
+	assert.Len(t, locks, 2)
+	assert.Equal(t, "vol-1", locks[0].Key)
+	assert.Equal(t, "CreateVolume", locks[0].Owner)
+
+	vl.Release("vol-1", token1)
+	_, ok = vl.TryAcquire("vol-1")
+	assert.True(t, ok)
+	assert.True(t, vl.ForceRelease("vol-1"))
+	assert.False(t, vl.ForceRelease("vol-1"))
+	vl.Release("vol-2", token2)
+	assert.Empty(t, vl.locks)
+}
+
+func TestAcquireWithoutWaiting(t *testing.T) {
+	vl := newVolumeLocks(0)
+	ctx := context.Background()
+	token, ok := vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.True(t, ok)
+	_, ok = vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.False(t, ok)
+	_, ok = vl.AcquireShared(ctx, "vol-1", "NodeGetVolumeStats")
+	assert.False(t, ok)
+	vl.Release("vol-1", token)
+	reader1, ok := vl.AcquireShared(ctx, "vol-1", "NodeGetVolumeStats")
+	assert.True(t, ok)
+	reader2, ok := vl.AcquireShared(ctx, "vol-1", "NodeGetVolumeStats")
+	assert.True(t, ok)
+	_, ok = vl.Acquire(ctx, "vol-1", "NodeUnpublishVolume")
+	assert.False(t, ok)
+	vl.Release("vol-1", reader1)
+	vl.Release("vol-1", reader2)
+	assert.Empty(t, vl.locks)
+}
+
+func TestAcquireWaitTimeout(t *testing.T) {
+	vl := newVolumeLocks(50 * time.Millisecond)
+	ctx := context.Background()
+	token, ok := vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.True(t, ok)
+
+	start := time.Now()
+	_, ok = vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.False(t, ok)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// wait is bounded by half of the time left before ctx deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	vl.waitTimeout = time.Minute
+	start = time.Now()
+	_, ok = vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.False(t, ok)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// expired waiters are removed from the queue
+	vl.Release("vol-1", token)
+	assert.Empty(t, vl.locks)
+}
+
+func TestAcquireWaitUntilReleased(t *testing.T) {
+	vl := newVolumeLocks(time.Minute)
+	ctx := context.Background()
+	token, ok := vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+	assert.True(t, ok)
+
+	acquired := make(chan lockToken)
+	go func() {
+		token, ok := vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+		assert.True(t, ok)
+		acquired <- token
+	}()
+	waitForWaiters(t, vl, "vol-1", 1)
+	vl.Release("vol-1", token)
+	token = <-acquired
+	assert.Equal(t, "NodeStageVolume", vl.List()[0].Owner)
+	vl.Release("vol-1", token)
+	assert.Empty(t, vl.locks)
+}
+
+func TestAcquireFIFO(t *testing.T) {
+	vl := newVolumeLocks(time.Minute)
+	ctx := context.Background()
+	token, ok := vl.Acquire(ctx, "vol-1", "holder")
+	assert.True(t, ok)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	acquire := func(owner string, shared bool) {
+		defer wg.Done()
+		var token lockToken
+		var ok bool
+		if shared {
+			token, ok = vl.AcquireShared(ctx, "vol-1", owner)
+		} else {
+			token, ok = vl.Acquire(ctx, "vol-1", owner)
+		}
+		assert.True(t, ok)
+		mu.Lock()
+		order = append(order, owner)
+		mu.Unlock()
+		if shared {
+			time.Sleep(10 * time.Millisecond)
+		}
+		vl.Release("vol-1", token)
+	}
+
+	waiters := []struct {
+		owner  string
+		shared bool
+	}{
+		{"writer-1", false},
+		{"reader-1", true},
+		{"reader-2", true},
+		{"writer-2", false},
+	}
+	for i, w := range waiters {
+		wg.Add(1)
+		go acquire(w.owner, w.shared)
+		waitForWaiters(t, vl, "vol-1", i+1)
+	}
+	// a new shared request must queue behind waiters instead of jumping the line
+	lateCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, ok = vl.AcquireShared(lateCtx, "vol-1", "late-reader")
+	assert.False(t, ok)
+
+	vl.Release("vol-1", token)
+	wg.Wait()
+	assert.Len(t, order, 4)
+	assert.Equal(t, "writer-1", order[0])
+	assert.ElementsMatch(t, []string{"reader-1", "reader-2"}, order[1:3])
+	assert.Equal(t, "writer-2", order[3])
+}
+
+func TestForceReleaseGrantsWaiter(t *testing.T) {
+	vl := newVolumeLocks(time.Minute)
+	ctx := context.Background()
+	stuck, ok := vl.Acquire(ctx, "vol-1", "stuck")
+	assert.True(t, ok)
+
+	acquired := make(chan lockToken)
+	go func() {
+		token, ok := vl.Acquire(ctx, "vol-1", "NodeStageVolume")
+		assert.True(t, ok)
+		acquired <- token
+	}()
+	waitForWaiters(t, vl, "vol-1", 1)
+	assert.Equal(t, 1, vl.List()[0].Waiters)
+	assert.True(t, vl.ForceRelease("vol-1"))
+	token := <-acquired
+
+	// release of the stuck operation does not release the lock granted to the waiter
+	vl.Release("vol-1", stuck)
+	locks := vl.List()
+	assert.Len(t, locks, 1)
+	assert.Equal(t, "NodeStageVolume", locks[0].Owner)
+	_, ok = vl.TryAcquire("vol-1")
+	assert.False(t, ok)
+
+	vl.Release("vol-1", token)
+	assert.Empty(t, vl.locks)
+	// stale release after the key is removed is ignored
+	vl.Release("vol-1", stuck)
+	assert.Empty(t, vl.locks)
+}
+
+func waitForWaiters(t *testing.T, vl *volumeLocks, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		vl.mux.Lock()
+		entry, ok := vl.locks[key]
+		count := 0
+		if ok {
+			count = len(entry.waiters)
+		}
+		vl.mux.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d waiters on %s", n, key)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ownership marker written into each directory provisioned by CreateVolume
+	volumeMarkerFile = ".csi-smb-volume.json"
+	// reason of the event on PV when DeleteVolume refuses to delete a directory
+	deletionRefusedReason = "DeletionRefused"
+)
+
+// volumeMarker records the volume which a directory is provisioned for
+type volumeMarker struct {
+	VolumeID  string    `json:"volumeID"`
+	PV        string    `json:"pv"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// writeVolumeMarker writes the marker of volumeID into volumePath. A marker of another volume is kept unless
+// overwrite is true, so that a directory shared by several volumes could only be deleted by its first volume.
+func writeVolumeMarker(volumePath, volumeID, pvName string, overwrite bool) error {
+	markerPath := filepath.Join(volumePath, volumeMarkerFile)
+	if !overwrite {
+		if marker, err := readVolumeMarker(volumePath); err == nil {
+			if marker.VolumeID != volumeID {
+				klog.Warningf("directory %s is provisioned for volume %s, keep its marker", volumePath, marker.VolumeID)
+			}
+			return nil
+		}
+	}
+	data, err := json.Marshal(&volumeMarker{VolumeID: volumeID, PV: pvName, CreatedAt: timeNow().UTC().Truncate(time.Second)})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(markerPath, data, 0644)
+}
+
+func readVolumeMarker(volumePath string) (*volumeMarker, error) {
+	data, err := os.ReadFile(filepath.Join(volumePath, volumeMarkerFile))
+	if err != nil {
+		return nil, err
+	}
+	marker := &volumeMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		return nil, fmt.Errorf("invalid volume marker: %v", err)
+	}
+	return marker, nil
+}
+
+// removeAll is replaced in tests to simulate a failed removal
+var removeAll = os.RemoveAll
+
+// removeVolumeDirectory removes the directory of a volume with its marker removed last, so that a retry after
+// a partial removal still finds the marker and passes checkDeletionSafety
+func removeVolumeDirectory(volumePath string) error {
+	entries, err := os.ReadDir(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == volumeMarkerFile {
+			continue
+		}
+		if err := removeAll(filepath.Join(volumePath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(volumePath, volumeMarkerFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeAll(volumePath)
+}
+
+// validateProtectedPaths checks the syntax of protected path patterns
+func validateProtectedPaths(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid protected path pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// checkDeletionSafety returns an error if the directory of vol, which is mounted at its internal mount path,
+// should not be deleted or archived: it's the share root, it matches a protected path pattern, or it does not
+// have the marker of volumeID. A directory which does not exist is safe to delete.
+func (d *Driver) checkDeletionSafety(vol *smbVolume, volumeID string) error {
+	mountPath := getInternalMountPath(d.workingMountDir, vol)
+	volumePath := getInternalVolumePath(d.workingMountDir, vol)
+	if filepath.Clean(volumePath) == filepath.Clean(mountPath) {
+		return fmt.Errorf("refuse to delete the root of share %s", vol.source)
+	}
+	subDir := strings.Trim(filepath.ToSlash(vol.subDir), "/")
+	for _, pattern := range d.protectedPaths {
+		if matched, _ := path.Match(pattern, subDir); matched {
+			return fmt.Errorf("refuse to delete %s on share %s, it matches protected path pattern %q", subDir, vol.source, pattern)
+		}
+	}
+	if _, err := os.Lstat(volumePath); os.IsNotExist(err) {
+		return nil
+	}
+	if d.allowDeleteWithoutVolumeMarker {
+		return nil
+	}
+	marker, err := readVolumeMarker(volumePath)
+	if err != nil {
+		return fmt.Errorf("refuse to delete %s on share %s without a valid volume marker %s: %v", subDir, vol.source, volumeMarkerFile, err)
+	}
+	if marker.VolumeID != volumeID {
+		return fmt.Errorf("refuse to delete %s on share %s, it's provisioned for volume %s", subDir, vol.source, marker.VolumeID)
+	}
+	return nil
+}
+
+// recordVolumeEvent records an event on the PV of volumeID, it's a no-op if the PV is not found
+func (d *Driver) recordVolumeEvent(ctx context.Context, volumeID, eventType, reason, message string) {
+	if d.eventRecorder == nil || d.kubeClient == nil {
+		return
+	}
+	pv, err := d.getPVByVolumeID(ctx, volumeID)
+	if err != nil || pv == nil {
+		klog.V(4).Infof("skip event %s of volume %s: PV not found, err: %v", reason, volumeID, err)
+		return
+	}
+	d.eventRecorder.Event(pv, eventType, reason, message)
+}
+
+// warnDeletionRefused records the reason why DeleteVolume refuses to delete a directory on PV
+func (d *Driver) warnDeletionRefused(ctx context.Context, volumeID string, err error) {
+	klog.Warningf("DeleteVolume(%s): %v", volumeID, err)
+	d.recordVolumeEvent(ctx, volumeID, v1.EventTypeWarning, deletionRefusedReason, err.Error())
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestWriteVolumeMarker(t *testing.T) {
+	createdAt := time.Date(2026, 10, 18, 10, 20, 30, 0, time.UTC)
+	timeNow = func() time.Time { return createdAt }
+	defer func() { timeNow = time.Now }()
+
+	volumePath := t.TempDir()
+	_, err := readVolumeMarker(volumePath)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, writeVolumeMarker(volumePath, "vol-1", "pv-1", false))
+	marker, err := readVolumeMarker(volumePath)
+	assert.NoError(t, err)
+	assert.Equal(t, &volumeMarker{VolumeID: "vol-1", PV: "pv-1", CreatedAt: createdAt}, marker)
+
+	// marker of another volume is kept
+	assert.NoError(t, writeVolumeMarker(volumePath, "vol-2", "pv-2", false))
+	marker, _ = readVolumeMarker(volumePath)
+	assert.Equal(t, "vol-1", marker.VolumeID)
+
+	assert.NoError(t, writeVolumeMarker(volumePath, "vol-2", "pv-2", true))
+	marker, _ = readVolumeMarker(volumePath)
+	assert.Equal(t, "vol-2", marker.VolumeID)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(volumePath, volumeMarkerFile), []byte("invalid"), 0644))
+	_, err = readVolumeMarker(volumePath)
+	assert.Error(t, err)
+}
+
+func TestValidateProtectedPaths(t *testing.T) {
+	assert.NoError(t, validateProtectedPaths([]string{"home", "projects/*"}))
+	assert.Error(t, validateProtectedPaths([]string{"home", "projects/["}))
+}
+
+func TestCheckDeletionSafety(t *testing.T) {
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	d.protectedPaths = []string{"home", "projects/*"}
+	newVolume := func(subDir, markerVolumeID string) *smbVolume {
+		vol := &smbVolume{source: "//smb-server/share", subDir: subDir, uuid: "pv-name"}
+		if subDir != "" && markerVolumeID != "" {
+			volumePath := getInternalVolumePath(d.workingMountDir, vol)
+			assert.NoError(t, os.MkdirAll(volumePath, 0755))
+			assert.NoError(t, writeVolumeMarker(volumePath, markerVolumeID, "pv-name", true))
+		}
+		return vol
+	}
+	unmarked := newVolume("unmarked", "")
+	assert.NoError(t, os.MkdirAll(getInternalVolumePath(d.workingMountDir, unmarked), 0755))
+
+	tests := []struct {
+		desc          string
+		vol           *smbVolume
+		allowNoMarker bool
+		expectedErr   bool
+	}{
+		{desc: "matching marker", vol: newVolume("pvc-1", "vol-id")},
+		{desc: "directory does not exist", vol: newVolume("pvc-2", "")},
+		{desc: "share root", vol: newVolume("", ""), expectedErr: true},
+		{desc: "share root with allowed missing marker", vol: newVolume("", ""), allowNoMarker: true, expectedErr: true},
+		{desc: "protected path", vol: newVolume("home", "vol-id"), expectedErr: true},
+		{desc: "protected path pattern", vol: newVolume("projects/a", "vol-id"), expectedErr: true},
+		{desc: "nested directory not protected", vol: newVolume("projects/a/b", "vol-id")},
+		{desc: "marker of another volume", vol: newVolume("pvc-3", "other-vol-id"), expectedErr: true},
+		{desc: "no marker", vol: unmarked, expectedErr: true},
+		{desc: "no marker in migration", vol: unmarked, allowNoMarker: true},
+	}
+	for _, test := range tests {
+		d.allowDeleteWithoutVolumeMarker = test.allowNoMarker
+		err := d.checkDeletionSafety(test.vol, "vol-id")
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+	}
+}
+
+func TestRemoveVolumeDirectory(t *testing.T) {
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	vol := &smbVolume{source: "//smb-server/share", subDir: "pvc-1", uuid: "pv-name"}
+	volumePath := getInternalVolumePath(d.workingMountDir, vol)
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(volumePath, name), 0755))
+	}
+	assert.NoError(t, writeVolumeMarker(volumePath, "vol-id", "pv-name", true))
+
+	// the removal fails partway, the marker is kept for the retry
+	removeAll = func(path string) error {
+		if filepath.Base(path) == "b" {
+			return fmt.Errorf("permission denied")
+		}
+		return os.RemoveAll(path)
+	}
+	defer func() { removeAll = os.RemoveAll }()
+	assert.Error(t, removeVolumeDirectory(volumePath))
+	_, err := os.Stat(filepath.Join(volumePath, "a"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, d.checkDeletionSafety(vol, "vol-id"))
+
+	removeAll = os.RemoveAll
+	assert.NoError(t, removeVolumeDirectory(volumePath))
+	_, err = os.Stat(volumePath)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, removeVolumeDirectory(volumePath))
+}
+
+func TestDeleteVolumeRefused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skip on windows")
+	}
+	d := NewFakeDriver()
+	d.workingMountDir = t.TempDir()
+	mounter, err := NewFakeMounter()
+	if err != nil {
+		t.Fatalf("failed to get fake mounter: %v", err)
+	}
+	d.mounter = mounter
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+
+	volumeID := "smb-server/share#pvc-1#pv-name#"
+	d.kubeClient = fake.NewSimpleClientset(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-name"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: volumeID},
+			},
+		},
+	})
+	volumePath := filepath.Join(d.workingMountDir, "pv-name", "pvc-1")
+	assert.NoError(t, os.MkdirAll(volumePath, 0755))
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+		VolumeId: volumeID,
+		Secrets:  map[string]string{usernameField: "test", passwordField: "test"},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = os.Stat(volumePath)
+	assert.NoError(t, err)
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning DeletionRefused refuse to delete pvc-1")
+}
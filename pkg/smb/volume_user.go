@@ -0,0 +1,269 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// prefix of the user created for a volume, the user name is also the name of its secret
+	volumeUserPrefix = "smb-"
+	// length of random password of volume user in bytes before base64 encoding
+	volumeUserPasswordLength = 24
+	// annotation of volume user secret with the PV name
+	volumeUserPVAnnotation = "smb.csi.k8s.io/pv-name"
+)
+
+var volumeUserRegex = regexp.MustCompile(`^` + volumeUserPrefix + `[0-9a-f]{16}$`)
+
+// userBackend creates and deletes users on SMB server
+type userBackend interface {
+	// CreateUser creates the user or resets the password if the user already exists
+	CreateUser(ctx context.Context, user, password string) error
+	// DeleteUser deletes the user, it's a no-op if the user does not exist
+	DeleteUser(ctx context.Context, user string) error
+	// GrantAccess grants the user access to dir under share and revokes the access of other users
+	// except the owner and group of dir
+	GrantAccess(ctx context.Context, user, share, dir string) error
+}
+
+// newUserBackend returns the user backend of opts, nil if user backend is disabled
+func newUserBackend(opts ServerBackendOptions) (userBackend, error) {
+	switch opts.UserBackend {
+	case "":
+		return nil, nil
+	case UserBackendSambaSmbpasswd:
+		return &sambaSmbpasswdBackend{
+			serverCommand: serverCommand{sshTarget: opts.SSHTarget, sshKeyFile: opts.SSHKeyFile},
+		}, nil
+	default:
+		return nil, fmt.Errorf("user backend %q is not supported, supported backends: %s", opts.UserBackend, UserBackendSambaSmbpasswd)
+	}
+}
+
+// getVolumeUser returns the user name of volume name, it fits into the 32 characters limit of Unix user names
+func getVolumeUser(name string) string {
+	hash := sha256.Sum256([]byte(name))
+	return volumeUserPrefix + hex.EncodeToString(hash[:8])
+}
+
+// validateVolumeUser checks whether user is a volume user created by the driver
+func validateVolumeUser(user string) error {
+	if !volumeUserRegex.MatchString(user) {
+		return fmt.Errorf("invalid volume user %q, it should match %s", user, volumeUserRegex.String())
+	}
+	return nil
+}
+
+// generatePassword returns a random password
+func generatePassword() (string, error) {
+	b := make([]byte, volumeUserPasswordLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// splitShareDir returns the share name and the directory under share of volume
+func splitShareDir(vol *smbVolume) (string, string) {
+	if vol.share != "" {
+		return vol.share, vol.subDir
+	}
+	// source is //{server}/{share}/{dir}
+	segments := strings.SplitN(strings.Trim(strings.ReplaceAll(vol.source, `\`, "/"), "/"), "/", 3)
+	var share, dir string
+	if len(segments) >= 2 {
+		share = segments[1]
+	}
+	if len(segments) == 3 {
+		dir = segments[2]
+	}
+	return share, path.Join(dir, vol.subDir)
+}
+
+// createVolumeUser creates the user of vol with the password in its secret, the secret is created with a
+// random password if it does not exist.
+func (d *Driver) createVolumeUser(ctx context.Context, vol *smbVolume, pvName string) error {
+	password, err := d.ensureVolumeUserSecret(ctx, vol, pvName)
+	if err != nil {
+		return err
+	}
+	if err := d.userBackend.CreateUser(ctx, vol.volumeUser, password); err != nil {
+		return status.Errorf(codes.Internal, "failed to create user %s: %v", vol.volumeUser, err)
+	}
+	return nil
+}
+
+// grantVolumeUser grants the user of vol access to the volume directory
+func (d *Driver) grantVolumeUser(ctx context.Context, vol *smbVolume) error {
+	share, dir := splitShareDir(vol)
+	if dir == "" {
+		// volume is the root of a share which is only valid for the volume user
+		return nil
+	}
+	if err := d.userBackend.GrantAccess(ctx, vol.volumeUser, share, dir); err != nil {
+		return status.Errorf(codes.Internal, "failed to grant user %s access to %s/%s: %v", vol.volumeUser, share, dir, err)
+	}
+	return nil
+}
+
+// deleteVolumeUser deletes the user and secret of vol
+func (d *Driver) deleteVolumeUser(ctx context.Context, vol *smbVolume) error {
+	if d.userBackend == nil {
+		return status.Errorf(codes.FailedPrecondition, "user backend is not configured in driver to delete user %s", vol.volumeUser)
+	}
+	if err := d.userBackend.DeleteUser(ctx, vol.volumeUser); err != nil {
+		return status.Errorf(codes.Internal, "failed to delete user %s: %v", vol.volumeUser, err)
+	}
+	if d.kubeClient == nil {
+		return status.Errorf(codes.Internal, "could not delete secret %s/%s: KubeClient is nil", vol.volumeUserSecretNamespace, vol.volumeUser)
+	}
+	err := d.kubeClient.CoreV1().Secrets(vol.volumeUserSecretNamespace).Delete(ctx, vol.volumeUser, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return status.Errorf(codes.Internal, "could not delete secret %s/%s: %v", vol.volumeUserSecretNamespace, vol.volumeUser, err)
+	}
+	klog.V(2).Infof("deleted user %s and secret %s/%s", vol.volumeUser, vol.volumeUserSecretNamespace, vol.volumeUser)
+	return nil
+}
+
+// ensureVolumeUserSecret returns the password in the secret of volume user, the secret is created if it does not exist
+func (d *Driver) ensureVolumeUserSecret(ctx context.Context, vol *smbVolume, pvName string) (string, error) {
+	if d.kubeClient == nil {
+		return "", status.Errorf(codes.Internal, "could not create secret %s/%s: KubeClient is nil", vol.volumeUserSecretNamespace, vol.volumeUser)
+	}
+	secrets := d.kubeClient.CoreV1().Secrets(vol.volumeUserSecretNamespace)
+	secret, err := secrets.Get(ctx, vol.volumeUser, metav1.GetOptions{})
+	if err == nil {
+		if password := string(secret.Data[passwordField]); password != "" {
+			return password, nil
+		}
+		return "", status.Errorf(codes.Internal, "secret %s/%s exists without %s", vol.volumeUserSecretNamespace, vol.volumeUser, passwordField)
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", status.Errorf(codes.Internal, "could not get secret %s/%s: %v", vol.volumeUserSecretNamespace, vol.volumeUser, err)
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to generate password: %v", err)
+	}
+	secret = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        vol.volumeUser,
+			Namespace:   vol.volumeUserSecretNamespace,
+			Labels:      map[string]string{"app.kubernetes.io/managed-by": d.Name},
+			Annotations: map[string]string{volumeUserPVAnnotation: pvName},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			usernameField: []byte(vol.volumeUser),
+			passwordField: []byte(password),
+		},
+	}
+	if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return "", status.Errorf(codes.Internal, "could not create secret %s/%s: %v", vol.volumeUserSecretNamespace, vol.volumeUser, err)
+	}
+	klog.V(2).Infof("created secret %s/%s for volume %s", vol.volumeUserSecretNamespace, vol.volumeUser, pvName)
+	return password, nil
+}
+
+// sambaSmbpasswdBackend manages Samba users in passdb with `smbpasswd` and `pdbedit`, locally or on a
+// remote host over ssh. A Unix account without home directory and login shell is created for each user.
+type sambaSmbpasswdBackend struct {
+	serverCommand
+}
+
+func (b *sambaSmbpasswdBackend) CreateUser(_ context.Context, user, password string) error {
+	if err := validateVolumeUser(user); err != nil {
+		return err
+	}
+	if _, err := b.run("id", "-u", user); err != nil {
+		if _, err := b.run("useradd", "--no-create-home", "--shell", "/usr/sbin/nologin", user); err != nil {
+			return err
+		}
+	}
+	// smbpasswd -s reads the new password twice from stdin, an existing user gets the password reset
+	if _, err := b.runWithInput(password+"\n"+password+"\n", "smbpasswd", "-a", "-s", user); err != nil {
+		return err
+	}
+	klog.V(2).Infof("created user %s on %s", user, b.host())
+	return nil
+}
+
+func (b *sambaSmbpasswdBackend) DeleteUser(_ context.Context, user string) error {
+	if err := validateVolumeUser(user); err != nil {
+		return err
+	}
+	if _, err := b.run("pdbedit", "-u", user); err == nil {
+		if _, err := b.run("smbpasswd", "-x", user); err != nil {
+			return err
+		}
+	}
+	if _, err := b.run("id", "-u", user); err == nil {
+		if _, err := b.run("userdel", user); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("deleted user %s on %s", user, b.host())
+	return nil
+}
+
+func (b *sambaSmbpasswdBackend) GrantAccess(_ context.Context, user, share, dir string) error {
+	if err := validateVolumeUser(user); err != nil {
+		return err
+	}
+	if err := validateShareName(share); err != nil {
+		return err
+	}
+	if err := validatePath(dir); err != nil {
+		return err
+	}
+	out, err := b.run("testparm", "-s", "--section-name="+share, "--parameter-name=path")
+	if err != nil {
+		return err
+	}
+	sharePath := strings.TrimSpace(out)
+	if err := validateSharePath(sharePath); err != nil {
+		return fmt.Errorf("invalid path of share %s: %v", share, err)
+	}
+	dirPath := path.Join(sharePath, dir)
+	acl := fmt.Sprintf("u:%s:rwX,d:u:%s:rwX", user, user)
+	if _, err := b.run("setfacl", "-R", "-m", acl, "--", dirPath); err != nil {
+		return err
+	}
+	if _, err := b.run("chmod", "o-rwx", "--", dirPath); err != nil {
+		return err
+	}
+	klog.V(2).Infof("granted user %s access to %s on %s", user, dirPath, b.host())
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeUserBackend records the users and their access
+type fakeUserBackend struct {
+	users   map[string]string
+	granted map[string]string
+}
+
+func newFakeUserBackend() *fakeUserBackend {
+	return &fakeUserBackend{users: map[string]string{}, granted: map[string]string{}}
+}
+
+func (f *fakeUserBackend) CreateUser(_ context.Context, user, password string) error {
+	f.users[user] = password
+	return nil
+}
+
+func (f *fakeUserBackend) DeleteUser(_ context.Context, user string) error {
+	delete(f.users, user)
+	return nil
+}
+
+func (f *fakeUserBackend) GrantAccess(_ context.Context, user, share, dir string) error {
+	f.granted[user] = share + "/" + dir
+	return nil
+}
+
+func TestGetVolumeUser(t *testing.T) {
+	user := getVolumeUser("pvc-4729891a-f57e-4982-9c60-e9884af1be2f")
+	assert.NoError(t, validateVolumeUser(user))
+	assert.LessOrEqual(t, len(user), 32)
+	assert.Equal(t, user, getVolumeUser("pvc-4729891a-f57e-4982-9c60-e9884af1be2f"))
+	assert.NotEqual(t, user, getVolumeUser("pvc-other"))
+
+	assert.Error(t, validateVolumeUser("root"))
+	assert.Error(t, validateVolumeUser("smb-0123456789abcdef;id"))
+}
+
+func TestSplitShareDir(t *testing.T) {
+	tests := []struct {
+		vol           *smbVolume
+		expectedShare string
+		expectedDir   string
+	}{
+		{
+			vol:           &smbVolume{source: "//smb-server/share", subDir: "pv-name"},
+			expectedShare: "share",
+			expectedDir:   "pv-name",
+		},
+		{
+			vol:           &smbVolume{source: "//smb-server/share/dir1/dir2", subDir: "pv-name"},
+			expectedShare: "share",
+			expectedDir:   "dir1/dir2/pv-name",
+		},
+		{
+			vol:           &smbVolume{source: "//smb-server/pv-name", share: "pv-name"},
+			expectedShare: "pv-name",
+		},
+	}
+	for _, test := range tests {
+		share, dir := splitShareDir(test.vol)
+		assert.Equal(t, test.expectedShare, share, test.vol.source)
+		assert.Equal(t, test.expectedDir, dir, test.vol.source)
+	}
+}
+
+func TestSambaSmbpasswdBackend(t *testing.T) {
+	user := "smb-0123456789abcdef"
+	backend := &sambaSmbpasswdBackend{}
+
+	runner := &fakeCommandRunner{errors: map[string]error{"id -u " + user: fmt.Errorf("no such user")}}
+	setFakeCommandRunner(t, runner)
+	assert.NoError(t, backend.CreateUser(context.Background(), user, "secret"))
+	assert.Equal(t, []string{
+		"id -u " + user,
+		"useradd --no-create-home --shell /usr/sbin/nologin " + user,
+		"smbpasswd -a -s " + user,
+	}, runner.commands)
+	assert.Equal(t, "secret\nsecret\n", runner.inputs["smbpasswd -a -s "+user])
+
+	runner = &fakeCommandRunner{}
+	setFakeCommandRunner(t, runner)
+	assert.NoError(t, backend.CreateUser(context.Background(), user, "secret"))
+	assert.Equal(t, []string{"id -u " + user, "smbpasswd -a -s " + user}, runner.commands)
+
+	runner = &fakeCommandRunner{}
+	setFakeCommandRunner(t, runner)
+	assert.NoError(t, backend.DeleteUser(context.Background(), user))
+	assert.Equal(t, []string{"pdbedit -u " + user, "smbpasswd -x " + user, "id -u " + user, "userdel " + user}, runner.commands)
+
+	runner = &fakeCommandRunner{errors: map[string]error{"pdbedit -u " + user: fmt.Errorf("not found"), "id -u " + user: fmt.Errorf("no such user")}}
+	setFakeCommandRunner(t, runner)
+	assert.NoError(t, backend.DeleteUser(context.Background(), user))
+	assert.Equal(t, []string{"pdbedit -u " + user, "id -u " + user}, runner.commands)
+
+	runner = &fakeCommandRunner{outputs: map[string]string{"testparm -s --section-name=share --parameter-name=path": "/srv/samba/share\n"}}
+	setFakeCommandRunner(t, runner)
+	assert.NoError(t, backend.GrantAccess(context.Background(), user, "share", "dir/pv-name"))
+	assert.Equal(t, []string{
+		"testparm -s --section-name=share --parameter-name=path",
+		"setfacl -R -m u:" + user + ":rwX,d:u:" + user + ":rwX -- /srv/samba/share/dir/pv-name",
+		"chmod o-rwx -- /srv/samba/share/dir/pv-name",
+	}, runner.commands)
+
+	assert.Error(t, backend.CreateUser(context.Background(), "root", "secret"))
+	assert.Error(t, backend.GrantAccess(context.Background(), user, "share", "../etc"))
+}
+
+func TestCreateDeleteVolumeUser(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = fake.NewSimpleClientset()
+	// CreateVolume sets volume context in parameters, so every call gets a new request
+	newRequest := func() *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: "pv-name",
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				},
+			},
+			Parameters: map[string]string{
+				sourceField:     "//smb-server",
+				"sharePath":     "/srv/samba",
+				"perVolumeUser": "true",
+				pvcNamespaceKey: "tenant1",
+			},
+		}
+	}
+	_, err := d.CreateVolume(context.Background(), newRequest())
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	shares := &fakeShareBackend{}
+	users := newFakeUserBackend()
+	d.shareBackend, d.userBackend = shares, users
+	resp, err := d.CreateVolume(context.Background(), newRequest())
+	assert.NoError(t, err)
+
+	user := getVolumeUser("pv-name")
+	assert.Equal(t, "v2:share=pv-name&source=smb-server%2Fpv-name&user=tenant1%2F"+user+"&uuid=pv-name", resp.GetVolume().GetVolumeId())
+	assert.Equal(t, user, resp.GetVolume().GetVolumeContext()[secretNameField])
+	assert.Equal(t, "tenant1", resp.GetVolume().GetVolumeContext()[secretNamespaceField])
+	assert.Equal(t, user, shares.created[0].validUsers)
+	secret, err := d.kubeClient.CoreV1().Secrets("tenant1").Get(context.Background(), user, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, user, string(secret.Data[usernameField]))
+	assert.Equal(t, users.users[user], string(secret.Data[passwordField]))
+	assert.NotEmpty(t, users.users[user])
+	assert.Empty(t, users.granted)
+
+	// retry reuses the password in secret
+	password := users.users[user]
+	_, err = d.CreateVolume(context.Background(), newRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, password, users.users[user])
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	assert.NoError(t, err)
+	assert.Empty(t, users.users)
+	_, err = d.kubeClient.CoreV1().Secrets("tenant1").Get(context.Background(), user, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// user and secret are cleaned up if CreateVolume fails after the user is created
+	shares.err = fmt.Errorf("net conf addshare failed")
+	_, err = d.CreateVolume(context.Background(), newRequest())
+	assert.Error(t, err)
+	assert.Empty(t, users.users)
+	_, err = d.kubeClient.CoreV1().Secrets("tenant1").Get(context.Background(), user, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
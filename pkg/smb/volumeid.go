@@ -0,0 +1,312 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// separator of elements in legacy volume id and of volume id and token in snapshot id
+	separator = "#"
+	// prefix of the versioned volume id, the fields are URL query encoded after it
+	volumeIDV2Prefix = "v2:"
+)
+
+// keys of the fields in versioned volume id
+const (
+	idKeySource     = "source"
+	idKeySubDir     = "subdir"
+	idKeyUUID       = "uuid"
+	idKeyOnDelete   = "ondelete"
+	idKeyShare      = "share"
+	idKeyVolumeUser = "user"
+)
+
+// Ordering of elements in the legacy CSI volume id.
+// ID is of the form {server}/{subDir}.
+const (
+	idSource = iota
+	idSubDir
+	idUUID
+	idOnDelete
+	idShare
+	idVolumeUser
+	totalIDElements // Always last
+)
+
+// volumeIDVersionRegex matches the version prefix of a versioned volume id, a legacy volume id never matches
+// since it starts with a server name which does not contain ':'
+var volumeIDVersionRegex = regexp.MustCompile(`^v([0-9]+):`)
+
+// VolumeIDInfo is the decoded form of a volume id
+type VolumeIDInfo struct {
+	// Version is 1 for the legacy volume id, 2 for the versioned volume id
+	Version                   int               `json:"version"`
+	Source                    string            `json:"source"`
+	SubDir                    string            `json:"subDir,omitempty"`
+	UUID                      string            `json:"uuid,omitempty"`
+	OnDelete                  string            `json:"onDelete,omitempty"`
+	Share                     string            `json:"share,omitempty"`
+	VolumeUser                string            `json:"volumeUser,omitempty"`
+	VolumeUserSecretNamespace string            `json:"volumeUserSecretNamespace,omitempty"`
+	Attributes                map[string]string `json:"attributes,omitempty"`
+}
+
+// DecodeVolumeID returns the fields of a legacy or versioned volume id
+func DecodeVolumeID(id string) (*VolumeIDInfo, error) {
+	vol, err := getSmbVolFromID(id)
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if strings.HasPrefix(id, volumeIDV2Prefix) {
+		version = 2
+	}
+	return &VolumeIDInfo{
+		Version:                   version,
+		Source:                    vol.source,
+		SubDir:                    vol.subDir,
+		UUID:                      vol.uuid,
+		OnDelete:                  vol.onDelete,
+		Share:                     vol.share,
+		VolumeUser:                vol.volumeUser,
+		VolumeUserSecretNamespace: vol.volumeUserSecretNamespace,
+		Attributes:                vol.idAttributes,
+	}, nil
+}
+
+// EncodeVolumeID returns the volume id of info in the format of info.Version
+func EncodeVolumeID(info *VolumeIDInfo) (string, error) {
+	if info.Source == "" {
+		return "", fmt.Errorf("source is required")
+	}
+	vol := &smbVolume{
+		source:                    info.Source,
+		subDir:                    info.SubDir,
+		uuid:                      info.UUID,
+		onDelete:                  info.OnDelete,
+		share:                     info.Share,
+		volumeUser:                info.VolumeUser,
+		volumeUserSecretNamespace: info.VolumeUserSecretNamespace,
+		idAttributes:              info.Attributes,
+	}
+	if err := validateVolumeIDFields(vol); err != nil {
+		return "", err
+	}
+	switch info.Version {
+	case 1:
+		return getLegacyVolumeID(vol)
+	case 2:
+		return getVolumeIDV2(vol), nil
+	}
+	return "", fmt.Errorf("unsupported volume id version %d", info.Version)
+}
+
+// Given a smbVolume, return a CSI volume id. The legacy volume id is returned if it could encode vol, so that
+// volumes could still be managed by an earlier driver version, otherwise the versioned volume id is returned.
+// A volume with a share per volume, a volume user or extra attributes always gets the versioned volume id,
+// since an earlier driver version would ignore them, e.g. delete the share root as the volume directory.
+func getVolumeIDFromSmbVol(vol *smbVolume) string {
+	if id, err := getLegacyVolumeID(vol); err == nil {
+		return id
+	}
+	return getVolumeIDV2(vol)
+}
+
+// getVolumeIDV2 returns the versioned CSI volume id of vol, e.g.
+//
+//	v2:ondelete=retain&source=smb-server%2Fshare&subdir=pvc-4729891a&uuid=pvc-4729891a
+func getVolumeIDV2(vol *smbVolume) string {
+	values := url.Values{}
+	for k, v := range vol.idAttributes {
+		values.Set(k, v)
+	}
+	values.Set(idKeySource, strings.Trim(vol.source, "/"))
+	setIDValue := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	setIDValue(idKeySubDir, strings.Trim(vol.subDir, "/"))
+	setIDValue(idKeyUUID, vol.uuid)
+	if strings.EqualFold(vol.onDelete, retain) || strings.EqualFold(vol.onDelete, archive) {
+		setIDValue(idKeyOnDelete, vol.onDelete)
+	}
+	setIDValue(idKeyShare, vol.share)
+	if vol.volumeUser != "" {
+		setIDValue(idKeyVolumeUser, vol.volumeUserSecretNamespace+"/"+vol.volumeUser)
+	}
+	return volumeIDV2Prefix + values.Encode()
+}
+
+// getLegacyVolumeID returns the legacy CSI volume id of vol, an error is returned if an element
+// contains the separator or vol has a share, a volume user or extra attributes, which are only encoded
+// in the versioned volume id. Legacy volume ids with share and volume user elements are still parsed.
+func getLegacyVolumeID(vol *smbVolume) (string, error) {
+	if len(vol.idAttributes) > 0 {
+		return "", fmt.Errorf("attributes could not be encoded in legacy volume id")
+	}
+	if vol.share != "" || vol.volumeUser != "" {
+		return "", fmt.Errorf("share and volume user could not be encoded in legacy volume id")
+	}
+	idElements := make([]string, idShare)
+	idElements[idSource] = strings.Trim(vol.source, "/")
+	idElements[idSubDir] = strings.Trim(vol.subDir, "/")
+	idElements[idUUID] = vol.uuid
+	if strings.EqualFold(vol.onDelete, retain) || strings.EqualFold(vol.onDelete, archive) {
+		idElements[idOnDelete] = vol.onDelete
+	}
+	for _, element := range idElements {
+		if strings.Contains(element, separator) {
+			return "", fmt.Errorf("%q could not be encoded in legacy volume id since it contains %q", element, separator)
+		}
+	}
+	return strings.Join(idElements, separator), nil
+}
+
+// Given a CSI volume id, return a smbVolume
+// sample volume Id:
+//
+//	v2:ondelete=retain&source=smb-server%2Fshare&subdir=pvc-4729891a&uuid=pvc-4729891a
+//	smb-server.default.svc.cluster.local/share#pvc-4729891a-f57e-4982-9c60-e9884af1be2f
+//	smb-server.default.svc.cluster.local/share#subdir#pvc-4729891a-f57e-4982-9c60-e9884af1be2f
+//	smb-server.default.svc.cluster.local/pvc-4729891a-f57e-4982-9c60-e9884af1be2f##pvc-4729891a-f57e-4982-9c60-e9884af1be2f##pvc-4729891a-f57e-4982-9c60-e9884af1be2f
+func getSmbVolFromID(id string) (*smbVolume, error) {
+	var vol *smbVolume
+	var err error
+	if matches := volumeIDVersionRegex.FindStringSubmatch(id); matches != nil {
+		if version, _ := strconv.Atoi(matches[1]); version != 2 {
+			return nil, fmt.Errorf("unsupported version %s of volume id %q", matches[1], id)
+		}
+		vol, err = parseVolumeIDV2(id)
+	} else {
+		vol, err = parseLegacyVolumeID(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(vol.source, "//") {
+		vol.source = "//" + vol.source
+	}
+	if err := validateVolumeIDFields(vol); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
+
+func parseVolumeIDV2(id string) (*smbVolume, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(id, volumeIDV2Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode volume id %q: %v", id, err)
+	}
+	vol := &smbVolume{id: id}
+	for k := range values {
+		v := values.Get(k)
+		switch k {
+		case idKeySource:
+			vol.source = v
+		case idKeySubDir:
+			vol.subDir = v
+		case idKeyUUID:
+			vol.uuid = v
+		case idKeyOnDelete:
+			vol.onDelete = v
+		case idKeyShare:
+			vol.share = v
+		case idKeyVolumeUser:
+			if err := setVolumeUserFromID(vol, v); err != nil {
+				return nil, err
+			}
+		default:
+			if vol.idAttributes == nil {
+				vol.idAttributes = map[string]string{}
+			}
+			vol.idAttributes[k] = v
+		}
+	}
+	if strings.Trim(vol.source, "/") == "" {
+		return nil, fmt.Errorf("source is missing in volume id %q", id)
+	}
+	return vol, nil
+}
+
+func parseLegacyVolumeID(id string) (*smbVolume, error) {
+	segments := strings.Split(id, separator)
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("could not split %q into server and subDir", id)
+	}
+	vol := &smbVolume{
+		id:     id,
+		source: segments[idSource],
+		subDir: segments[idSubDir],
+	}
+	if len(segments) > idUUID {
+		vol.uuid = segments[idUUID]
+	}
+	if len(segments) > idOnDelete {
+		vol.onDelete = segments[idOnDelete]
+	}
+	if len(segments) > idShare {
+		vol.share = segments[idShare]
+	}
+	if len(segments) > idVolumeUser && segments[idVolumeUser] != "" {
+		if err := setVolumeUserFromID(vol, segments[idVolumeUser]); err != nil {
+			return nil, err
+		}
+	}
+	return vol, nil
+}
+
+// setVolumeUserFromID sets volume user and the namespace of its secret in {namespace}/{user} format
+func setVolumeUserFromID(vol *smbVolume, value string) error {
+	namespace, user, found := strings.Cut(value, "/")
+	if !found || namespace == "" {
+		return fmt.Errorf("could not split %q into secret namespace and volume user", value)
+	}
+	vol.volumeUser = user
+	vol.volumeUserSecretNamespace = namespace
+	return nil
+}
+
+// validateVolumeIDFields checks the fields of vol which are encoded in volume id
+func validateVolumeIDFields(vol *smbVolume) error {
+	if err := validatePath(vol.subDir); err != nil {
+		return fmt.Errorf("invalid subDir %q: %v", vol.subDir, err)
+	}
+	if err := validatePath(vol.uuid); err != nil {
+		return fmt.Errorf("invalid uuid %q: %v", vol.uuid, err)
+	}
+	if vol.share != "" {
+		if err := validateShareName(vol.share); err != nil {
+			return err
+		}
+	}
+	if vol.volumeUser != "" || vol.volumeUserSecretNamespace != "" {
+		if vol.volumeUserSecretNamespace == "" {
+			return fmt.Errorf("secret namespace of volume user %q is missing", vol.volumeUser)
+		}
+		if err := validateVolumeUser(vol.volumeUser); err != nil {
+			return err
+		}
+	}
+	return nil
+}
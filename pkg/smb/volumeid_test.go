@@ -0,0 +1,130 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package smb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetVolumeIDV2(t *testing.T) {
+	tests := []struct {
+		desc     string
+		vol      *smbVolume
+		expected string
+	}{
+		{
+			desc:     "legacy volume id is kept if it could encode the volume",
+			vol:      &smbVolume{source: "//smb-server/share", subDir: "pvc-1", uuid: "pv-1", onDelete: retain},
+			expected: "smb-server/share#pvc-1#pv-1#retain",
+		},
+		{
+			desc:     "subDir contains separator",
+			vol:      &smbVolume{source: "//smb-server/share", subDir: "team#1/pvc-1", uuid: "pv-1", onDelete: "delete"},
+			expected: "v2:source=smb-server%2Fshare&subdir=team%231%2Fpvc-1&uuid=pv-1",
+		},
+		{
+			desc:     "share contains separator",
+			vol:      &smbVolume{source: "//smb-server/data#1", subDir: "pvc-1", uuid: "pv-1", onDelete: archive},
+			expected: "v2:ondelete=archive&source=smb-server%2Fdata%231&subdir=pvc-1&uuid=pv-1",
+		},
+		{
+			desc:     "share per volume",
+			vol:      &smbVolume{source: "//smb-server", subDir: "pvc-1", uuid: "pv-1", share: "pvc-1"},
+			expected: "v2:share=pvc-1&source=smb-server&subdir=pvc-1&uuid=pv-1",
+		},
+		{
+			desc:     "volume user",
+			vol:      &smbVolume{source: "//smb-server/share", subDir: "pvc-1", uuid: "pv-1", volumeUser: "smb-0123456789abcdef", volumeUserSecretNamespace: "kube-system"},
+			expected: "v2:source=smb-server%2Fshare&subdir=pvc-1&user=kube-system%2Fsmb-0123456789abcdef&uuid=pv-1",
+		},
+		{
+			desc: "extra attributes",
+			vol: &smbVolume{source: "//smb-server/share", subDir: "pvc-1", uuid: "pv-1",
+				volumeUser: "smb-0123456789abcdef", volumeUserSecretNamespace: "kube-system", idAttributes: map[string]string{"backend": "samba"}},
+			expected: "v2:backend=samba&source=smb-server%2Fshare&subdir=pvc-1&user=kube-system%2Fsmb-0123456789abcdef&uuid=pv-1",
+		},
+	}
+	for _, test := range tests {
+		id := getVolumeIDFromSmbVol(test.vol)
+		assert.Equal(t, test.expected, id, test.desc)
+
+		vol, err := getSmbVolFromID(id)
+		if !assert.NoError(t, err, test.desc) {
+			continue
+		}
+		assert.Equal(t, test.vol.source, vol.source, test.desc)
+		assert.Equal(t, test.vol.subDir, vol.subDir, test.desc)
+		assert.Equal(t, test.vol.uuid, vol.uuid, test.desc)
+		assert.Equal(t, test.vol.volumeUser, vol.volumeUser, test.desc)
+		assert.Equal(t, test.vol.idAttributes, vol.idAttributes, test.desc)
+	}
+}
+
+func TestGetSmbVolFromVersionedID(t *testing.T) {
+	tests := []struct {
+		desc        string
+		id          string
+		expectedErr bool
+	}{
+		{desc: "versioned volume id", id: "v2:source=smb-server%2Fshare&subdir=pvc-1&uuid=pv-1"},
+		{desc: "unsupported version", id: "v3:source=smb-server%2Fshare", expectedErr: true},
+		{desc: "source is missing", id: "v2:subdir=pvc-1", expectedErr: true},
+		{desc: "invalid encoding", id: "v2:source=smb-server%2", expectedErr: true},
+		{desc: "directory traversal", id: "v2:source=smb-server%2Fshare&subdir=..%2Fpvc-1", expectedErr: true},
+		{desc: "volume user without namespace", id: "v2:source=smb-server%2Fshare&user=smb-0123456789abcdef", expectedErr: true},
+	}
+	for _, test := range tests {
+		_, err := getSmbVolFromID(test.id)
+		if test.expectedErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+	}
+}
+
+func TestEncodeAndDecodeVolumeID(t *testing.T) {
+	info, err := DecodeVolumeID("smb-server/share#pvc-1#pv-1#retain")
+	assert.NoError(t, err)
+	assert.Equal(t, &VolumeIDInfo{Version: 1, Source: "//smb-server/share", SubDir: "pvc-1", UUID: "pv-1", OnDelete: retain}, info)
+
+	info.Version = 2
+	id, err := EncodeVolumeID(info)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2:ondelete=retain&source=smb-server%2Fshare&subdir=pvc-1&uuid=pv-1", id)
+
+	decoded, err := DecodeVolumeID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, info, decoded)
+
+	_, err = EncodeVolumeID(&VolumeIDInfo{Version: 1, Source: "//smb-server/share", SubDir: "a#b"})
+	assert.Error(t, err)
+	_, err = EncodeVolumeID(&VolumeIDInfo{Version: 1, Source: "//smb-server", SubDir: "pvc-1", Share: "pvc-1"})
+	assert.Error(t, err)
+
+	// legacy volume id with share and volume user is still decoded
+	info, err = DecodeVolumeID("smb-server#pvc-1#pv-1##pvc-1#kube-system/smb-0123456789abcdef")
+	assert.NoError(t, err)
+	assert.Equal(t, "pvc-1", info.Share)
+	assert.Equal(t, "smb-0123456789abcdef", info.VolumeUser)
+	_, err = EncodeVolumeID(&VolumeIDInfo{Version: 3, Source: "//smb-server/share"})
+	assert.Error(t, err)
+	_, err = EncodeVolumeID(&VolumeIDInfo{Version: 2})
+	assert.Error(t, err)
+}